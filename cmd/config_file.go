@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golooper/config"
+
+	"github.com/spf13/pflag"
+)
+
+var configFile string
+var profile string
+
+// optionalFlags are the flags whose config field stays nil, meaning the model default, unless they are set
+var optionalFlags = map[string]bool{
+	"a": true, "N": true, "sigma": true, "minlength": true, "maxlength": true, "max-loops": true,
+	"reverse": true, "complement": true, "unconstrained": true, "homopolymer": true, "g4-bonus": true,
+}
+
+// fileOnlyFlags are the flags that select a config file and cannot themselves be set from one
+var fileOnlyFlags = map[string]bool{"config": true, "profile": true}
+
+// runFlags are the flags that describe one run rather than the configuration, which writeConfigYAML leaves
+// out so that a written config file does not resume every run that reads it
+var runFlags = map[string]bool{"resume": true}
+
+// EnvPrefix starts the environment variable of every flag: --sigma is GOLOOPER_SIGMA and --track-name is
+// GOLOOPER_TRACK_NAME
+const EnvPrefix = "GOLOOPER_"
+
+// envName returns the environment variable that sets the flag called name
+func envName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// sources records where each flag that was set got its value; flags missing from it have their default
+var sources = map[string]string{}
+
+// valueSource describes where the flag called name got its value
+func valueSource(name string) string {
+	if source, ok := sources[name]; ok {
+		return source
+	}
+	if optionalFlags[name] {
+		return "model default"
+	}
+	return "default"
+}
+
+// applySettings fills in the flags that were not given on the command line, first from the GOLOOPER_*
+// environment and then from the config file and profile, so that flags override the environment, the
+// environment overrides the file and the file overrides the defaults. the config file and profile can
+// themselves come from GOLOOPER_CONFIG and GOLOOPER_PROFILE.
+func applySettings(flags *pflag.FlagSet) error {
+	var errs []error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Changed {
+			sources[f.Name] = "flag"
+			return
+		}
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		if err := flags.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Errorf("error in environment variable %s: %v", envName(f.Name), err))
+			return
+		}
+		sources[f.Name] = "env " + envName(f.Name)
+	})
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return applyConfigFile(flags)
+}
+
+// applyConfigFile sets every flag that is still unset from the config file and profile, the profile
+// overriding the rest of the file
+func applyConfigFile(flags *pflag.FlagSet) error {
+	if configFile == "" && profile == "" {
+		return nil
+	}
+	file := &config.File{}
+	if configFile != "" {
+		var err error
+		if file, err = config.ReadFile(configFile); err != nil {
+			return err
+		}
+	}
+	settings, fileSources, err := file.Resolve(profile)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		flag := flags.Lookup(key)
+		if flag == nil || fileOnlyFlags[key] {
+			return fmt.Errorf("unknown setting %q in config file (settings are named after the long flags, e.g. sigma or minlength)", key)
+		}
+		if flag.Changed {
+			continue
+		}
+		if err := flags.Set(key, settings[key]); err != nil {
+			return fmt.Errorf("error in config file setting %s: %v", key, err)
+		}
+		sources[key] = fileSources[key]
+	}
+	return nil
+}
+
+// writeSources prints where every setting got its value
+func writeSources(w io.Writer, flags *pflag.FlagSet) {
+	flags.VisitAll(func(f *pflag.Flag) {
+		fmt.Fprintf(w, "  --%s: %s\n", f.Name, valueSource(f.Name))
+	})
+}
+
+// writeConfigYAML writes the current settings as a config file that --config reads back. optional flags that
+// were not set are left out so that they keep meaning the model default, and so is --resume.
+func writeConfigYAML(w io.Writer, flags *pflag.FlagSet) error {
+	if _, err := fmt.Fprintln(w, "# golooper configuration, read back with --config"); err != nil {
+		return err
+	}
+	var err error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if err != nil || fileOnlyFlags[f.Name] || runFlags[f.Name] || (optionalFlags[f.Name] && !f.Changed) {
+			return
+		}
+		value := f.Value.String()
+		if f.Value.Type() == "string" {
+			if value == "" && (f.Name == "input" || f.Name == "output") {
+				return
+			}
+			value = strconv.Quote(value)
+		}
+		if source, ok := sources[f.Name]; ok {
+			_, err = fmt.Fprintf(w, "%s: %s # %s\n", f.Name, value, source)
+		} else {
+			_, err = fmt.Fprintf(w, "%s: %s\n", f.Name, value)
+		}
+	})
+	return err
+}
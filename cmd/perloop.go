@@ -14,7 +14,16 @@ var perloopCmd = &cobra.Command{
 	Short: "Run perloop analysis on nucleic acid sequences",
 	Long: `Run perloop analysis on nucleic acid sequences to analyze R-loop formation
 and energetics. This command takes an input file containing sequence data and
-generates output at the specified path.`,
+generates output at the specified path.
+
+Every record of a multi-record FASTA file is analyzed. Genes are computed side by
+side, sharing the --threads budget with the windows within each gene, and are
+written to the outputs in the order of the input.
+
+A run that is stopped keeps the outputs of the genes it finished, with a
+_checkpoint.json file recording them, and --resume continues it with the same
+settings. Runs with bigwig, json or parquet outputs, or a binary or parquet
+dump, cannot be resumed and remove their outputs instead.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Validate required flags
 		if cfg.InfileName == "" {
@@ -27,7 +36,7 @@ generates output at the specified path.`,
 		}
 
 		// Run the simulation
-		if err := sim.SimulationA(&cfg); err != nil {
+		if err := sim.SimulationA(cmd.Context(), &cfg); err != nil {
 			fmt.Printf("Error running simulation: %v\n", err)
 			os.Exit(1)
 		}
@@ -1,10 +1,18 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
 	"strconv"
+	"syscall"
 
 	"golooper/config"
+	"golooper/rlooper"
+	"golooper/sim"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -18,10 +26,13 @@ var nucleationFreeEnergy float64
 var superhelicityDomain string
 var superhelicalDensity float64
 var minRLoopLength int
+var maxRLoopLength int
+var maxLoops int
 var reverse bool
 var complement bool
 var unconstrained bool
 var homopolymer float64
+var g4Bonus float64
 var infilename string
 var outfilename string
 
@@ -29,29 +40,48 @@ var rootCmd = &cobra.Command{
 	Use:   "golooper [subcommand] [input_file] [output_path] [options]",
 	Short: "golooper is a CLI application for running biophysical simulations on nucleic acid energetics.",
 	Long: `Go Looper is a CLI application that provides various commands
-for running biophysical simulations on nucleic acid energetics with an emphasis on R-loops (genomic DNA/RNA hybrids).`,
-	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+for running biophysical simulations on nucleic acid energetics with an emphasis on R-loops (genomic DNA/RNA hybrids).
+
+Every setting can be given as a flag, as a GOLOOPER_* environment variable named after the long flag
+(GOLOOPER_SIGMA, GOLOOPER_TRACK_NAME, ...), or in a --config file. Flags override the environment, the
+environment overrides the config file, and the config file overrides the defaults.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// the environment and config file fill in the flags that were not given, after which they count as
+		// set. the persistent flags hold every setting whichever command runs.
+		flags := cmd.Root().PersistentFlags()
+		if err := applySettings(flags); err != nil {
+			return err
+		}
+		if !commandsWithoutInput[cmd.Name()] && (infilename == "" || outfilename == "") {
+			return fmt.Errorf(`required flag(s) "input", "output" not set (give them on the command line or in the config file)`)
+		}
 		cfg.InfileName = infilename
 		cfg.OutfileName = outfilename
 
 		// Set the pointers in the config only if the flags are set
-		cmd.Flags().Visit(func(f *pflag.Flag) {
+		flags.VisitAll(func(f *pflag.Flag) {
+			if !f.Changed {
+				return
+			}
 			switch f.Name {
 			case "a":
 				cfg.NucleationFreeEnergy = &nucleationFreeEnergy
 			case "N":
+				// a value that does not parse is left to Validate to report
+				cfg.DomainSizeText = superhelicityDomain
 				if superhelicityDomain == "auto" {
 					cfg.AutoDomainSize = true
-				} else {
-					value, err := strconv.Atoi(superhelicityDomain)
-					if err == nil {
-						cfg.SuperhelicityDomain = &value
-					}
+				} else if value, err := strconv.Atoi(superhelicityDomain); err == nil {
+					cfg.SuperhelicityDomain = &value
 				}
 			case "sigma":
 				cfg.SuperhelicalDensity = &superhelicalDensity
 			case "minlength":
 				cfg.MinRLoopLength = &minRLoopLength
+			case "maxlength":
+				cfg.MaxRLoopLength = &maxRLoopLength
+			case "max-loops":
+				cfg.MaxLoops = &maxLoops
 			case "reverse":
 				cfg.Reverse = &reverse
 			case "complement":
@@ -60,9 +90,39 @@ for running biophysical simulations on nucleic acid energetics with an emphasis
 				cfg.Unconstrained = &unconstrained
 			case "homopolymer":
 				cfg.Homopolymer = &homopolymer
+			case "g4-bonus":
+				cfg.G4Bonus = &g4Bonus
 			}
 		})
+		if commandsWithoutValidation[cmd.Name()] {
+			return nil
+		}
+		return cfg.Validate(sim.ConfigChecks()...)
 	},
+	// main prints the error once; the usage would only bury what the error says to fix
+	SilenceErrors: true,
+	SilenceUsage:  true,
+}
+
+// commandsWithoutInput are the commands that run without --input and --output
+var commandsWithoutInput = map[string]bool{"show-config": true, "version": true, "help": true}
+
+// commandsWithoutValidation are the commands that do not use the configuration
+var commandsWithoutValidation = map[string]bool{"version": true, "help": true}
+
+// showConfigFormat is the layout show-config prints: text for reading or yaml for --config
+var showConfigFormat string
+
+// supercoilingName describes the sign of a superhelical density
+func supercoilingName(sigma float64) string {
+	switch {
+	case sigma < 0:
+		return "negative supercoiling, underwound"
+	case sigma > 0:
+		return "positive supercoiling, overwound"
+	default:
+		return "relaxed"
+	}
 }
 
 // GetConfig returns the current configuration
@@ -70,22 +130,47 @@ func GetConfig() config.Config {
 	return cfg
 }
 
-// Execute adds all child commands to the root command and sets flags appropriately.
+// Execute adds all child commands to the root command and sets flags appropriately. SIGINT or SIGTERM
+// cancels the context of the running command so that it can stop and clean up; a second signal exits at
+// once.
 func Execute() error {
-	return rootCmd.Execute()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	interrupted := context.AfterFunc(ctx, func() {
+		stop()
+		log.Println("WARN: stopping, press Ctrl-C again to exit at once")
+	})
+	defer interrupted()
+	return rootCmd.ExecuteContext(ctx)
 }
 
 func init() {
 	initFlags()
 
-	rootCmd.AddCommand(&cobra.Command{
+	showConfigCmd := &cobra.Command{
 		Use:   "show-config",
 		Short: "Display the current configuration values",
 		Long: `Display the current configuration values that will be used for the simulation.
-Values not explicitly set via command line flags will use the model's default values.`,
-		Run: func(cmd *cobra.Command, args []string) {
+Values not explicitly set via command line flags or the config file will use the model's default values.
+With --as yaml the values are printed as a config file that --config reads back.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch showConfigFormat {
+			case "yaml":
+				return writeConfigYAML(os.Stdout, rootCmd.PersistentFlags())
+			case "text":
+			default:
+				return fmt.Errorf("unknown show-config layout %q for --as (expected text or yaml)", showConfigFormat)
+			}
 			fmt.Println("Current Configuration:")
 			fmt.Println("---------------------")
+			if configFile == "" {
+				fmt.Println("Config File (--config): none")
+			} else {
+				fmt.Printf("Config File (--config): %s\n", configFile)
+			}
+			if profile != "" {
+				fmt.Printf("Profile (--profile): %s\n", profile)
+			}
 			if cfg.NucleationFreeEnergy == nil {
 				fmt.Println("Nucleation Free Energy (--a): not set (will use model default)")
 			} else {
@@ -101,13 +186,34 @@ Values not explicitly set via command line flags will use the model's default va
 			if cfg.SuperhelicalDensity == nil {
 				fmt.Println("Superhelical Density (--sigma): not set (will use model default)")
 			} else {
-				fmt.Printf("Superhelical Density (--sigma): %.3f (%.1f%%)\n", *cfg.SuperhelicalDensity, *cfg.SuperhelicalDensity*100)
+				fmt.Printf("Superhelical Density (--sigma): %.3f (%.1f%%, %s)\n", *cfg.SuperhelicalDensity, *cfg.SuperhelicalDensity*100, supercoilingName(*cfg.SuperhelicalDensity))
 			}
 			if cfg.MinRLoopLength == nil {
 				fmt.Println("Minimum R-loop Length (--minlength): not set (will use model default)")
 			} else {
 				fmt.Printf("Minimum R-loop Length (--minlength): %d nucleotides\n", *cfg.MinRLoopLength)
 			}
+			if cfg.MaxRLoopLength == nil {
+				fmt.Println("Maximum R-loop Length (--maxlength): not set (loops may span the whole sequence)")
+			} else {
+				fmt.Printf("Maximum R-loop Length (--maxlength): %d nucleotides\n", *cfg.MaxRLoopLength)
+			}
+			if cfg.LengthPrior == "" {
+				fmt.Println("Loop Length Prior (--length-prior): none")
+			} else {
+				fmt.Printf("Loop Length Prior (--length-prior): %s\n", cfg.LengthPrior)
+			}
+			if cfg.InitiationZone == "" && cfg.InitiationBed == "" {
+				fmt.Println("Initiation Zones (--initiation-zone, --initiation-bed): none (loops may start anywhere)")
+			} else {
+				fmt.Printf("Initiation Zone (--initiation-zone): %s\n", cfg.InitiationZone)
+				fmt.Printf("Initiation Sites (--initiation-bed): %s\n", cfg.InitiationBed)
+			}
+			if cfg.MaxLoops == nil {
+				fmt.Println("Maximum Simultaneous R-loops (--max-loops): not set (single-loop model)")
+			} else {
+				fmt.Printf("Maximum Simultaneous R-loops (--max-loops): %d\n", *cfg.MaxLoops)
+			}
 			if cfg.Reverse == nil {
 				fmt.Println("Reverse Direction (--reverse): not set (will use model default)")
 			} else {
@@ -128,33 +234,116 @@ Values not explicitly set via command line flags will use the model's default va
 			} else {
 				fmt.Printf("Homopolymer Energy (--homopolymer): %.2f Kcal/mol\n", *cfg.Homopolymer)
 			}
+			if cfg.G4Bonus == nil {
+				fmt.Println("G-quadruplex Bonus (--g4-bonus): not set (G4 detection off)")
+			} else {
+				fmt.Printf("G-quadruplex Bonus (--g4-bonus): %.2f Kcal/mol\n", *cfg.G4Bonus)
+			}
+			if cfg.OccupancyTrack == "" {
+				fmt.Println("Occupancy Track (--occupancy): not set (no chromatin penalty)")
+			} else {
+				fmt.Printf("Occupancy Track (--occupancy): %s\n", cfg.OccupancyTrack)
+				fmt.Printf("Occupancy Penalty (--occupancy-penalty): %.2f Kcal/mol per occupied base\n", cfg.OccupancyPenalty)
+			}
+			fmt.Printf("Track Format (--format): %s\n", cfg.Format)
+			if cfg.ChromSizes == "" {
+				fmt.Println("Chromosome Sizes (--chrom-sizes): not set (chromosomes end with the input sequence)")
+			} else {
+				fmt.Printf("Chromosome Sizes (--chrom-sizes): %s\n", cfg.ChromSizes)
+			}
+			fmt.Printf("Wig Span (--wig-span): %d bases\n", cfg.WigSpan)
+			fmt.Printf("Track Name Prefix (--track-name): %s\n", cfg.TrackName)
+			fmt.Printf("Track Description (--track-description): %s\n", cfg.TrackDescription)
+			fmt.Printf("Track Color (--track-color): %s\n", cfg.TrackColor)
+			fmt.Printf("Track View Limits (--track-view-limits): %s\n", cfg.TrackViewLimits)
+			fmt.Printf("BED Format (--bed-format): %s\n", cfg.BedFormat)
+			fmt.Printf("Peak Threshold (--peak-threshold): %.3f\n", cfg.PeakThreshold)
+			fmt.Printf("MFE Peak Margin (--mfe-margin): %.2f Kcal/mol above the gene's lowest minimum free energy\n", cfg.MfeMargin)
+			fmt.Printf("Outputs (--outputs): %s\n", cfg.Outputs)
+			fmt.Printf("Top Structures (--top-structures): %d\n", cfg.TopStructures)
+			fmt.Printf("Engine (--engine): %s\n", cfg.Engine)
+			if cfg.SampleSteps > 0 {
+				fmt.Printf("Sampling Steps (--sample-steps): %d per chain\n", cfg.SampleSteps)
+				fmt.Printf("Sampling Burn-in (--sample-burnin): %d steps\n", cfg.SampleBurnIn)
+				fmt.Printf("Random Seed (--seed): %d\n", cfg.Seed)
+			} else {
+				fmt.Println("Sampling Steps (--sample-steps): not set (exhaustive enumeration)")
+			}
+			if cfg.Threads <= 0 {
+				fmt.Printf("Worker Threads (--threads): one per CPU (%d)\n", runtime.NumCPU())
+			} else {
+				fmt.Printf("Worker Threads (--threads): %d\n", cfg.Threads)
+			}
+			fmt.Printf("Chunk Size (--chunk-size): %d windows\n", cfg.ChunkSize)
+			if cfg.GeneTimeout > 0 {
+				fmt.Printf("Gene Timeout (--gene-timeout): %v\n", cfg.GeneTimeout)
+			} else {
+				fmt.Println("Gene Timeout (--gene-timeout): none")
+			}
+			fmt.Printf("Resume (--resume): %v\n", cfg.Resume)
 			fmt.Printf("Invert Output (--invert): %v\n", cfg.Invert)
 			fmt.Printf("Dump Calculations (--dump): %v\n", cfg.Dump)
+			fmt.Printf("Dump Format (--dump-format): %s\n", cfg.DumpFormat)
 			fmt.Printf("Circular Sequence (--circular): %v\n", cfg.Circular)
 			fmt.Printf("Calculate Residuals (--residuals): %v\n", cfg.Residuals)
 			fmt.Printf("Local Average Energy (--local-average-energy): %v\n", cfg.LocalAverageEnergy)
 			fmt.Println("---------------------")
+			fmt.Println("Value Sources (flag > env > config file > default):")
+			writeSources(os.Stdout, rootCmd.PersistentFlags())
+			fmt.Println("---------------------")
+			return nil
 		},
-	})
-
-	rootCmd.MarkPersistentFlagRequired("input")
-	rootCmd.MarkPersistentFlagRequired("output")
+	}
+	// named --as so that --format still sets the track format shown
+	showConfigCmd.Flags().StringVar(&showConfigFormat, "as", "text", "layout to print: text, or yaml to write a config file")
+	rootCmd.AddCommand(showConfigCmd)
 }
 
 func initFlags() {
 	rootCmd.PersistentFlags().Float64VarP(&nucleationFreeEnergy, "a", "a", 0.0, "nucleation free energy in Kcal/mol")
 	rootCmd.PersistentFlags().StringVarP(&superhelicityDomain, "N", "N", "0", "size of the superhelicity domain in nucleotides (use 'auto' for automatic sizing)")
-	rootCmd.PersistentFlags().Float64VarP(&superhelicalDensity, "sigma", "s", 0.0, "superhelical density as a percentage (e.g., 0.07 for +7%)")
-	rootCmd.PersistentFlags().IntVarP(&minRLoopLength, "minlength", "m", 0, "minimum length of an R-loop in nucleotides")
+	rootCmd.PersistentFlags().Float64VarP(&superhelicalDensity, "sigma", "s", 0.0, "superhelical density as a fraction, negative for underwound DNA (e.g., -0.07 for -7%; use --sigma=-0.07)")
+	rootCmd.PersistentFlags().IntVarP(&minRLoopLength, "minlength", "m", 0, "minimum length of an R-loop in nucleotides, at least 2")
+	rootCmd.PersistentFlags().IntVarP(&maxRLoopLength, "maxlength", "M", 0, "maximum length of an R-loop in nucleotides")
+	rootCmd.PersistentFlags().StringVar(&cfg.LengthPrior, "length-prior", "", "weight loops by length: normal:MEAN:SD or exponential:SCALE")
+	rootCmd.PersistentFlags().StringVar(&cfg.InitiationZone, "initiation-zone", "", "only let loops initiate within K bases of the TSS, written tss:K; loops extend in the direction of transcription")
+	rootCmd.PersistentFlags().StringVar(&cfg.InitiationBed, "initiation-bed", "", "BED file of sites where loops may initiate; loops extend in the direction of transcription")
+	rootCmd.PersistentFlags().IntVar(&maxLoops, "max-loops", 1, "maximum number of simultaneous R-loops sharing the superhelical domain, enumerated exhaustively: about 700 bases for 2 loops, 140 for 3 (use --sample-steps beyond)")
 	rootCmd.PersistentFlags().BoolVarP(&reverse, "reverse", "r", false, "reverse the direction of the simulation")
 	rootCmd.PersistentFlags().BoolVarP(&complement, "complement", "c", false, "use the complement strand for the simulation")
 	rootCmd.PersistentFlags().BoolVarP(&unconstrained, "unconstrained", "u", false, "set the superhelicity modeling to unconstrained")
 	rootCmd.PersistentFlags().BoolVarP(&cfg.Invert, "invert", "i", false, "invert the input sequence")
 	rootCmd.PersistentFlags().BoolVarP(&cfg.Dump, "dump", "d", false, "dump all structures computed by the program to file")
-	rootCmd.PersistentFlags().BoolVarP(&cfg.Circular, "circular", "C", false, "treat sequence as circular")
+	rootCmd.PersistentFlags().StringVar(&cfg.DumpFormat, "dump-format", "tsv", "format of the structure dump: tsv, binary or parquet")
+	rootCmd.PersistentFlags().BoolVarP(&cfg.Circular, "circular", "C", false, "treat sequence as circular; not allowed for records whose header says topology=linear")
 	rootCmd.PersistentFlags().BoolVarP(&cfg.Residuals, "residuals", "R", false, "calculate and output residual superhelicity for each structure")
 	rootCmd.PersistentFlags().BoolVarP(&cfg.LocalAverageEnergy, "local-average-energy", "l", false, "use local average energy for the simulation")
 	rootCmd.PersistentFlags().Float64VarP(&homopolymer, "homopolymer", "H", 0.0, "override base pairing energetics with constant value in Kcal/mol")
+	rootCmd.PersistentFlags().StringVar(&cfg.Format, "format", "wig", "format of the probability, average energy and MFE tracks: wig or bigwig")
+	rootCmd.PersistentFlags().StringVar(&cfg.ChromSizes, "chrom-sizes", "", "chrom.sizes file for bigwig output; without it each chromosome ends with the input sequence")
+	rootCmd.PersistentFlags().IntVar(&cfg.WigSpan, "wig-span", 1, "number of bases each wig value covers (0 is the same as 1); values are averaged over the span")
+	rootCmd.PersistentFlags().StringVar(&cfg.TrackName, "track-name", "", "prefix for the names of output tracks")
+	rootCmd.PersistentFlags().StringVar(&cfg.TrackDescription, "track-description", "", "description written on the track line of output tracks")
+	rootCmd.PersistentFlags().StringVar(&cfg.TrackColor, "track-color", "50,150,255", "r,g,b color of output tracks")
+	rootCmd.PersistentFlags().StringVar(&cfg.TrackViewLimits, "track-view-limits", "", "default view range of output tracks, written lower:upper")
+	rootCmd.PersistentFlags().StringVar(&cfg.BedFormat, "bed-format", "bed6", "layout of the peak BED files: bed6, bed9 (with itemRgb) or narrowpeak")
+	rootCmd.PersistentFlags().Float64Var(&cfg.PeakThreshold, "peak-threshold", 0.1, "base pair probability a base needs to be part of a peak in the probability BED file")
+	rootCmd.PersistentFlags().Float64Var(&cfg.MfeMargin, "mfe-margin", 2, "Kcal/mol above the lowest minimum free energy of a gene that a base's minimum free energy may be to be part of a peak in the MFE BED file")
+	rootCmd.PersistentFlags().StringVar(&cfg.Outputs, "outputs", "all", "comma separated outputs to write: bpprob, avgG, mfe, bpprob-bed, mfe-bed, bedgraph, json, ndjson, parquet, or all (every output but json, ndjson and parquet)")
+	rootCmd.PersistentFlags().IntVar(&cfg.TopStructures, "top-structures", 10, "number of most probable structures written to the json and ndjson outputs")
+	rootCmd.PersistentFlags().StringVar(&cfg.Engine, "engine", "enumerate", "partition function engine: enumerate (every structure) or dp (grouped by loop length)")
+	rootCmd.PersistentFlags().IntVar(&cfg.SampleSteps, "sample-steps", 0, "estimate probabilities by Metropolis sampling with this many steps per chain instead of enumerating")
+	rootCmd.PersistentFlags().IntVar(&cfg.SampleBurnIn, "sample-burnin", 100000, "number of initial sampling steps discarded from each chain")
+	rootCmd.PersistentFlags().Int64Var(&cfg.Seed, "seed", 1, "random seed for sampling, chain i uses seed+i")
+	rootCmd.PersistentFlags().IntVar(&cfg.Threads, "threads", 0, "number of worker threads, shared between genes computed side by side and the windows within each gene (0 for one per CPU)")
+	rootCmd.PersistentFlags().IntVar(&cfg.ChunkSize, "chunk-size", rlooper.DefaultChunkSize, "number of windows a worker thread takes from the queue at a time")
+	rootCmd.PersistentFlags().DurationVar(&cfg.GeneTimeout, "gene-timeout", 0, "skip genes that take longer than this to compute, e.g. 10m (0 for no limit)")
+	rootCmd.PersistentFlags().BoolVar(&cfg.Resume, "resume", false, "continue an interrupted run from its checkpoint, skipping finished genes and appending to its outputs")
+	rootCmd.PersistentFlags().Float64Var(&g4Bonus, "g4-bonus", 0.0, "detect G-quadruplexes on the displaced strand and stabilize loops containing one by this many Kcal/mol")
+	rootCmd.PersistentFlags().StringVar(&cfg.OccupancyTrack, "occupancy", "", "wig or bedGraph of nucleosome/protein occupancy (0 free to 1 occupied) that penalizes loops covering occupied bases")
+	rootCmd.PersistentFlags().Float64Var(&cfg.OccupancyPenalty, "occupancy-penalty", 1.0, "penalty in Kcal/mol for each fully occupied base covered by a loop")
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "YAML or TOML config file whose settings, named after the long flags, apply where no flag is given")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "named profile from the config file or built in (genomic-default, plasmid-negative) laid over the config file")
 	rootCmd.PersistentFlags().StringVarP(&infilename, "input", "f", "", "input file name (required)")
 	rootCmd.PersistentFlags().StringVarP(&outfilename, "output", "o", "", "output file name (required)")
 }
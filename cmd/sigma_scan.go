@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"golooper/sim"
+
+	"github.com/spf13/cobra"
+)
+
+var sigmaFrom, sigmaTo, sigmaStep float64
+
+var sigmaScanCmd = &cobra.Command{
+	Use:   "sigma-scan",
+	Short: "Report how R-loop probability changes across superhelical densities",
+	Long: `Compute the single-loop ensemble of each input record over a range of superhelical
+densities and report the probability of any R-loop at each one. Negative densities are
+underwound DNA and positive densities are overwound, so the scan shows how R-loop
+formation flips on either side of relaxed DNA (sigma=0). --sigma and --engine are
+ignored, and --sample-steps and --max-loops above 1 are rejected.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if cfg.InfileName == "" {
+			fmt.Println("Error: input file is required")
+			os.Exit(1)
+		}
+
+		sigmas, err := sim.ValueRange(sigmaFrom, sigmaTo, sigmaStep)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		points, err := sim.SigmaScan(cmd.Context(), &cfg, sigmas)
+		if err != nil {
+			fmt.Printf("Error running sigma scan: %v\n", err)
+			os.Exit(1)
+		}
+		if err := sim.WriteSigmaScan(os.Stdout, points); err != nil {
+			fmt.Printf("Error writing sigma scan: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sigmaScanCmd)
+
+	sigmaScanCmd.Flags().Float64Var(&sigmaFrom, "from", -0.1, "first superhelical density of the scan")
+	sigmaScanCmd.Flags().Float64Var(&sigmaTo, "to", 0.1, "last superhelical density of the scan")
+	sigmaScanCmd.Flags().Float64Var(&sigmaStep, "step", 0.01, "increment between superhelical densities")
+}
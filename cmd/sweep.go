@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"golooper/sim"
+
+	"github.com/spf13/cobra"
+)
+
+var sweepSigma, sweepN, sweepA, sweepT string
+
+var sweepCmd = &cobra.Command{
+	Use:   "sweep",
+	Short: "Run the model over every combination of sigma, N, a and temperature",
+	Long: `Compute the single-loop ensemble of each input record over every combination of
+the swept parameters. Each parameter takes a comma separated list of values or a range
+written FROM:TO:STEP; parameters left out keep their value from the other flags. Each
+record's base pairing energies are computed once for all conditions, so --engine does
+not apply, and --sample-steps and --max-loops above 1 are rejected.
+
+Writes a table with one row per record and condition to <output>_sweep.tsv and the
+per-base probabilities of every record under each condition to
+<output>_sweep_<condition>_bpprob.wig.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if cfg.InfileName == "" {
+			fmt.Println("Error: input file is required")
+			os.Exit(1)
+		}
+		if cfg.OutfileName == "" {
+			fmt.Println("Error: output file is required")
+			os.Exit(1)
+		}
+
+		var params sim.SweepParams
+		for _, v := range []struct {
+			flag   string
+			spec   string
+			values *[]float64
+		}{
+			{"sweep-sigma", sweepSigma, &params.Sigmas},
+			{"sweep-N", sweepN, &params.Ns},
+			{"sweep-a", sweepA, &params.Nucleations},
+			{"sweep-T", sweepT, &params.Temperatures},
+		} {
+			values, err := sim.ParseSweepValues(v.spec)
+			if err != nil {
+				fmt.Printf("Error: --%s: %v\n", v.flag, err)
+				os.Exit(1)
+			}
+			*v.values = values
+		}
+
+		if err := sim.Sweep(cmd.Context(), &cfg, params); err != nil {
+			fmt.Printf("Error running sweep: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sweepCmd)
+
+	sweepCmd.Flags().StringVar(&sweepSigma, "sweep-sigma", "", "superhelical densities to sweep, e.g. -0.1:0:0.01")
+	sweepCmd.Flags().StringVar(&sweepN, "sweep-N", "", "superhelicity domain sizes in nucleotides to sweep, e.g. 1000,1500,3000")
+	sweepCmd.Flags().StringVar(&sweepA, "sweep-a", "", "nucleation free energies in Kcal/mol to sweep")
+	sweepCmd.Flags().StringVar(&sweepT, "sweep-T", "", "temperatures in Kelvin to sweep")
+}
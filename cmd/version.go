@@ -3,6 +3,8 @@ package cmd
 import (
 	"fmt"
 
+	"golooper/sim"
+
 	"github.com/spf13/cobra"
 )
 
@@ -11,7 +13,7 @@ var versionCmd = &cobra.Command{
 	Short: "Print the version number of Looper",
 	Long:  `All software has versions. This is Looper's`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Looper v0.1.0")
+		fmt.Println("Looper v" + sim.Version)
 	},
 }
 
@@ -1,21 +1,53 @@
 package config
 
+import "time"
+
 // Config holds the global configuration values for the application
 type Config struct {
 	NucleationFreeEnergy *float64
 	SuperhelicityDomain  *int
 	AutoDomainSize       bool
+	DomainSizeText       string
 	SuperhelicalDensity  *float64
 	MinRLoopLength       *int
+	MaxRLoopLength       *int
+	LengthPrior          string
+	InitiationZone       string
+	InitiationBed        string
+	G4Bonus              *float64
+	OccupancyTrack       string
+	OccupancyPenalty     float64
+	MaxLoops             *int
 	Reverse              *bool
 	Complement           *bool
 	Unconstrained        *bool
 	Invert               bool
 	Dump                 bool
+	DumpFormat           string
 	Circular             bool
 	Residuals            bool
 	LocalAverageEnergy   bool
 	Homopolymer          *float64
+	Format               string
+	ChromSizes           string
+	WigSpan              int
+	TrackName            string
+	TrackDescription     string
+	TrackColor           string
+	TrackViewLimits      string
+	BedFormat            string
+	Outputs              string
+	TopStructures        int
+	PeakThreshold        float64
+	MfeMargin            float64
+	Engine               string
+	SampleSteps          int
+	SampleBurnIn         int
+	Seed                 int64
+	Threads              int
+	ChunkSize            int
+	GeneTimeout          time.Duration
+	Resume               bool
 	InfileName           string
 	OutfileName          string
 }
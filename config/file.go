@@ -0,0 +1,252 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// File holds the settings read from a config file. keys are the long names of the command line flags, so
+// that `sigma: -0.07` in a file means the same as --sigma=-0.07, and values are kept as the text a flag
+// would be given.
+type File struct {
+	Settings map[string]string
+	Profiles map[string]map[string]string
+}
+
+// ProfileKey is the top-level setting that selects a profile when --profile is not given
+const ProfileKey = "profile"
+
+// BuiltinProfiles are the profiles available without a config file. a profile of the same name in a file
+// replaces the built-in one.
+var BuiltinProfiles = map[string]map[string]string{
+	"genomic-default": {
+		"sigma":    "-0.07",
+		"N":        "1500",
+		"a":        "10",
+		"circular": "false",
+	},
+	"plasmid-negative": {
+		"sigma":    "-0.07",
+		"N":        "auto",
+		"circular": "true",
+	},
+}
+
+func newFile() *File {
+	return &File{Settings: make(map[string]string), Profiles: make(map[string]map[string]string)}
+}
+
+// ReadFile reads a YAML (.yaml, .yml) or TOML (.toml) config file
+func ReadFile(path string) (*File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening config file: %v", err)
+	}
+	defer file.Close()
+
+	var f *File
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		f, err = ParseYAML(file)
+	case ".toml":
+		f, err = ParseTOML(file)
+	default:
+		return nil, fmt.Errorf("unknown config file type %q (expected .yaml, .yml or .toml)", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file %s: %v", path, err)
+	}
+	return f, nil
+}
+
+// Resolve returns the settings of the file with those of the profile laid over them, and where each came
+// from: "config file" or "profile <name>". profile names the profile to apply; when it is empty the profile
+// set in the file, if any, is used.
+func (f *File) Resolve(profile string) (settings, sources map[string]string, err error) {
+	settings = make(map[string]string, len(f.Settings))
+	sources = make(map[string]string, len(f.Settings))
+	for key, value := range f.Settings {
+		if key != ProfileKey {
+			settings[key], sources[key] = value, "config file"
+		}
+	}
+	if profile == "" {
+		profile = f.Settings[ProfileKey]
+	}
+	if profile == "" {
+		return settings, sources, nil
+	}
+	values, ok := f.Profiles[profile]
+	if !ok {
+		values, ok = BuiltinProfiles[profile]
+	}
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown profile %q (available: %s)", profile, strings.Join(f.ProfileNames(), ", "))
+	}
+	for key, value := range values {
+		settings[key], sources[key] = value, "profile "+profile
+	}
+	return settings, sources, nil
+}
+
+// ProfileNames returns the names of the built-in profiles and those defined in the file, sorted
+func (f *File) ProfileNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, profiles := range []map[string]map[string]string{BuiltinProfiles, f.Profiles} {
+		for name := range profiles {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// set stores a setting, or a profile setting when profile is not empty
+func (f *File) set(profile, key, value string) error {
+	settings := f.Settings
+	if profile != "" {
+		if f.Profiles[profile] == nil {
+			f.Profiles[profile] = make(map[string]string)
+		}
+		settings = f.Profiles[profile]
+	}
+	if _, ok := settings[key]; ok {
+		return fmt.Errorf("%s is set twice", key)
+	}
+	settings[key] = value
+	return nil
+}
+
+// ParseYAML reads a YAML config file: a mapping of settings to single values, and a `profiles` mapping of
+// profile names to mappings of settings to single values. scalars are kept as written, so `sigma: -0.070`
+// gives --sigma the text -0.070; lists, nested mappings and aliases are rejected.
+func ParseYAML(r io.Reader) (*File, error) {
+	f := newFile()
+	var doc yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		if err == io.EOF {
+			return f, nil
+		}
+		return nil, err
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: expected settings as key: value pairs", root.Line)
+	}
+	profiles := false
+	for i := 0; i < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value != "profiles" || value.Kind != yaml.MappingNode {
+			if err := setYAML(f, "", key, value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if profiles {
+			return nil, fmt.Errorf("line %d: profiles is set twice", key.Line)
+		}
+		profiles = true
+		for j := 0; j < len(value.Content); j += 2 {
+			name, settings := value.Content[j], value.Content[j+1]
+			if settings.Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("line %d: expected the settings of profile %s as key: value pairs", name.Line, name.Value)
+			}
+			if _, ok := f.Profiles[name.Value]; ok {
+				return nil, fmt.Errorf("line %d: profile %s is defined twice", name.Line, name.Value)
+			}
+			f.Profiles[name.Value] = make(map[string]string)
+			for k := 0; k < len(settings.Content); k += 2 {
+				if err := setYAML(f, name.Value, settings.Content[k], settings.Content[k+1]); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return f, nil
+}
+
+// setYAML stores the setting of a YAML key and value, which must be a scalar
+func setYAML(f *File, profile string, key, value *yaml.Node) error {
+	switch {
+	case value.Kind != yaml.ScalarNode:
+		return fmt.Errorf("line %d: %s must be a single value", value.Line, key.Value)
+	case value.Tag == "!!null":
+		return fmt.Errorf("line %d: %s has no value", key.Line, key.Value)
+	}
+	if err := f.set(profile, key.Value, value.Value); err != nil {
+		if profile != "" {
+			return fmt.Errorf("line %d: profile %s: %v", key.Line, profile, err)
+		}
+		return fmt.Errorf("line %d: %v", key.Line, err)
+	}
+	return nil
+}
+
+// ParseTOML reads a TOML config file: top-level settings with single values, and `[profiles.<name>]`
+// tables of settings with single values. numbers and booleans are given to the flags in their shortest
+// form; arrays, dates and other tables are rejected.
+func ParseTOML(r io.Reader) (*File, error) {
+	var values map[string]any
+	if _, err := toml.NewDecoder(r).Decode(&values); err != nil {
+		return nil, err
+	}
+	f := newFile()
+	for key, value := range values {
+		if key != "profiles" {
+			if _, ok := value.(map[string]any); ok {
+				return nil, fmt.Errorf("unknown table [%s] (expected [profiles.<name>])", key)
+			}
+			if err := setTOML(f, "", key, value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		profiles, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("profiles must be tables [profiles.<name>]")
+		}
+		for name, value := range profiles {
+			settings, ok := value.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("expected the settings of profile %s in a table [profiles.%s]", name, name)
+			}
+			f.Profiles[name] = make(map[string]string)
+			for key, value := range settings {
+				if err := setTOML(f, name, key, value); err != nil {
+					return nil, fmt.Errorf("profile %s: %v", name, err)
+				}
+			}
+		}
+	}
+	return f, nil
+}
+
+// setTOML stores the setting of a TOML key and value, which must be a string, number or boolean
+func setTOML(f *File, profile, key string, value any) error {
+	var text string
+	switch v := value.(type) {
+	case string:
+		text = v
+	case int64:
+		text = strconv.FormatInt(v, 10)
+	case float64:
+		text = strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		text = strconv.FormatBool(v)
+	default:
+		return fmt.Errorf("%s must be a string, number or boolean", key)
+	}
+	return f.set(profile, key, text)
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	f, err := ParseYAML(strings.NewReader(`# run settings
+sigma: -0.070 # comment
+length-prior: "normal:300:100"
+track-name: ""
+track-description: 'it''s # not a comment'
+profile: plasmid
+profiles:
+  plasmid:
+    N: auto
+    circular: true
+  short:
+    maxlength: 200
+`))
+	if err != nil {
+		t.Fatalf("ParseYAML returned error: %v", err)
+	}
+	want := &File{
+		Settings: map[string]string{
+			"sigma": "-0.070", "length-prior": "normal:300:100", "track-name": "",
+			"track-description": "it's # not a comment", "profile": "plasmid",
+		},
+		Profiles: map[string]map[string]string{
+			"plasmid": {"N": "auto", "circular": "true"},
+			"short":   {"maxlength": "200"},
+		},
+	}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("ParseYAML = %+v, want %+v", f, want)
+	}
+
+	for _, bad := range []string{"sigma:\n", "sigma: -0.07\nsigma: -0.05\n", "profiles:\n  a: 1\n", "profiles:\n  a:\n    N: 1\n  a:\n    N: 2\n", "sigma: &s 1\nN: *s\n", "outputs:\n  - bpprob\n", "sigma: [1, 2]\n", "name: \"open\n"} {
+		if _, err := ParseYAML(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	f, err := ParseTOML(strings.NewReader(`sigma = -0.07
+length-prior = "normal:300:100" # comment
+
+[profiles.plasmid]
+N = "auto"
+circular = true
+`))
+	if err != nil {
+		t.Fatalf("ParseTOML returned error: %v", err)
+	}
+	want := &File{
+		Settings: map[string]string{"sigma": "-0.07", "length-prior": "normal:300:100"},
+		Profiles: map[string]map[string]string{"plasmid": {"N": "auto", "circular": "true"}},
+	}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("ParseTOML = %+v, want %+v", f, want)
+	}
+
+	for _, bad := range []string{"[model]\n", "sigma\n", "outputs = [\"bpprob\"]\n", "started = 2024-01-01\n", "[profiles.a.b]\nN = 1\n", "[profiles.a]\n[profiles.a]\n"} {
+		if _, err := ParseTOML(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	f := &File{
+		Settings: map[string]string{"sigma": "-0.05", "minlength": "3", "profile": "mine"},
+		Profiles: map[string]map[string]string{"mine": {"sigma": "-0.06"}},
+	}
+	settings, sources, err := f.Resolve("")
+	if err != nil {
+		t.Fatalf("Resolve returned error: %v", err)
+	}
+	if want := map[string]string{"sigma": "-0.06", "minlength": "3"}; !reflect.DeepEqual(settings, want) {
+		t.Errorf("Resolve with the file's profile = %v, want %v", settings, want)
+	}
+	if want := map[string]string{"sigma": "profile mine", "minlength": "config file"}; !reflect.DeepEqual(sources, want) {
+		t.Errorf("Resolve sources = %v, want %v", sources, want)
+	}
+	settings, _, err = f.Resolve("plasmid-negative")
+	if err != nil {
+		t.Fatalf("Resolve returned error: %v", err)
+	}
+	if settings["circular"] != "true" || settings["minlength"] != "3" {
+		t.Errorf("expected the built-in profile over the file, got %v", settings)
+	}
+	if _, _, err := f.Resolve("missing"); err == nil || !strings.Contains(err.Error(), "genomic-default") {
+		t.Errorf("expected an unknown profile error listing the profiles, got %v", err)
+	}
+}
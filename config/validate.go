@@ -0,0 +1,220 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"golooper/rlooper"
+)
+
+// Problem is one invalid setting, named after its long flag, with a message saying how to fix it
+type Problem struct {
+	Setting string
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Setting == "" {
+		return p.Message
+	}
+	return "--" + p.Setting + ": " + p.Message
+}
+
+// ValidationError lists every problem Validate found
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		lines[i] = "  " + p.String()
+	}
+	return "invalid configuration:\n" + strings.Join(lines, "\n")
+}
+
+// Check is a validation of a setting, named after its long flag, by a package that owns the meaning of the
+// setting, such as the output formats
+type Check struct {
+	Setting string
+	Fn      func(*Config) error
+}
+
+// Validate checks the types and ranges of every setting and combinations of settings that cannot be used
+// together, then runs checks, and returns a *ValidationError listing all problems found, or nil
+func (c *Config) Validate(checks ...Check) error {
+	var problems []Problem
+	add := func(setting, format string, args ...any) {
+		problems = append(problems, Problem{Setting: setting, Message: fmt.Sprintf(format, args...)})
+	}
+	finite := func(setting string, v *float64) {
+		if v != nil && (math.IsNaN(*v) || math.IsInf(*v, 0)) {
+			add(setting, "must be a finite number, got %v", *v)
+		}
+	}
+
+	if c.DomainSizeText != "" && c.DomainSizeText != "auto" {
+		if n, err := strconv.Atoi(c.DomainSizeText); err != nil || n <= 0 {
+			add("N", "must be a positive whole number of nucleotides or auto, got %q", c.DomainSizeText)
+		}
+	} else if c.SuperhelicityDomain != nil && *c.SuperhelicityDomain <= 0 {
+		add("N", "must be a positive whole number of nucleotides, got %d", *c.SuperhelicityDomain)
+	}
+	finite("a", c.NucleationFreeEnergy)
+	finite("homopolymer", c.Homopolymer)
+	finite("g4-bonus", c.G4Bonus)
+
+	minLength := rlooper.DefaultMinLoopLength
+	if c.MinRLoopLength != nil {
+		minLength = *c.MinRLoopLength
+		if minLength < 2 {
+			add("minlength", "must be at least 2 nucleotides, got %d", minLength)
+		}
+	}
+	if c.MaxRLoopLength != nil {
+		if *c.MaxRLoopLength < 1 {
+			add("maxlength", "must be at least 1 nucleotide, got %d", *c.MaxRLoopLength)
+		} else if *c.MaxRLoopLength < minLength {
+			add("maxlength", "%d is shorter than the minimum length %d; raise --maxlength or lower --minlength", *c.MaxRLoopLength, minLength)
+		}
+	}
+	if _, err := rlooper.ParseLengthPrior(c.LengthPrior); err != nil {
+		add("length-prior", "%v", err)
+	}
+	if c.MaxLoops != nil && *c.MaxLoops < 1 {
+		add("max-loops", "must be at least 1, got %d", *c.MaxLoops)
+	}
+	if math.IsNaN(c.OccupancyPenalty) || math.IsInf(c.OccupancyPenalty, 0) {
+		add("occupancy-penalty", "must be a finite number, got %v", c.OccupancyPenalty)
+	}
+
+	switch c.Engine {
+	case "", rlooper.EngineEnumerate, rlooper.EngineDP:
+	default:
+		add("engine", "unknown engine %q (expected %s or %s)", c.Engine, rlooper.EngineEnumerate, rlooper.EngineDP)
+	}
+	if c.SampleSteps < 0 {
+		add("sample-steps", "must not be negative, got %d", c.SampleSteps)
+	}
+	if c.SampleBurnIn < 0 {
+		add("sample-burnin", "must not be negative, got %d", c.SampleBurnIn)
+	}
+	if c.Threads < 0 {
+		add("threads", "must not be negative, got %d", c.Threads)
+	}
+	if c.ChunkSize < 0 {
+		add("chunk-size", "must not be negative, got %d", c.ChunkSize)
+	}
+	if c.GeneTimeout < 0 {
+		add("gene-timeout", "must not be negative, got %v", c.GeneTimeout)
+	}
+	if c.WigSpan < 0 {
+		add("wig-span", "must not be negative (0 is the same as 1 base), got %d", c.WigSpan)
+	}
+	if c.PeakThreshold < 0 || c.PeakThreshold > 1 || math.IsNaN(c.PeakThreshold) {
+		add("peak-threshold", "must be a probability between 0 and 1, got %v", c.PeakThreshold)
+	}
+	if c.MfeMargin < 0 || math.IsNaN(c.MfeMargin) || math.IsInf(c.MfeMargin, 0) {
+		add("mfe-margin", "must be a finite, non-negative energy in Kcal/mol, got %v", c.MfeMargin)
+	}
+	if c.TopStructures < 0 {
+		add("top-structures", "must not be negative, got %d", c.TopStructures)
+	}
+	if c.TrackColor != "" && !validColor(c.TrackColor) {
+		add("track-color", "must be three numbers from 0 to 255 written r,g,b, got %q", c.TrackColor)
+	}
+	if c.TrackViewLimits != "" && !validViewLimits(c.TrackViewLimits) {
+		add("track-view-limits", "must be two numbers written lower:upper with lower below upper, got %q", c.TrackViewLimits)
+	}
+
+	// the engines cannot be combined: sampling replaces the multi-loop ensemble and both ignore --engine
+	multiLoop := c.MaxLoops != nil && *c.MaxLoops > 1
+	if c.SampleSteps > 0 && multiLoop {
+		add("sample-steps", "cannot be combined with --max-loops above 1; sample or enumerate the multi-loop ensemble, not both")
+	}
+	if c.Engine == rlooper.EngineDP && (c.SampleSteps > 0 || multiLoop) {
+		add("engine", "dp applies to the single-loop ensemble and cannot be combined with --sample-steps or --max-loops above 1")
+	}
+
+	for _, file := range []struct{ setting, path string }{
+		{"input", c.InfileName},
+		{"initiation-bed", c.InitiationBed},
+		{"occupancy", c.OccupancyTrack},
+		{"chrom-sizes", c.ChromSizes},
+	} {
+		if file.path == "" {
+			continue
+		}
+		if info, err := os.Stat(file.path); errors.Is(err, fs.ErrNotExist) {
+			add(file.setting, "%s does not exist", file.path)
+		} else if err != nil {
+			add(file.setting, "cannot read %s: %v", file.path, err)
+		} else if info.IsDir() {
+			add(file.setting, "%s is a directory, not a file", file.path)
+		}
+	}
+
+	if c.Circular && c.InfileName != "" {
+		if linear := linearRecords(c.InfileName); len(linear) > 0 {
+			add("circular", "%s marks %s as topology=linear; drop --circular or fix the header", c.InfileName, strings.Join(linear, ", "))
+		}
+	}
+
+	for _, check := range checks {
+		if err := check.Fn(c); err != nil {
+			add(check.Setting, "%v", err)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// validColor reports whether s is an r,g,b color
+func validColor(s string) bool {
+	channels := strings.Split(s, ",")
+	if len(channels) != 3 {
+		return false
+	}
+	for _, c := range channels {
+		v, err := strconv.Atoi(strings.TrimSpace(c))
+		if err != nil || v < 0 || v > 255 {
+			return false
+		}
+	}
+	return true
+}
+
+// validViewLimits reports whether s is a lower:upper view range
+func validViewLimits(s string) bool {
+	lower, upper, ok := strings.Cut(s, ":")
+	if !ok {
+		return false
+	}
+	l, err1 := strconv.ParseFloat(lower, 64)
+	u, err2 := strconv.ParseFloat(upper, 64)
+	return err1 == nil && err2 == nil && l < u
+}
+
+// linearRecords returns the names of the records of the FASTA file at path whose headers mark them
+// topology=linear. a file that cannot be read gives none: the input check reports a missing file, and
+// malformed records are reported when the run reads them.
+func linearRecords(path string) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	linear, err := rlooper.LinearRecords(file)
+	if err != nil {
+		return nil
+	}
+	return linear
+}
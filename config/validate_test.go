@@ -0,0 +1,89 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	if err := (&Config{}).Validate(); err != nil {
+		t.Errorf("expected the zero config to be valid, got %v", err)
+	}
+
+	zero, four, two := 0, 4, 2
+	c := &Config{
+		DomainSizeText: "abc",
+		MinRLoopLength: &four,
+		MaxRLoopLength: &two,
+		MaxLoops:       &two,
+		SampleSteps:    100,
+		Engine:         "fast",
+		PeakThreshold:  2,
+		TrackColor:     "red",
+		InfileName:     "does/not/exist.fa",
+	}
+	err := c.Validate()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+	got := map[string]bool{}
+	for _, p := range verr.Problems {
+		got[p.Setting] = true
+	}
+	for _, setting := range []string{"N", "maxlength", "sample-steps", "engine", "peak-threshold", "track-color", "input"} {
+		if !got[setting] {
+			t.Errorf("expected a problem with --%s in %v", setting, verr.Problems)
+		}
+	}
+	if !strings.Contains(err.Error(), `--N: must be a positive whole number of nucleotides or auto, got "abc"`) {
+		t.Errorf("unexpected message %q", err)
+	}
+
+	c = &Config{MinRLoopLength: &zero}
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "--minlength") {
+		t.Errorf("expected --minlength 0 to be rejected, got %v", err)
+	}
+	one := 1
+	c = &Config{MinRLoopLength: &one}
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "--minlength: must be at least 2") {
+		t.Errorf("expected --minlength 1 to be rejected, got %v", err)
+	}
+	if err := (&Config{WigSpan: -1}).Validate(); err == nil || !strings.Contains(err.Error(), "--wig-span: must not be negative") {
+		t.Errorf("expected --wig-span -1 to be rejected, got %v", err)
+	}
+}
+
+func TestValidateChecks(t *testing.T) {
+	notWig := Check{Setting: "format", Fn: func(c *Config) error {
+		if c.Format != "wig" {
+			return errors.New("not wig")
+		}
+		return nil
+	}}
+	err := (&Config{Format: "bw"}).Validate(notWig)
+	if err == nil || !strings.Contains(err.Error(), "--format: not wig") {
+		t.Errorf("expected the check to report, got %v", err)
+	}
+	if err := (&Config{Format: "wig"}).Validate(notWig); err != nil {
+		t.Errorf("expected the check to pass, got %v", err)
+	}
+}
+
+func TestValidateTopology(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "in.fa")
+	input := ">pUC19 range=pUC19:1-8 topology=circular\nGATTACAG\n>amplicon range=chr1:1-4 topology=linear\nGGCC\n"
+	if err := os.WriteFile(path, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Config{InfileName: path}).Validate(); err != nil {
+		t.Errorf("expected a linear record to be accepted without --circular, got %v", err)
+	}
+	err := (&Config{InfileName: path, Circular: true}).Validate()
+	if err == nil || !strings.Contains(err.Error(), "--circular") || !strings.Contains(err.Error(), "amplicon") {
+		t.Errorf("expected --circular to be rejected naming the linear record, got %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package rlooper
+
+// Engine names accepted by --engine
+const (
+	EngineEnumerate = "enumerate"
+	EngineDP        = "dp"
+)
+
+// EnsembleResult summarizes a single-loop ensemble computed by ComputeEnsembleDP
+type EnsembleResult struct {
+	BpProbability          []float64
+	PartitionFunction      float64
+	GroundStateProbability float64
+}
+
+// LoopProbability returns the probability that any R-loop is present
+func (r *EnsembleResult) LoopProbability() float64 {
+	return 1 - r.GroundStateProbability
+}
+
+// BasePairProbabilitiesDP computes the same per-base probabilities as BasePairProbabilities without
+// building a Structure per window, see ComputeEnsembleDP
+func (g *Gene) BasePairProbabilitiesDP(ec *ExecutionContext, model *ModelParams, wp WindowParams) ([]float64, error) {
+	result, err := g.ComputeEnsembleDP(ec, model, wp)
+	if err != nil {
+		return nil, err
+	}
+	return result.BpProbability, nil
+}
+
+// ComputeEnsembleDP computes the single-loop ensemble grouped by loop length. the superhelical term is
+// computed once per length, and the base pairing energy of each window comes from prefix sums in constant
+// time. coverage is accumulated in difference arrays, making the whole pass O(n·L) for loops up to
+// WindowParams.MaxLength bases.
+func (g *Gene) ComputeEnsembleDP(ec *ExecutionContext, model *ModelParams, wp WindowParams) (*EnsembleResult, error) {
+	n := len(g.Sequence)
+	prefix := model.bpEnergyPrefix(g.Sequence)
+
+	// each block of lengths sums into a slot of its own, added to the totals in length order
+	slots := lengthBlockSlots(ec)
+	slotDiffs := make([][]float64, slots)
+	slotZ := make([]float64, slots)
+	for i := range slotDiffs {
+		slotDiffs[i] = make([]float64, n+1)
+	}
+	diff := make([]float64, n+1)
+	z := model.GroundStateFactor()
+	err := forLengthBlocks(ec, wp.dpMinLength(), wp.maxLength(n), func(slot, length int) {
+		slotZ[slot] += accumulateLength(model, prefix, wp, length, slotDiffs[slot])
+	}, func(slot int) {
+		z += slotZ[slot]
+		for i, v := range slotDiffs[slot] {
+			diff[i] += v
+		}
+		slotZ[slot] = 0
+		clear(slotDiffs[slot])
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newEnsembleResult(model, diff, z), nil
+}
+
+// lengthBlockSize is how many consecutive loop lengths a worker of forLengthBlocks takes at a time
+const lengthBlockSize = 16
+
+// lengthBlock is a block of loop lengths handed to a worker, with the slot its sums go into
+type lengthBlock struct {
+	first int
+	slot  int
+	done  chan struct{}
+}
+
+// lengthBlockSlots returns how many slots of partial sums forLengthBlocks uses with ec
+func lengthBlockSlots(ec *ExecutionContext) int {
+	return 2*max(ec.NumThreads, 1) + 1
+}
+
+// forLengthBlocks runs compute on every loop length from minLength to maxLength, in blocks of
+// lengthBlockSize lengths on the threads of ec, each block summing into one of lengthBlockSlots slots.
+// reduce is called from the calling goroutine with the slot of each block in length order and must clear
+// it for the next block. floating point totals then come out the same whatever the number of threads and
+// whichever block finishes first, unlike sums added as workers finish.
+func forLengthBlocks(ec *ExecutionContext, minLength, maxLength int, compute func(slot, length int), reduce func(slot int)) error {
+	numThreads := max(ec.NumThreads, 1)
+	slots := lengthBlockSlots(ec)
+	free := make(chan int, slots)
+	for slot := 0; slot < slots; slot++ {
+		free <- slot
+	}
+	work := make(chan *lengthBlock)
+	ordered := make(chan *lengthBlock, slots)
+	go func() {
+		defer close(work)
+		defer close(ordered)
+		for first := minLength; first <= maxLength; first += lengthBlockSize {
+			var slot int
+			select {
+			case slot = <-free:
+			case <-ec.done():
+				return
+			}
+			block := &lengthBlock{first: first, slot: slot, done: make(chan struct{})}
+			ordered <- block
+			select {
+			case work <- block:
+			case <-ec.done():
+				return
+			}
+		}
+	}()
+
+	ec.WaitGroup.Add(numThreads)
+	for t := 0; t < numThreads; t++ {
+		go func() {
+			defer ec.WaitGroup.Done()
+			for block := range work {
+				for length := block.first; length < block.first+lengthBlockSize && length <= maxLength; length++ {
+					compute(block.slot, length)
+				}
+				close(block.done)
+			}
+		}()
+	}
+
+reduce:
+	for block := range ordered {
+		select {
+		case <-block.done:
+		case <-ec.done():
+			break reduce
+		}
+		reduce(block.slot)
+		free <- block.slot
+	}
+	ec.WaitGroup.Wait()
+	return ec.Err()
+}
+
+// dpMinLength returns the shortest loop the DP engine considers
+func (wp WindowParams) dpMinLength() int {
+	return max(wp.MinLength, 1)
+}
+
+// accumulateLength adds the coverage of every window of the given length to diff and returns the sum of
+// their weights. prefix holds the base pairing energies from bpEnergyPrefix, so n is len(prefix)-1.
+func accumulateLength(model *ModelParams, prefix []float64, wp WindowParams, length int, diff []float64) float64 {
+	n := len(prefix) - 1
+	superhelical := model.superhelicalEnergy(length)
+	prior := wp.lengthWeight(length)
+	lastStart := n - length
+	if wp.Circular {
+		lastStart = n - 1
+	}
+	z := 0.0
+	for s := 0; s <= lastStart; s++ {
+		w := Window{Start: s, End: (s + length - 1) % n}
+		if !wp.initiates(w) {
+			continue
+		}
+		energy := superhelical + windowBpEnergy(prefix, w) + wp.adjustmentEnergy(n, w)
+		weight := prior * computeBoltzmannFactor(energy, model.T)
+		z += weight
+		addCoverage(diff, w, weight)
+	}
+	return z
+}
+
+// newEnsembleResult normalizes accumulated coverage by z, the partition function including the ground state
+func newEnsembleResult(model *ModelParams, diff []float64, z float64) *EnsembleResult {
+	return &EnsembleResult{
+		BpProbability:          coverageFromDiff(diff, z),
+		PartitionFunction:      z,
+		GroundStateProbability: model.GroundStateFactor() / z,
+	}
+}
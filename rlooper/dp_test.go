@@ -0,0 +1,111 @@
+package rlooper
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBasePairProbabilitiesDP(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	projectRoot := filepath.Dir(wd)
+	genes := []*Gene{
+		NewGene(filepath.Join(projectRoot, "res/gattaca.fa")),
+		{Sequence: []rune("GGGCTTAGCCATTGCGCAATCCGGATTAGCAGGTTTACGCGCATTAGGCCCT")},
+	}
+	model := NewParamsReasonableDefaults()
+	model.SetN(60)
+	ec := &ExecutionContext{
+		NumThreads: 3,
+		WaitGroup:  &sync.WaitGroup{},
+	}
+
+	occupancy := make([]float64, 64)
+	for i := 20; i < 40; i++ {
+		occupancy[i] = 0.75
+	}
+
+	for _, gene := range genes {
+		for _, circular := range []bool{false, true} {
+			for _, wp := range []WindowParams{
+				{MinLength: 1, Circular: circular},
+				{MinLength: 2, Circular: circular},
+				{MinLength: 5, Circular: circular},
+				{MinLength: 3, MaxLength: 12, Circular: circular},
+				{MinLength: 2, Circular: circular, LengthPrior: NormalLengthPrior{Mean: 8, SD: 3}},
+				{MinLength: 2, Circular: circular, Initiation: InitiationMask(len(gene.Sequence), []Zone{{1, 3}})},
+				{MinLength: 2, Circular: circular, Initiation: InitiationMask(len(gene.Sequence), []Zone{{4, 6}}), InitiateFromEnd: true},
+				{MinLength: 2, Circular: circular, Adjustments: []EnergyAdjustment{G4Bonus{Motifs: []G4{{Start: 2, End: 5}}, Energy: -3}}},
+				{MinLength: 2, Circular: circular, Adjustments: []EnergyAdjustment{NewOccupancyPenalty(occupancy[:len(gene.Sequence)], 0.8)}},
+			} {
+				expected, err := gene.BasePairProbabilities(ec, &model, wp)
+				if err != nil {
+					t.Fatalf("BasePairProbabilities returned error: %v", err)
+				}
+				result, err := gene.BasePairProbabilitiesDP(ec, &model, wp)
+				if err != nil {
+					t.Fatalf("BasePairProbabilitiesDP returned error: %v", err)
+				}
+				for i := range expected {
+					if math.Abs(result[i]-expected[i]) > 1e-9*math.Max(1, expected[i]) {
+						t.Errorf("len=%d %+v: probability at %d = %v, enumerative engine gives %v",
+							len(gene.Sequence), wp, i, result[i], expected[i])
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestComputeEnsembleDPSupercoilingSign(t *testing.T) {
+	gene := &Gene{Sequence: []rune("GGGCTTAGCCATTGCGCAATCCGGATTAGCAGGTTTACGCGCATTAGGCCCT")}
+	model := NewParamsReasonableDefaults()
+	model.SetN(200)
+	ec := &ExecutionContext{
+		NumThreads: 2,
+		WaitGroup:  &sync.WaitGroup{},
+	}
+
+	loopProbability := func(sigma float64) float64 {
+		model.SetSuperhelicity(sigma)
+		result, err := gene.ComputeEnsembleDP(ec, &model, WindowParams{MinLength: 2})
+		if err != nil {
+			t.Fatalf("ComputeEnsembleDP returned error: %v", err)
+		}
+		return result.LoopProbability()
+	}
+
+	underwound, relaxed, overwound := loopProbability(-0.05), loopProbability(0), loopProbability(0.05)
+	if !(underwound > relaxed && relaxed > overwound) {
+		t.Errorf("expected P(loop) to fall from underwound to overwound DNA, got %v, %v, %v", underwound, relaxed, overwound)
+	}
+}
+
+func TestComputeEnsembleDPThreads(t *testing.T) {
+	// lengths are summed in a fixed order, so the result is the same to the last bit on any number of threads
+	gene := &Gene{Sequence: []rune(strings.Repeat("GGGCTTAGCCATTGCGCAATCCGGATTAGCAGGTTTACGCGCATTAGGCCCT", 6))}
+	model := NewParamsReasonableDefaults()
+	wp := WindowParams{MinLength: 2}
+	var first *EnsembleResult
+	for _, threads := range []int{1, 2, 4, 7} {
+		ec := &ExecutionContext{NumThreads: threads, WaitGroup: &sync.WaitGroup{}}
+		result, err := gene.ComputeEnsembleDP(ec, &model, wp)
+		if err != nil {
+			t.Fatalf("ComputeEnsembleDP returned error: %v", err)
+		}
+		if first == nil {
+			first = result
+			continue
+		}
+		if !reflect.DeepEqual(result, first) {
+			t.Errorf("%d threads gave a different result than 1 thread", threads)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package rlooper
+
+import "math"
+
+// EnergyTracks holds per-base summaries of the free energy of the loops covering each base. bases that no
+// loop can cover are NaN.
+type EnergyTracks struct {
+	AverageEnergy []float64 // Boltzmann weighted mean free energy of the loops covering the base
+	MinFreeEnergy []float64 // lowest free energy of any loop covering the base
+}
+
+// energyAggregate summarizes a set of windows: their lowest free energy and their Boltzmann weighted sums
+type energyAggregate struct {
+	min      float64
+	weight   float64
+	weighted float64 // sum of weight * free energy
+}
+
+var emptyEnergyAggregate = energyAggregate{min: math.Inf(1)}
+
+func (a energyAggregate) combine(b energyAggregate) energyAggregate {
+	return energyAggregate{min: math.Min(a.min, b.min), weight: a.weight + b.weight, weighted: a.weighted + b.weighted}
+}
+
+// slidingAggregate is a queue of window aggregates that reports the aggregate of its contents without ever
+// subtracting, which would cancel catastrophically when weights span many orders of magnitude. it is a pair
+// of stacks: values are pushed onto in, and moved onto out, oldest on top, when the front is popped.
+type slidingAggregate struct {
+	in    []energyAggregate
+	inAgg energyAggregate
+	out   []energyAggregate // each entry aggregates itself and every entry below it
+}
+
+func (q *slidingAggregate) reset() {
+	q.in, q.out, q.inAgg = q.in[:0], q.out[:0], emptyEnergyAggregate
+}
+
+func (q *slidingAggregate) push(v energyAggregate) {
+	q.in = append(q.in, v)
+	q.inAgg = q.inAgg.combine(v)
+}
+
+func (q *slidingAggregate) pop() {
+	if len(q.out) == 0 {
+		agg := emptyEnergyAggregate
+		for i := len(q.in) - 1; i >= 0; i-- {
+			agg = agg.combine(q.in[i])
+			q.out = append(q.out, agg)
+		}
+		q.in, q.inAgg = q.in[:0], emptyEnergyAggregate
+	}
+	q.out = q.out[:len(q.out)-1]
+}
+
+func (q *slidingAggregate) total() energyAggregate {
+	if len(q.out) == 0 {
+		return q.inAgg
+	}
+	return q.inAgg.combine(q.out[len(q.out)-1])
+}
+
+// energyAccumulator holds one worker's per-base sums for ComputeEnergyTracks
+type energyAccumulator struct {
+	covering []energyAggregate // aggregate of every window covering each base
+	windows  []energyAggregate // the window starting at each base for the current length
+	queue    slidingAggregate
+}
+
+func newEnergyAccumulator(n int) *energyAccumulator {
+	acc := &energyAccumulator{
+		covering: make([]energyAggregate, n),
+		windows:  make([]energyAggregate, n),
+	}
+	acc.clear()
+	return acc
+}
+
+// clear empties the sums so that the accumulator can be used again
+func (acc *energyAccumulator) clear() {
+	for i := range acc.covering {
+		acc.covering[i] = emptyEnergyAggregate
+	}
+}
+
+// addLength accumulates every window of the given length. base i is covered by the windows starting at
+// i-length+1 through i, so a queue sliding over window starts gives each base its aggregate in O(n).
+func (acc *energyAccumulator) addLength(model *ModelParams, prefix []float64, wp WindowParams, length int) {
+	n := len(acc.windows)
+	superhelical := model.superhelicalEnergy(length)
+	prior := wp.lengthWeight(length)
+	lastStart := n - length
+	if wp.Circular {
+		lastStart = n - 1
+	}
+	for s := range acc.windows {
+		acc.windows[s] = emptyEnergyAggregate
+		if s > lastStart {
+			continue
+		}
+		w := Window{Start: s, End: (s + length - 1) % n}
+		if !wp.initiates(w) {
+			continue
+		}
+		energy := superhelical + windowBpEnergy(prefix, w) + wp.adjustmentEnergy(n, w)
+		weight := prior * computeBoltzmannFactor(energy, model.T)
+		acc.windows[s] = energyAggregate{min: energy, weight: weight, weighted: weight * energy}
+	}
+
+	// in a circular sequence the negative starts wrap around to the end
+	acc.queue.reset()
+	for s := -(length - 1); s < n; s++ {
+		switch {
+		case s >= 0:
+			acc.queue.push(acc.windows[s])
+		case wp.Circular:
+			acc.queue.push(acc.windows[s+n])
+		default:
+			acc.queue.push(emptyEnergyAggregate)
+		}
+		if s < 0 {
+			continue
+		}
+		if s > 0 {
+			acc.queue.pop()
+		}
+		acc.covering[s] = acc.covering[s].combine(acc.queue.total())
+	}
+}
+
+// merge adds other's sums into acc
+func (acc *energyAccumulator) merge(other *energyAccumulator) {
+	for i := range acc.covering {
+		acc.covering[i] = acc.covering[i].combine(other.covering[i])
+	}
+}
+
+// ComputeEnergyTracks computes the average and minimum free energy of the loops covering each base, over
+// the same windows, energies and length prior as ComputeEnsembleDP. the average is conditional on the
+// base being in a loop, so it does not depend on the ground state.
+func (g *Gene) ComputeEnergyTracks(ec *ExecutionContext, model *ModelParams, wp WindowParams) (*EnergyTracks, error) {
+	n := len(g.Sequence)
+	prefix := model.bpEnergyPrefix(g.Sequence)
+	total := newEnergyAccumulator(n)
+	// each block of lengths accumulates into a slot of its own, merged into the total in length order
+	accumulators := make([]*energyAccumulator, lengthBlockSlots(ec))
+	for i := range accumulators {
+		accumulators[i] = newEnergyAccumulator(n)
+	}
+	err := forLengthBlocks(ec, wp.dpMinLength(), wp.maxLength(n), func(slot, length int) {
+		accumulators[slot].addLength(model, prefix, wp, length)
+	}, func(slot int) {
+		total.merge(accumulators[slot])
+		accumulators[slot].clear()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := &EnergyTracks{
+		AverageEnergy: make([]float64, n),
+		MinFreeEnergy: make([]float64, n),
+	}
+	for i, agg := range total.covering {
+		if math.IsInf(agg.min, 1) {
+			tracks.AverageEnergy[i], tracks.MinFreeEnergy[i] = math.NaN(), math.NaN()
+			continue
+		}
+		tracks.MinFreeEnergy[i] = agg.min
+		tracks.AverageEnergy[i] = math.NaN()
+		if agg.weight > 0 {
+			tracks.AverageEnergy[i] = agg.weighted / agg.weight
+		}
+	}
+	return tracks, nil
+}
@@ -0,0 +1,92 @@
+package rlooper
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestComputeEnergyTracks(t *testing.T) {
+	gene := &Gene{Sequence: []rune("GGGCTTAGCCATTGCGCAATCCGGATTAGCAGGTTTACGCGCATTAGGCCCT")}
+	n := len(gene.Sequence)
+	model := NewParamsReasonableDefaults()
+	model.SetN(60)
+	ec := &ExecutionContext{
+		NumThreads: 3,
+		WaitGroup:  &sync.WaitGroup{},
+	}
+
+	for _, circular := range []bool{false, true} {
+		for _, wp := range []WindowParams{
+			{MinLength: 2, Circular: circular},
+			{MinLength: 3, MaxLength: 12, Circular: circular},
+			{MinLength: 2, Circular: circular, LengthPrior: NormalLengthPrior{Mean: 8, SD: 3}},
+			{MinLength: 2, Circular: circular, Initiation: InitiationMask(n, []Zone{{10, 12}})},
+		} {
+			tracks, err := gene.ComputeEnergyTracks(ec, &model, wp)
+			if err != nil {
+				t.Fatalf("ComputeEnergyTracks returned error: %v", err)
+			}
+
+			// brute force over every structure
+			weighted := make([]float64, n)
+			weights := make([]float64, n)
+			mfe := make([]float64, n)
+			for i := range mfe {
+				mfe[i] = math.Inf(1)
+			}
+			for _, w := range wp.Windows(gene.Sequence) {
+				s := gene.computeStructure(&model, wp, w)
+				for k := 0; k < s.Length; k++ {
+					i := (w.Start + k) % n
+					weighted[i] += s.BoltzmannFactor * s.FreeEnergy
+					weights[i] += s.BoltzmannFactor
+					mfe[i] = math.Min(mfe[i], s.FreeEnergy)
+				}
+			}
+
+			for i := 0; i < n; i++ {
+				if math.IsInf(mfe[i], 1) {
+					if !math.IsNaN(tracks.MinFreeEnergy[i]) || !math.IsNaN(tracks.AverageEnergy[i]) {
+						t.Errorf("circular=%v %+v: base %d is never covered but got %v, %v", circular, wp, i, tracks.AverageEnergy[i], tracks.MinFreeEnergy[i])
+					}
+					continue
+				}
+				if math.Abs(tracks.MinFreeEnergy[i]-mfe[i]) > 1e-9 {
+					t.Errorf("circular=%v %+v: base %d MFE %v, want %v", circular, wp, i, tracks.MinFreeEnergy[i], mfe[i])
+				}
+				if avg := weighted[i] / weights[i]; math.Abs(tracks.AverageEnergy[i]-avg) > 1e-6*math.Max(1, math.Abs(avg)) {
+					t.Errorf("circular=%v %+v: base %d average energy %v, want %v", circular, wp, i, tracks.AverageEnergy[i], avg)
+				}
+			}
+		}
+	}
+}
+
+func TestComputeEnergyTracksThreads(t *testing.T) {
+	// the per-block sums are merged in length order, so the tracks are the same to the last bit on any
+	// number of threads
+	gene := &Gene{Sequence: []rune(strings.Repeat("GGGCTTAGCCATTGCGCAATCCGGATTAGCAGGTTTACGCGCATTAGGCCCT", 6))}
+	model := NewParamsReasonableDefaults()
+	wp := WindowParams{MinLength: 2}
+	var first *EnergyTracks
+	for _, threads := range []int{1, 2, 4, 7} {
+		ec := &ExecutionContext{NumThreads: threads, WaitGroup: &sync.WaitGroup{}}
+		tracks, err := gene.ComputeEnergyTracks(ec, &model, wp)
+		if err != nil {
+			t.Fatalf("ComputeEnergyTracks returned error: %v", err)
+		}
+		if first == nil {
+			first = tracks
+			continue
+		}
+		for i := range tracks.AverageEnergy {
+			if math.Float64bits(tracks.AverageEnergy[i]) != math.Float64bits(first.AverageEnergy[i]) ||
+				math.Float64bits(tracks.MinFreeEnergy[i]) != math.Float64bits(first.MinFreeEnergy[i]) {
+				t.Fatalf("%d threads: base %d has %v, %v, 1 thread gives %v, %v", threads, i,
+					tracks.AverageEnergy[i], tracks.MinFreeEnergy[i], first.AverageEnergy[i], first.MinFreeEnergy[i])
+			}
+		}
+	}
+}
@@ -4,3 +4,215 @@ type ensemble struct {
 	structures        []Structure
 	groundStateEnergy float64
 }
+
+// DefaultMinLoopLength is the minimum R-loop length used when none is configured
+const DefaultMinLoopLength = 2
+
+// StructureSink receives batches of computed structures. Batches are delivered from a single goroutine,
+// so a sink does not need to be safe for concurrent use. Returning an error stops the stream.
+type StructureSink func([]Structure) error
+
+// windowsFrom returns every window allowed by wp beginning at start, linear windows first followed by the
+// windows that cross the circular boundary
+func windowsFrom(seq []rune, start int, wp WindowParams) []Window {
+	var result []Window
+	if wp.Initiation != nil && !wp.InitiateFromEnd && !wp.Initiation[start] {
+		return result
+	}
+	maxLength := wp.maxLength(len(seq))
+	for j := start + wp.MinLength - 1; j < len(seq) && j-start+1 <= maxLength; j++ {
+		if wp.initiates(Window{Start: start, End: j}) {
+			result = append(result, Window{Start: start, End: j})
+		}
+	}
+	if wp.Circular && start > 0 {
+		for j := 0; j < start; j++ {
+			length := (len(seq) - start) + j + 1
+			if length >= wp.MinLength && length <= maxLength && wp.initiates(Window{Start: start, End: j}) {
+				result = append(result, Window{Start: start, End: j})
+			}
+		}
+	}
+	return result
+}
+
+// startBatch is the structures of every window beginning at start
+type startBatch struct {
+	start      int
+	structures []Structure
+}
+
+// streamStructures computes the structure for every window and hands them to sink one start position
+// at a time, in order of start position whatever the number of threads, so sums over the stream come out
+// the same on every run. workers take the next start as they finish the last, and no more than a few
+// batches per worker are computed ahead of the one sink is waiting for, which bounds memory. canceling
+// ec.Context stops the stream with the context's error.
+func (g *Gene) streamStructures(ec *ExecutionContext, model *ModelParams, wp WindowParams, sink StructureSink) error {
+	numThreads := ec.NumThreads
+	if numThreads <= 0 {
+		numThreads = 1
+	}
+
+	starts := make(chan int)
+	batches := make(chan startBatch, numThreads)
+	// a token is taken for every start handed out and returned when its batch reaches sink
+	inFlight := make(chan struct{}, 4*numThreads)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(starts)
+		for i := range g.Sequence {
+			select {
+			case inFlight <- struct{}{}:
+			case <-done:
+				return
+			case <-ec.done():
+				return
+			}
+			select {
+			case starts <- i:
+			case <-done:
+				return
+			case <-ec.done():
+				return
+			}
+		}
+	}()
+
+	ec.WaitGroup.Add(numThreads)
+	for t := 0; t < numThreads; t++ {
+		go func() {
+			defer ec.WaitGroup.Done()
+			for i := range starts {
+				windows := windowsFrom(g.Sequence, i, wp)
+				batch := make([]Structure, len(windows))
+				for k, w := range windows {
+					batch[k] = g.computeStructure(model, wp, w)
+				}
+				select {
+				case batches <- startBatch{i, batch}:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		ec.WaitGroup.Wait()
+		close(batches)
+	}()
+
+	// batches arrive in any order and are held until every earlier start has been delivered. keep draining
+	// after a sink error so that no worker is left blocked on a send
+	var err error
+	pending := make(map[int][]Structure)
+	next := 0
+	for b := range batches {
+		pending[b.start] = b.structures
+		for {
+			batch, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			<-inFlight
+			if err != nil || len(batch) == 0 {
+				continue
+			}
+			if err = sink(batch); err != nil {
+				close(done)
+			}
+		}
+	}
+	if err == nil {
+		err = ec.Err()
+	}
+	return err
+}
+
+// PartitionFunction returns the sum of the Boltzmann factors of the ground state and every structure
+func (g *Gene) PartitionFunction(ec *ExecutionContext, model *ModelParams, wp WindowParams) (float64, error) {
+	z := model.GroundStateFactor()
+	err := g.streamStructures(ec, model, wp, func(batch []Structure) error {
+		for _, s := range batch {
+			z += s.BoltzmannFactor
+		}
+		return nil
+	})
+	return z, err
+}
+
+// StreamEnsemble computes every structure twice: once to find the partition function, and once more to
+// deliver the structures to sink with Probability filled in. Trading compute for memory this way keeps
+// the full ensemble off the heap, which is tens of millions of structures for a 5 kb gene.
+func (g *Gene) StreamEnsemble(ec *ExecutionContext, model *ModelParams, wp WindowParams, sink StructureSink) error {
+	_, err := g.StreamBasePairProbabilities(ec, model, wp, sink)
+	return err
+}
+
+// StreamBasePairProbabilities streams the ensemble to sink as StreamEnsemble does and returns the per-base
+// probabilities BasePairProbabilities gives, summed from the structures as they are delivered, so that
+// writing the structures and finding the probabilities take one pass over them after the partition
+// function
+func (g *Gene) StreamBasePairProbabilities(ec *ExecutionContext, model *ModelParams, wp WindowParams, sink StructureSink) ([]float64, error) {
+	z, err := g.PartitionFunction(ec, model, wp)
+	if err != nil {
+		return nil, err
+	}
+	diff := make([]float64, len(g.Sequence)+1)
+	err = g.streamStructures(ec, model, wp, func(batch []Structure) error {
+		for i := range batch {
+			batch[i].Probability = batch[i].BoltzmannFactor / z
+			addCoverage(diff, Window{int(batch[i].Pos.StartPos), int(batch[i].Pos.EndPos)}, batch[i].BoltzmannFactor)
+		}
+		return sink(batch)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return coverageFromDiff(diff, z), nil
+}
+
+// addCoverage adds v to every base covered by w in the difference array diff, which has one more element
+// than the sequence. windows crossing the circular boundary are split into their two linear pieces.
+func addCoverage(diff []float64, w Window, v float64) {
+	if w.End >= w.Start {
+		diff[w.Start] += v
+		diff[w.End+1] -= v
+		return
+	}
+	diff[w.Start] += v
+	diff[len(diff)-1] -= v
+	diff[0] += v
+	diff[w.End+1] -= v
+}
+
+// coverageFromDiff accumulates a difference array built by addCoverage into per-base values divided by z
+func coverageFromDiff(diff []float64, z float64) []float64 {
+	result := make([]float64, len(diff)-1)
+	running := 0.0
+	for i := range result {
+		running += diff[i]
+		result[i] = running / z
+	}
+	return result
+}
+
+// BasePairProbabilities returns, for every base, the probability that it is inside an R-loop
+func (g *Gene) BasePairProbabilities(ec *ExecutionContext, model *ModelParams, wp WindowParams) ([]float64, error) {
+	diff := make([]float64, len(g.Sequence)+1)
+	z := model.GroundStateFactor()
+	err := g.streamStructures(ec, model, wp, func(batch []Structure) error {
+		for _, s := range batch {
+			z += s.BoltzmannFactor
+			addCoverage(diff, Window{int(s.Pos.StartPos), int(s.Pos.EndPos)}, s.BoltzmannFactor)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return coverageFromDiff(diff, z), nil
+}
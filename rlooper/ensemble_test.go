@@ -0,0 +1,130 @@
+package rlooper
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestStreamEnsemble(t *testing.T) {
+	// Get the absolute path to the project root
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	projectRoot := filepath.Dir(wd)
+	gene := NewGene(filepath.Join(projectRoot, "res/gattaca.fa"))
+	model := NewParamsReasonableDefaults()
+	ec := &ExecutionContext{
+		NumThreads: 3,
+		WaitGroup:  &sync.WaitGroup{},
+	}
+
+	for _, circular := range []bool{false, true} {
+		count := 0
+		total := 0.0
+		err := gene.StreamEnsemble(ec, &model, WindowParams{MinLength: 2, Circular: circular}, func(batch []Structure) error {
+			for _, s := range batch {
+				count++
+				total += s.Probability
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("StreamEnsemble returned error: %v", err)
+		}
+
+		expected := len(gene.computeStructuresSerial(&model, WindowParams{MinLength: 2, Circular: circular}))
+		if count != expected {
+			t.Errorf("circular=%v: expected %d structures, got %d", circular, expected, count)
+		}
+
+		z, _ := gene.PartitionFunction(ec, &model, WindowParams{MinLength: 2, Circular: circular})
+		total += model.GroundStateFactor() / z
+		if math.Abs(total-1) > 1e-9 {
+			t.Errorf("circular=%v: probabilities sum to %v, want 1", circular, total)
+		}
+	}
+}
+
+func TestStreamEnsembleSinkError(t *testing.T) {
+	gene := &Gene{Sequence: []rune("GATTACAGATTACA")}
+	model := NewParamsReasonableDefaults()
+	ec := &ExecutionContext{
+		NumThreads: 4,
+		WaitGroup:  &sync.WaitGroup{},
+	}
+
+	sinkErr := errors.New("sink failed")
+	err := gene.StreamEnsemble(ec, &model, WindowParams{MinLength: 2}, func(batch []Structure) error {
+		return sinkErr
+	})
+	if !errors.Is(err, sinkErr) {
+		t.Errorf("expected sink error to be returned, got %v", err)
+	}
+}
+
+func TestStreamBasePairProbabilities(t *testing.T) {
+	gene := &Gene{Sequence: []rune("GATTACAGGGCCCGATTACAGGGAAATTTCCCGGA")}
+	model := NewParamsReasonableDefaults()
+	ec := &ExecutionContext{
+		NumThreads: 3,
+		WaitGroup:  &sync.WaitGroup{},
+	}
+	for _, circular := range []bool{false, true} {
+		wp := WindowParams{MinLength: 2, Circular: circular}
+		count := 0
+		streamed, err := gene.StreamBasePairProbabilities(ec, &model, wp, func(batch []Structure) error {
+			count += len(batch)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("StreamBasePairProbabilities returned error: %v", err)
+		}
+		if expected := len(gene.computeStructuresSerial(&model, wp)); count != expected {
+			t.Errorf("circular=%v: expected %d structures, got %d", circular, expected, count)
+		}
+		// the same sums in the same order, so the probabilities match to the bit
+		probabilities, _ := gene.BasePairProbabilities(ec, &model, wp)
+		for i := range probabilities {
+			if streamed[i] != probabilities[i] {
+				t.Errorf("circular=%v: streamed probability at %d = %v, want %v", circular, i, streamed[i], probabilities[i])
+			}
+		}
+	}
+}
+
+func TestStreamStructuresOrder(t *testing.T) {
+	gene := &Gene{Sequence: []rune("GATTACAGGGCCCGATTACAGGGAAATTTCCCGGA")}
+	model := NewParamsReasonableDefaults()
+	wp := WindowParams{MinLength: 2, Circular: true}
+	var serial []Structure
+	for start := range gene.Sequence {
+		for _, w := range windowsFrom(gene.Sequence, start, wp) {
+			serial = append(serial, gene.computeStructure(&model, wp, w))
+		}
+	}
+
+	for _, threads := range []int{1, 3, 8} {
+		ec := &ExecutionContext{NumThreads: threads, WaitGroup: &sync.WaitGroup{}}
+		var streamed []Structure
+		err := gene.streamStructures(ec, &model, wp, func(batch []Structure) error {
+			streamed = append(streamed, batch...)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("streamStructures returned error: %v", err)
+		}
+		if len(streamed) != len(serial) {
+			t.Fatalf("%d threads: expected %d structures, got %d", threads, len(serial), len(streamed))
+		}
+		for i := range serial {
+			if streamed[i].Pos != serial[i].Pos || streamed[i].BoltzmannFactor != serial[i].BoltzmannFactor {
+				t.Fatalf("%d threads: structure %d is %+v, want %+v", threads, i, streamed[i].Pos, serial[i].Pos)
+			}
+		}
+	}
+}
@@ -1,10 +1,105 @@
 package rlooper
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
+
+// DefaultChunkSize is the number of windows a worker takes at a time when ExecutionContext.ChunkSize is unset
+const DefaultChunkSize = 256
 
 type ExecutionContext struct {
 	NumThreads int
+	ChunkSize  int // windows a worker takes from the queue at a time
 	WaitGroup  *sync.WaitGroup
+	// Context stops the work when it is canceled, which then returns the context's error. nil never
+	// cancels.
+	Context context.Context
+
+	budgetOnce sync.Once
+	budget     *threadBudget // threads not reserved by a child context
+	parent     *ExecutionContext
+}
+
+// chunkSize returns the number of windows a worker takes at a time
+func (ec *ExecutionContext) chunkSize() int {
+	if ec.ChunkSize <= 0 {
+		return DefaultChunkSize
+	}
+	return ec.ChunkSize
+}
+
+// done returns a channel closed when the work is to stop, or nil, which never is
+func (ec *ExecutionContext) done() <-chan struct{} {
+	if ec.Context == nil {
+		return nil
+	}
+	return ec.Context.Done()
+}
+
+// Err returns the error of a canceled Context, or nil while the work may go on
+func (ec *ExecutionContext) Err() error {
+	if ec.Context == nil {
+		return nil
+	}
+	return ec.Context.Err()
+}
+
+// threadBudget counts the threads of an ExecutionContext that are free to be reserved
+type threadBudget struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	free int
+}
+
+func (ec *ExecutionContext) threads() *threadBudget {
+	ec.budgetOnce.Do(func() {
+		ec.budget = &threadBudget{free: max(ec.NumThreads, 1)}
+		ec.budget.cond = sync.NewCond(&ec.budget.mu)
+	})
+	return ec.budget
+}
+
+// Reserve blocks until n of the threads of ec are free and returns a context that runs on them, so that
+// genes computed side by side share one budget rather than each starting NumThreads workers. n is
+// clamped to between 1 and NumThreads. the returned context shares the Context of ec and must be
+// released with Release once its work is done. Reserve gives up with the error of the Context if it is
+// canceled while waiting.
+func (ec *ExecutionContext) Reserve(n int) (*ExecutionContext, error) {
+	n = min(max(n, 1), max(ec.NumThreads, 1))
+	budget := ec.threads()
+	if ec.Context != nil {
+		// wake the waiters below so they see the cancellation
+		stop := context.AfterFunc(ec.Context, func() {
+			budget.mu.Lock()
+			defer budget.mu.Unlock()
+			budget.cond.Broadcast()
+		})
+		defer stop()
+	}
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	for budget.free < n {
+		if err := ec.Err(); err != nil {
+			return nil, err
+		}
+		budget.cond.Wait()
+	}
+	budget.free -= n
+	return &ExecutionContext{NumThreads: n, ChunkSize: ec.ChunkSize, WaitGroup: &sync.WaitGroup{}, Context: ec.Context, parent: ec}, nil
+}
+
+// Release returns the threads of a context made by Reserve to its parent
+func (ec *ExecutionContext) Release() {
+	if ec.parent == nil {
+		return
+	}
+	budget := ec.parent.threads()
+	budget.mu.Lock()
+	budget.free += ec.NumThreads
+	budget.mu.Unlock()
+	budget.cond.Broadcast()
+	ec.parent = nil
 }
 
 type SafeFloat64 struct {
@@ -0,0 +1,61 @@
+package rlooper
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExecutionContextReserve(t *testing.T) {
+	ec := &ExecutionContext{NumThreads: 4, ChunkSize: 7, WaitGroup: &sync.WaitGroup{}}
+
+	child, err := ec.Reserve(10)
+	if err != nil {
+		t.Fatalf("Reserve returned error: %v", err)
+	}
+	if child.NumThreads != 4 || child.ChunkSize != 7 || child.WaitGroup == ec.WaitGroup {
+		t.Errorf("unexpected child context %+v", child)
+	}
+	child.Release()
+
+	// reservations of 1 to 3 threads never hold more than 4 at once
+	var inUse, peak atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		child, err := ec.Reserve(1 + i%3)
+		if err != nil {
+			t.Fatalf("Reserve returned error: %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n := inUse.Add(int64(child.NumThreads))
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			inUse.Add(-int64(child.NumThreads))
+			child.Release()
+		}()
+	}
+	wg.Wait()
+	if peak.Load() > 4 {
+		t.Errorf("expected at most 4 threads in use, got %d", peak.Load())
+	}
+	all, err := ec.Reserve(4)
+	if err != nil || all.NumThreads != 4 {
+		t.Fatalf("expected every thread to be free again, got %v %v", all, err)
+	}
+
+	// a reservation waiting on busy threads gives up when the context is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	ec.Context = ctx
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := ec.Reserve(1); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	all.Release()
+}
@@ -0,0 +1,144 @@
+package rlooper
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// maxFastaLine is the longest line FastaReader accepts, enough for a chromosome written on one line
+const maxFastaLine = 1 << 30
+
+// FastaReader reads the records of a FASTA file one gene at a time, so that inputs holding many sequences
+// never have to be held in memory at once
+type FastaReader struct {
+	scanner *bufio.Scanner
+	header  string // header of the next record, already read
+	record  int
+}
+
+func NewFastaReader(r io.Reader) *FastaReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxFastaLine)
+	return &FastaReader{scanner: scanner}
+}
+
+// Next returns the gene of the next record, or io.EOF after the last
+func (fr *FastaReader) Next() (*Gene, error) {
+	header := fr.header
+	var lines []string
+	for fr.scanner.Scan() {
+		line := strings.TrimSpace(fr.scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, ">") {
+			if header == "" && lines == nil {
+				header = line
+				continue
+			}
+			fr.header = line
+			return fr.gene(header, lines)
+		}
+		if header == "" {
+			return nil, fmt.Errorf("FASTA record %d has sequence before its header line", fr.record+1)
+		}
+		lines = append(lines, line)
+	}
+	if err := fr.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading FASTA: %v", err)
+	}
+	fr.header = ""
+	if header == "" {
+		return nil, io.EOF
+	}
+	return fr.gene(header, lines)
+}
+
+// ReadFastaHeaders returns the parsed header of every record of a FASTA file without keeping the
+// sequences, for checks that need only the headers of a large input
+func ReadFastaHeaders(r io.Reader) ([]FastaHeader, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxFastaLine)
+	var headers []FastaHeader
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, ">") {
+			continue
+		}
+		header, err := parseHeader(line)
+		if err != nil {
+			return nil, fmt.Errorf("error in FASTA record %d: unable to parse FASTA Header %q: %v", len(headers)+1, line, err)
+		}
+		headers = append(headers, *header)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading FASTA: %v", err)
+	}
+	return headers, nil
+}
+
+// LinearRecords returns the names of the records of a FASTA file whose headers mark them topology=linear.
+// a linear sequence has ends, so wrapping loops around from its last base to its first is not physical.
+func LinearRecords(r io.Reader) ([]string, error) {
+	headers, err := ReadFastaHeaders(r)
+	if err != nil {
+		return nil, err
+	}
+	var linear []string
+	for _, h := range headers {
+		if h.Topology == "linear" {
+			linear = append(linear, h.GeneName)
+		}
+	}
+	return linear, nil
+}
+
+func (fr *FastaReader) gene(header string, lines []string) (*Gene, error) {
+	fr.record++
+	gene, err := newGene(header, lines)
+	if err != nil {
+		return nil, fmt.Errorf("error in FASTA record %d: %v", fr.record, err)
+	}
+	return gene, nil
+}
+
+// newGene builds a gene from a FASTA header line and the sequence lines that follow it. only A, C, G and T
+// are kept, in upper case.
+func newGene(headerLine string, lines []string) (*Gene, error) {
+	header, err := parseHeader(headerLine)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse FASTA Header %q: %v", headerLine, err)
+	}
+
+	var seq []rune
+	for _, line := range lines {
+		for _, c := range strings.ToUpper(line) {
+			if c == 'A' || c == 'T' || c == 'C' || c == 'G' {
+				seq = append(seq, c)
+			} else if c == ' ' || c == '\t' || c == '\r' {
+				continue
+			} else {
+				log.Println("WARN: unrecognized character in input file: ", c)
+			}
+		}
+	}
+	if len(seq) < 2 { // from here on, seq should always be initialized and len(seq) > 1
+		return nil, fmt.Errorf("can't construct gene %s with an empty sequence", header.GeneName)
+	}
+
+	return &Gene{
+		GeneName:     header.GeneName,
+		Header:       headerLine,
+		HeaderFields: *header,
+		Pos: Loci{
+			Chromosome: header.Chromosome,
+			Strand:     header.Strand,
+			StartPos:   header.Start,
+			EndPos:     header.End,
+		},
+		Sequence: seq,
+	}, nil
+}
@@ -0,0 +1,70 @@
+package rlooper
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFastaReader(t *testing.T) {
+	input := `
+>first range=chr1:101-108 strand=+
+GATT
+acag
+
+>second range=chr2:11-14 strand=-
+GGCC
+>third range=chr3:1-2 strand=+
+at
+`
+	reader := NewFastaReader(strings.NewReader(input))
+	var names, sequences []string
+	for {
+		gene, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next returned error: %v", err)
+		}
+		names = append(names, gene.GeneName)
+		sequences = append(sequences, string(gene.Sequence))
+	}
+	if strings.Join(names, ",") != "first,second,third" || strings.Join(sequences, ",") != "GATTACAG,GGCC,AT" {
+		t.Errorf("unexpected records %v %v", names, sequences)
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF after the last record, got %v", err)
+	}
+
+	for _, bad := range []string{"GATTACA\n", ">first range=chr1:1-2\n>second range=chr1:3-4\nGA\n"} {
+		if _, err := NewFastaReader(strings.NewReader(bad)).Next(); err == nil || err == io.EOF {
+			t.Errorf("expected an error reading %q, got %v", bad, err)
+		}
+	}
+}
+
+func TestReadFastaHeaders(t *testing.T) {
+	input := ">first range=chr1:101-108 strand=+\nGATT\n>plasmid range=pUC19:1-4 topology=Circular\nGGCC\n"
+	headers, err := ReadFastaHeaders(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ReadFastaHeaders returned error: %v", err)
+	}
+	if len(headers) != 2 || headers[0].GeneName != "first" || headers[1].GeneName != "plasmid" {
+		t.Fatalf("unexpected headers %+v", headers)
+	}
+	if headers[0].Topology != "" || headers[1].Topology != "circular" {
+		t.Errorf("expected topologies \"\" and circular, got %q and %q", headers[0].Topology, headers[1].Topology)
+	}
+}
+
+func TestLinearRecords(t *testing.T) {
+	input := ">pUC19 range=pUC19:1-8 topology=circular\nGATTACAG\n>amplicon range=chr1:1-4 topology=linear\nGGCC\n>plain range=chr1:11-14\nGGCC\n"
+	linear, err := LinearRecords(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("LinearRecords returned error: %v", err)
+	}
+	if len(linear) != 1 || linear[0] != "amplicon" {
+		t.Errorf("expected only amplicon to be linear, got %v", linear)
+	}
+}
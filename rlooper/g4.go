@@ -0,0 +1,85 @@
+package rlooper
+
+import "regexp"
+
+// G4 is a G-quadruplex predicted on the displaced strand, as an inclusive range of sequence positions
+type G4 struct {
+	Start int
+	End   int
+	Score float64 // G4Hunter score of the motif on the displaced strand
+}
+
+var (
+	g4PlusMotif  = regexp.MustCompile(`G{3,}[ACGT]{1,7}?G{3,}[ACGT]{1,7}?G{3,}[ACGT]{1,7}?G{3,}`)
+	g4MinusMotif = regexp.MustCompile(`C{3,}[ACGT]{1,7}?C{3,}[ACGT]{1,7}?C{3,}[ACGT]{1,7}?C{3,}`)
+)
+
+// g4HunterScores returns the per-base G4Hunter score of seq: each G scores the length of its G run capped
+// at 4, each C scores minus the length of its C run capped at 4, and A and T score 0
+func g4HunterScores(seq []rune) []float64 {
+	scores := make([]float64, len(seq))
+	for i := 0; i < len(seq); {
+		j := i
+		for j < len(seq) && seq[j] == seq[i] {
+			j++
+		}
+		run := float64(min(j-i, 4))
+		for k := i; k < j; k++ {
+			switch seq[i] {
+			case 'G':
+				scores[k] = run
+			case 'C':
+				scores[k] = -run
+			}
+		}
+		i = j
+	}
+	return scores
+}
+
+// FindG4Motifs returns the non-overlapping G3+N1-7 motifs on the displaced strand. sequences are read in
+// genomic orientation, so for a minus strand gene the displaced strand is the reverse complement and its
+// G runs show up as C runs. scores are reported for the displaced strand, so they are positive for both.
+func FindG4Motifs(seq []rune, minusStrand bool) []G4 {
+	motif := g4PlusMotif
+	sign := 1.0
+	if minusStrand {
+		motif = g4MinusMotif
+		sign = -1.0
+	}
+	scores := g4HunterScores(seq)
+
+	var result []G4
+	for _, m := range motif.FindAllStringIndex(string(seq), -1) {
+		total := 0.0
+		for i := m[0]; i < m[1]; i++ {
+			total += scores[i]
+		}
+		result = append(result, G4{Start: m[0], End: m[1] - 1, Score: sign * total / float64(m[1]-m[0])})
+	}
+	return result
+}
+
+// G4Bonus stabilizes windows that fully contain a predicted G-quadruplex, which can fold on the displaced
+// strand once the R-loop has opened it. Energy is added once per contained motif and is negative for a
+// stabilizing bonus.
+type G4Bonus struct {
+	Motifs []G4
+	Energy float64
+}
+
+func (b G4Bonus) WindowEnergy(n int, w Window) float64 {
+	energy := 0.0
+	for _, g := range b.Motifs {
+		var contained bool
+		if w.End >= w.Start {
+			contained = g.Start >= w.Start && g.End <= w.End
+		} else { // motifs never cross the boundary, so they sit wholly in one piece of the window
+			contained = g.Start >= w.Start || g.End <= w.End
+		}
+		if contained {
+			energy += b.Energy
+		}
+	}
+	return energy
+}
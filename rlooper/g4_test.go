@@ -0,0 +1,50 @@
+package rlooper
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFindG4Motifs(t *testing.T) {
+	seq := []rune("ATGGGAGGGTTGGGCAGGGTATTTCCCACCCACCCTCCCA")
+	plus := FindG4Motifs(seq, false)
+	if len(plus) != 1 || plus[0].Start != 2 || plus[0].End != 18 {
+		t.Fatalf("FindG4Motifs plus strand = %v, want one motif at 2-18", plus)
+	}
+	// 12 bases in G runs of 3 score 3 each, the 5 loop bases score 0 or -1 for the lone C
+	if expected := (12*3.0 - 1) / 17; math.Abs(plus[0].Score-expected) > 1e-12 {
+		t.Errorf("G4Hunter score = %v, want %v", plus[0].Score, expected)
+	}
+
+	minus := FindG4Motifs(seq, true)
+	if len(minus) != 1 || minus[0].Start != 24 || minus[0].End != 38 {
+		t.Fatalf("FindG4Motifs minus strand = %v, want one motif at 24-38", minus)
+	}
+	if minus[0].Score <= 0 {
+		t.Errorf("minus strand motifs should score positive on the displaced strand, got %v", minus[0].Score)
+	}
+
+	if motifs := FindG4Motifs([]rune("GGGAGGGAGGG"), false); len(motifs) != 0 {
+		t.Errorf("three G runs should not form a motif, got %v", motifs)
+	}
+}
+
+func TestG4BonusWindowEnergy(t *testing.T) {
+	bonus := G4Bonus{Motifs: []G4{{Start: 2, End: 5}, {Start: 10, End: 12}}, Energy: -2}
+	cases := []struct {
+		w        Window
+		expected float64
+	}{
+		{Window{0, 13}, -4},
+		{Window{2, 5}, -2},
+		{Window{3, 12}, -2},
+		{Window{3, 11}, 0},
+		{Window{10, 3}, -2}, // crosses the boundary of a 15 base sequence
+		{Window{9, 6}, -4},
+	}
+	for _, c := range cases {
+		if e := bonus.WindowEnergy(15, c.w); e != c.expected {
+			t.Errorf("WindowEnergy(%v) = %v, want %v", c.w, e, c.expected)
+		}
+	}
+}
@@ -1,8 +1,8 @@
 package rlooper
 
 import (
-	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"regexp"
@@ -12,15 +12,17 @@ import (
 
 // Gene bioinformatic representation of a gene
 type Gene struct {
-	GeneName string
-	Header   string
-	Pos      Loci
-	Sequence []rune
+	GeneName     string
+	Header       string
+	HeaderFields FastaHeader
+	Pos          Loci
+	Sequence     []rune
 	// moved vector<Structure> and ground_state_energy to ensemble
 }
 
 type FastaHeader struct {
 	GeneName      string
+	Chromosome    string
 	BasePairRange string
 	Start         int64
 	End           int64
@@ -28,34 +30,7 @@ type FastaHeader struct {
 	ThreePad      int
 	Strand        string
 	RepeatMasking string
-}
-
-func fileLineScanner(filename string) ([]string, error) {
-
-	var values []string
-	file, err := os.Open(filename)
-	if err != nil {
-		return values, err
-	}
-	defer func(file *os.File) {
-		err := file.Close()
-		if err != nil {
-			log.Fatal(err)
-		}
-	}(file)
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
-		values = append(values, line)
-	}
-	if len(values) == 0 {
-		log.Fatal("ERROR: unable to read lines from input file")
-	}
-	return values, nil
+	Topology      string // linear or circular when the header gives topology=, otherwise empty
 }
 
 func atoiToInt64(s string) (int64, error) {
@@ -80,11 +55,13 @@ func parseHeader(header string) (*FastaHeader, error) {
 
 	rangeRegex := regexp.MustCompile(`range=([^:]+):(\d+)-(\d+)`)
 	padRegex := regexp.MustCompile(`([53]'?pad)=(\d+)`)
-	strandRegex := regexp.MustCompile(`Strand=([-+])`)
+	strandRegex := regexp.MustCompile(`(?i)strand=([-+])`)
 	repeatMaskRegex := regexp.MustCompile(`repeatMasking=([a-zA-Z0-9]+)`) // Adjusted to be more flexible
+	topologyRegex := regexp.MustCompile(`(?i)topology=(linear|circular)`)
 
 	for _, field := range fields[1:] {
 		if matches := rangeRegex.FindStringSubmatch(field); matches != nil {
+			parsed.Chromosome = matches[1]
 			parsed.BasePairRange = matches[1] + ":" + matches[2] + "-" + matches[3]
 			start, err := atoiToInt64(matches[2])
 			if err != nil {
@@ -111,51 +88,34 @@ func parseHeader(header string) (*FastaHeader, error) {
 		if matches := repeatMaskRegex.FindStringSubmatch(field); matches != nil {
 			parsed.RepeatMasking = matches[1]
 		}
+		if matches := topologyRegex.FindStringSubmatch(field); matches != nil {
+			parsed.Topology = strings.ToLower(matches[1])
+		}
 	}
 
 	return parsed, nil
 }
 
-// TODO: support for reading multiple Genes out of a single FASTA file
+// NewGene reads the first record of the FASTA file at filename. use FastaReader to read every record of a
+// multi-record file.
 func NewGene(filename string) *Gene {
-
-	lines, err := fileLineScanner(filename)
+	file, err := os.Open(filename)
 	if err != nil {
 		log.Fatal("ERROR: unable to read lines from input file: ", filename)
 	}
-	header, err := parseHeader(lines[0])
-	if err != nil {
-		log.Fatal("ERROR: unable to parse FASTA Header for input: ", filename)
-	}
+	defer file.Close()
 
-	var seq []rune
-	for _, line := range lines[1:] {
-		for i := 0; i < len(line); i++ {
-			c := rune(strings.ToUpper(line)[i])
-			if c == 'A' || c == 'T' || c == 'C' || c == 'G' {
-				seq = append(seq, c)
-			} else if c == '\n' || c == ' ' || c == '\t' || c == '\r' {
-				continue // TODO: allow multi-gene FASTA files
-			} else {
-				log.Println("WARN: unrecognized character in input file: ", c)
-			}
-		}
-	}
-	if len(seq) < 2 { // from here on, seq should always be initialized and len(seq) > 1
-		log.Fatal("ERROR: can't construct a gene with an empty sequence")
+	reader := NewFastaReader(file)
+	gene, err := reader.Next()
+	if err == io.EOF {
+		log.Fatal("ERROR: unable to read lines from input file")
+	} else if err != nil {
+		log.Fatal("ERROR: ", err, " in input: ", filename)
 	}
-
-	return &Gene{
-		GeneName: header.GeneName,
-		Header:   lines[0],
-		Pos: Loci{
-			Chromosome: "", // TODO: parse from Header
-			Strand:     header.Strand,
-			StartPos:   header.Start,
-			EndPos:     header.End,
-		},
-		Sequence: seq,
+	if _, err := reader.Next(); err != io.EOF {
+		log.Println("WARN: only the first record of", filename, "is read")
 	}
+	return gene
 }
 
 func (g *Gene) printGene() {
@@ -166,100 +126,85 @@ func (g *Gene) printGene() {
 	fmt.Print('\n')
 }
 
+// computeStructure computes the structure formed over w. energy adjustments in wp are added to the free
+// energy, and the length prior, if any, is folded into the Boltzmann factor so that probabilities derived
+// from it honor the prior.
+func (g *Gene) computeStructure(model *ModelParams, wp WindowParams, w Window) Structure {
+	structure := Structure{
+		Pos: Loci{
+			g.Pos.Chromosome,
+			g.Pos.Strand,
+			int64(w.Start), // TODO: loci Pos is in terms of genomic coordinates in rlooper2
+			int64(w.End),
+		},
+		FreeEnergy:      0,
+		BoltzmannFactor: 0,
+		Probability:     0,
+	}
+	model.ComputeStructure(g.Sequence, w, &structure)
+	if len(wp.Adjustments) > 0 {
+		structure.FreeEnergy += wp.adjustmentEnergy(len(g.Sequence), w)
+		structure.BoltzmannFactor = computeBoltzmannFactor(structure.FreeEnergy, model.T)
+	}
+	structure.BoltzmannFactor *= wp.lengthWeight(structure.Length)
+	return structure
+}
+
 // computeStructuresSerial computes structures the rlooper2 way, which is to say serially in a single thread
 // for performance comparison with computeStructures
-func (g *Gene) computeStructuresSerial(model *ModelParams, minLoopLength int, circular bool) []Structure {
+func (g *Gene) computeStructuresSerial(model *ModelParams, wp WindowParams) []Structure {
 
-	windows := FromLinearWindows(g.Sequence, minLoopLength)
-	if circular {
-		windows = append(windows, FromCircularWindows(g.Sequence, minLoopLength)...)
-	}
+	windows := wp.Windows(g.Sequence)
 	var result []Structure
 	for _, w := range windows {
-		structure := Structure{
-			Pos: Loci{
-				g.Pos.Chromosome,
-				g.Pos.Strand,
-				int64(w.Start), // TODO: loci Pos is in terms of genomic coordinates in rlooper2
-				int64(w.End),
-			},
-			FreeEnergy:      0,
-			BoltzmannFactor: 0,
-			Probability:     0,
-		}
-		model.ComputeStructure(g.Sequence, w, &structure)
-		result = append(result, structure)
+		result = append(result, g.computeStructure(model, wp, w))
 	}
 	return result
 }
 
-func (g *Gene) computeStructuresConcurrent(ec *ExecutionContext, model *ModelParams, minLoopLength int, circular bool) []Structure {
-	windows := FromLinearWindows(g.Sequence, minLoopLength)
-	if circular {
-		windows = append(windows, FromCircularWindows(g.Sequence, minLoopLength)...)
-	}
+// computeStructuresConcurrent computes the structure of every window in parallel. windows near the start
+// of the sequence are longer and more numerous, so rather than splitting the windows evenly between
+// threads, workers take chunks of ec.ChunkSize windows from a queue as they finish the last. each structure
+// is stored at the index of its window, so the result is in the same order for any number of threads.
+func (g *Gene) computeStructuresConcurrent(ec *ExecutionContext, model *ModelParams, wp WindowParams) ([]Structure, error) {
+	windows := wp.Windows(g.Sequence)
 
 	// Handle case where no threads are requested
 	if ec.NumThreads <= 0 {
 		// Fall back to serial computation
-		return g.computeStructuresSerial(model, minLoopLength, circular)
+		return g.computeStructuresSerial(model, wp), nil
 	}
 
-	// Calculate block size once outside the loop, edge case where n windows are less than numThreads
-	blockSize := len(windows) / ec.NumThreads
-	if blockSize == 0 {
-		blockSize = 1
-	}
-
-	// Create a channel with a reasonable buffer size
-	structureChan := make(chan []Structure, ec.NumThreads)
-
-	// Create an empty slice for results
-	results := make([]Structure, 0)
+	chunkSize := ec.chunkSize()
+	chunks := make(chan int)
+	go func() {
+		defer close(chunks)
+		for start := 0; start < len(windows); start += chunkSize {
+			select {
+			case chunks <- start:
+			case <-ec.done():
+				return
+			}
+		}
+	}()
 
+	results := make([]Structure, len(windows))
 	ec.WaitGroup.Add(ec.NumThreads)
-	for i := 0; i < ec.NumThreads; i++ { //compute structures in parallel
-		go func(i int) {
+	for t := 0; t < ec.NumThreads; t++ { //compute structures in parallel
+		go func() {
 			defer ec.WaitGroup.Done()
-
-			var chunk []Window
-			if i != ec.NumThreads-1 {
-				chunk = windows[i*blockSize : (i+1)*blockSize]
-			} else {
-				chunk = windows[i*blockSize:]
-			}
-
-			// Collect structures for this chunk
-			chunkStructures := make([]Structure, 0, len(chunk))
-			for _, w := range chunk {
-				structure := Structure{
-					Pos: Loci{
-						g.Pos.Chromosome,
-						g.Pos.Strand,
-						int64(w.Start), // TODO: loci Pos is in terms of genomic coordinates in rlooper2
-						int64(w.End),
-					},
-					FreeEnergy:      0,
-					BoltzmannFactor: 0,
-					Probability:     0,
+			for start := range chunks {
+				end := min(start+chunkSize, len(windows))
+				for k := start; k < end; k++ {
+					results[k] = g.computeStructure(model, wp, windows[k])
 				}
-				model.ComputeStructure(g.Sequence, w, &structure)
-				chunkStructures = append(chunkStructures, structure)
 			}
-			structureChan <- chunkStructures
-		}(i)
+		}()
 	}
+	ec.WaitGroup.Wait()
 
-	// Start a goroutine to close the channel when all workers are done
-	go func() {
-		ec.WaitGroup.Wait()
-		close(structureChan)
-	}()
-
-	// Collect all structures from the channel
-	for chunk := range structureChan {
-		results = append(results, chunk...)
+	if err := ec.Err(); err != nil {
+		return nil, err
 	}
-
-	return results
+	return results, nil
 }
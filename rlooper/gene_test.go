@@ -1,6 +1,7 @@
 package rlooper
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"sync"
@@ -20,8 +21,8 @@ func TestComputeStructuresSerial(t *testing.T) {
 	model := NewParamsReasonableDefaults()
 	minLoopLength := 2
 
-	result := gene.computeStructuresSerial(&model, minLoopLength, false)
-	result2 := gene.computeStructuresSerial(&model, minLoopLength, true)
+	result := gene.computeStructuresSerial(&model, WindowParams{MinLength: minLoopLength})
+	result2 := gene.computeStructuresSerial(&model, WindowParams{MinLength: minLoopLength, Circular: true})
 
 	if len(result) != 21 {
 		t.Errorf("Expected 21 structures, got %d", len(result))
@@ -48,8 +49,63 @@ func TestComputeStructuresConcurrent(t *testing.T) {
 		WaitGroup:  &sync.WaitGroup{},
 	}
 
-	result := gene.computeStructuresConcurrent(ec, &model, minLoopLength, false)
+	result, err := gene.computeStructuresConcurrent(ec, &model, WindowParams{MinLength: minLoopLength})
+	if err != nil {
+		t.Fatalf("computeStructuresConcurrent returned error: %v", err)
+	}
 	if len(result) != 21 {
 		t.Errorf("Expected 21 structures, got %d", len(result))
 	}
 }
+
+func TestComputeStructuresConcurrentOrder(t *testing.T) {
+	gene := &Gene{Sequence: []rune("GATTACAGGGCCCGATTACAGGGAAATTTCCCGGA")}
+	model := NewParamsReasonableDefaults()
+	wp := WindowParams{MinLength: 2, Circular: true}
+	serial := gene.computeStructuresSerial(&model, wp)
+
+	for _, threads := range []int{1, 3, 8} {
+		for _, chunkSize := range []int{1, 7, 0} {
+			ec := &ExecutionContext{NumThreads: threads, ChunkSize: chunkSize, WaitGroup: &sync.WaitGroup{}}
+			result, err := gene.computeStructuresConcurrent(ec, &model, wp)
+			if err != nil {
+				t.Fatalf("computeStructuresConcurrent returned error: %v", err)
+			}
+			if len(result) != len(serial) {
+				t.Fatalf("%d threads, chunks of %d: expected %d structures, got %d", threads, chunkSize, len(serial), len(result))
+			}
+			for i := range serial {
+				if result[i] != serial[i] {
+					t.Fatalf("%d threads, chunks of %d: structure %d is %+v, want %+v", threads, chunkSize, i, result[i], serial[i])
+				}
+			}
+		}
+	}
+}
+
+func TestComputeStructuresConcurrentCanceled(t *testing.T) {
+	gene := &Gene{Sequence: []rune("GATTACAGGGCCCGATTACAGGGAAATTTCCCGGA")}
+	model := NewParamsReasonableDefaults()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ec := &ExecutionContext{NumThreads: 2, ChunkSize: 1, WaitGroup: &sync.WaitGroup{}, Context: ctx}
+	if _, err := gene.computeStructuresConcurrent(ec, &model, WindowParams{MinLength: 2}); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	header, err := parseHeader(">GATTACA_dna range=chrG:11-17 5'pad=2 3'pad=3 strand=- repeatMasking=none")
+	if err != nil {
+		t.Fatalf("parseHeader returned error: %v", err)
+	}
+	if header.Chromosome != "chrG" || header.Start != 11 || header.End != 17 {
+		t.Errorf("range parsed as %s:%d-%d, want chrG:11-17", header.Chromosome, header.Start, header.End)
+	}
+	if header.Strand != "-" {
+		t.Errorf("Expected strand -, got %q", header.Strand)
+	}
+	if header.FivePad != 2 || header.ThreePad != 3 {
+		t.Errorf("Expected pads 2 and 3, got %d and %d", header.FivePad, header.ThreePad)
+	}
+}
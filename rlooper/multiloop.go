@@ -0,0 +1,255 @@
+package rlooper
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// MaxMultiLoopConfigurations caps the configurations ComputeMultiLoopEnsemble enumerates, about 20 minutes
+// on one thread. without a cap on loop length it allows domains of about 700 bases for two loops, 140 for
+// three and 65 for four; capping the length raises the limits. larger ensembles are left to SampleEnsemble.
+const MaxMultiLoopConfigurations = 1e10
+
+// ErrTooManyConfigurations is returned by ComputeMultiLoopEnsemble for ensembles over
+// MaxMultiLoopConfigurations
+var ErrTooManyConfigurations = errors.New("too many configurations to enumerate")
+
+// MultiLoopResult holds per-base probabilities for an ensemble in which up to MaxLoops non-overlapping
+// R-loops can form at once, alongside the single-loop approximation for comparison
+type MultiLoopResult struct {
+	MaxLoops                int
+	BpProbability           []float64 // probability each base is in any loop, multi-loop ensemble
+	SingleLoopBpProbability []float64 // probability each base is in a loop, single-loop ensemble
+	LoopCountProbability    []float64 // index k is the probability of exactly k loops
+}
+
+// MaxAbsDelta returns the largest per-base probability change relative to the single-loop approximation
+// and the position where it occurs
+func (r *MultiLoopResult) MaxAbsDelta() (float64, int) {
+	maxDelta, pos := 0.0, 0
+	for i := range r.BpProbability {
+		if d := math.Abs(r.BpProbability[i] - r.SingleLoopBpProbability[i]); d > maxDelta {
+			maxDelta, pos = d, i
+		}
+	}
+	return maxDelta, pos
+}
+
+// MeanAbsDelta returns the mean per-base probability change relative to the single-loop approximation
+func (r *MultiLoopResult) MeanAbsDelta() float64 {
+	if len(r.BpProbability) == 0 {
+		return 0
+	}
+	total := 0.0
+	for i := range r.BpProbability {
+		total += math.Abs(r.BpProbability[i] - r.SingleLoopBpProbability[i])
+	}
+	return total / float64(len(r.BpProbability))
+}
+
+// loopEnumerator walks every configuration of up to maxLoops non-overlapping loops. loops are visited in
+// increasing start order, so the only loop allowed to cross the circular boundary is the last one, and it
+// must end before the first loop starts. this visits each configuration exactly once.
+type loopEnumerator struct {
+	seq      []rune
+	model    *ModelParams
+	prefix   []float64
+	wp       WindowParams
+	maxLoops int
+
+	// per worker accumulators, indexed by the number of loops in a configuration
+	z     []float64
+	diffs [][]float64
+	loops []Window
+}
+
+func newLoopEnumerator(seq []rune, model *ModelParams, prefix []float64, wp WindowParams, maxLoops int) *loopEnumerator {
+	e := &loopEnumerator{
+		seq:      seq,
+		model:    model,
+		prefix:   prefix,
+		wp:       wp,
+		maxLoops: maxLoops,
+		z:        make([]float64, maxLoops+1),
+		diffs:    make([][]float64, maxLoops+1),
+		loops:    make([]Window, 0, maxLoops),
+	}
+	for k := 1; k <= maxLoops; k++ {
+		e.diffs[k] = make([]float64, len(seq)+1)
+	}
+	return e
+}
+
+// record adds the configuration currently held in e.loops to the accumulators. bpEnergy includes the energy
+// adjustments of every loop.
+func (e *loopEnumerator) record(totalLength int, bpEnergy float64) {
+	k := len(e.loops)
+	// the ground state carries the nucleation energy of a single loop, so every loop past the first pays it
+	energy := e.model.superhelicalEnergy(totalLength) + bpEnergy + float64(k-1)*e.model.a
+	weight := computeBoltzmannFactor(energy, e.model.T)
+	for _, w := range e.loops {
+		weight *= e.wp.lengthWeight(windowLength(len(e.seq), w))
+	}
+	e.z[k] += weight
+	for _, w := range e.loops {
+		addCoverage(e.diffs[k], w, weight)
+	}
+}
+
+// place tries every window starting at or after from as the next loop, then recurses for the one after it
+func (e *loopEnumerator) place(from int, totalLength int, bpEnergy float64) {
+	n := len(e.seq)
+	for s := from; s < n; s++ {
+		e.placeAt(s, totalLength, bpEnergy)
+	}
+}
+
+// placeAt tries every window starting at s as the next loop
+func (e *loopEnumerator) placeAt(s int, totalLength int, bpEnergy float64) {
+	n := len(e.seq)
+	maxLength := e.wp.maxLength(n)
+	for end := s + e.wp.MinLength - 1; end < n && end-s+1 <= maxLength; end++ {
+		if w := (Window{Start: s, End: end}); e.wp.initiates(w) {
+			e.visit(w, totalLength, bpEnergy)
+		}
+	}
+	if !e.wp.Circular {
+		return
+	}
+	// a loop crossing the boundary must be the last one and must stop short of the first loop
+	limit := s
+	if len(e.loops) > 0 {
+		limit = e.loops[0].Start
+	}
+	for end := 0; end < limit; end++ {
+		w := Window{Start: s, End: end}
+		if length := windowLength(n, w); length < e.wp.MinLength || length > maxLength || !e.wp.initiates(w) {
+			continue
+		}
+		e.loops = append(e.loops, w)
+		e.record(totalLength+windowLength(n, w), bpEnergy+e.loopEnergy(w))
+		e.loops = e.loops[:len(e.loops)-1]
+	}
+}
+
+// loopEnergy returns the sequence dependent energy of a single loop: base pairing plus adjustments
+func (e *loopEnumerator) loopEnergy(w Window) float64 {
+	return windowBpEnergy(e.prefix, w) + e.wp.adjustmentEnergy(len(e.seq), w)
+}
+
+func (e *loopEnumerator) visit(w Window, totalLength int, bpEnergy float64) {
+	totalLength += windowLength(len(e.seq), w)
+	bpEnergy += e.loopEnergy(w)
+	e.loops = append(e.loops, w)
+	e.record(totalLength, bpEnergy)
+	if len(e.loops) < e.maxLoops {
+		e.place(w.End+1, totalLength, bpEnergy)
+	}
+	e.loops = e.loops[:len(e.loops)-1]
+}
+
+// multiLoopConfigurations estimates the configurations of up to maxLoops non-overlapping loops on n bases:
+// the ways to place k disjoint intervals, C(n+k, 2k), or fewer when wp caps loop length and the k loops
+// come from a smaller set of windows
+func multiLoopConfigurations(n int, wp WindowParams, maxLoops int) float64 {
+	lgamma := func(x int) float64 {
+		v, _ := math.Lgamma(float64(x))
+		return v
+	}
+	windows := float64(n) * float64(max(wp.maxLength(n)-max(wp.MinLength, 1)+1, 1))
+	total := 0.0
+	for k := 1; k <= maxLoops; k++ {
+		placements := math.Exp(lgamma(n+k+1) - lgamma(2*k+1) - lgamma(n-k+1))
+		fromWindows := math.Exp(float64(k)*math.Log(windows) - lgamma(k+1))
+		total += math.Min(placements, fromWindows)
+	}
+	return total
+}
+
+// ComputeMultiLoopEnsemble enumerates every configuration of up to maxLoops non-overlapping R-loops sharing
+// the superhelical domain. the torsional energy of a configuration is computed from the combined length of
+// its loops. enumeration is exhaustive and grows as n^(2*maxLoops), so ensembles of more than
+// MaxMultiLoopConfigurations are refused with ErrTooManyConfigurations; the single-loop ensemble is computed
+// from the same pass for comparison.
+func (g *Gene) ComputeMultiLoopEnsemble(ec *ExecutionContext, model *ModelParams, wp WindowParams, maxLoops int) (*MultiLoopResult, error) {
+	if maxLoops < 1 {
+		maxLoops = 1
+	}
+	numThreads := ec.NumThreads
+	if numThreads <= 0 {
+		numThreads = 1
+	}
+	n := len(g.Sequence)
+	if c := multiLoopConfigurations(n, wp, maxLoops); maxLoops > 1 && c > MaxMultiLoopConfigurations {
+		return nil, fmt.Errorf("%w: up to %d loops on %d bases are about %.1e configurations, over the limit of %.0e",
+			ErrTooManyConfigurations, maxLoops, n, c, MaxMultiLoopConfigurations)
+	}
+	prefix := model.bpEnergyPrefix(g.Sequence)
+
+	z := make([]float64, maxLoops+1)
+	diffs := make([][]float64, maxLoops+1)
+	for k := 1; k <= maxLoops; k++ {
+		diffs[k] = make([]float64, n+1)
+	}
+	var mu sync.Mutex
+
+	// workers take the start of the first loop from a shared queue
+	starts := make(chan int)
+	go func() {
+		defer close(starts)
+		for i := 0; i < n; i++ {
+			select {
+			case starts <- i:
+			case <-ec.done():
+				return
+			}
+		}
+	}()
+
+	ec.WaitGroup.Add(numThreads)
+	for t := 0; t < numThreads; t++ {
+		go func() {
+			defer ec.WaitGroup.Done()
+			e := newLoopEnumerator(g.Sequence, model, prefix, wp, maxLoops)
+			for s := range starts {
+				e.placeAt(s, 0, 0)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for k := 1; k <= maxLoops; k++ {
+				z[k] += e.z[k]
+				for i, v := range e.diffs[k] {
+					diffs[k][i] += v
+				}
+			}
+		}()
+	}
+	ec.WaitGroup.Wait()
+	if err := ec.Err(); err != nil {
+		return nil, err
+	}
+
+	ground := model.GroundStateFactor()
+	total := ground
+	combined := make([]float64, n+1)
+	for k := 1; k <= maxLoops; k++ {
+		total += z[k]
+		for i, v := range diffs[k] {
+			combined[i] += v
+		}
+	}
+
+	result := &MultiLoopResult{
+		MaxLoops:                maxLoops,
+		BpProbability:           coverageFromDiff(combined, total),
+		SingleLoopBpProbability: coverageFromDiff(diffs[1], ground+z[1]),
+		LoopCountProbability:    make([]float64, maxLoops+1),
+	}
+	result.LoopCountProbability[0] = ground / total
+	for k := 1; k <= maxLoops; k++ {
+		result.LoopCountProbability[k] = z[k] / total
+	}
+	return result, nil
+}
@@ -0,0 +1,162 @@
+package rlooper
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+)
+
+// bruteForceTwoLoop computes per-base probabilities of the two-loop ensemble by checking every pair of windows
+func bruteForceTwoLoop(seq []rune, model *ModelParams, wp WindowParams) []float64 {
+	windows := wp.Windows(seq)
+	covers := func(w Window) []bool {
+		c := make([]bool, len(seq))
+		for i := w.Start; ; i = (i + 1) % len(seq) {
+			c[i] = true
+			if i == w.End {
+				break
+			}
+		}
+		return c
+	}
+	energy := func(ws ...Window) float64 {
+		total, bp := 0, 0.0
+		for _, w := range ws {
+			var s Structure
+			model.ComputeStructure(seq, w, &s)
+			total += s.Length
+			bp += s.FreeEnergy - model.superhelicalEnergy(s.Length)
+		}
+		return model.superhelicalEnergy(total) + bp + float64(len(ws)-1)*model.a
+	}
+
+	z := model.GroundStateFactor()
+	weights := make([]float64, len(seq))
+	for i, w1 := range windows {
+		c1 := covers(w1)
+		bf := computeBoltzmannFactor(energy(w1), model.T)
+		z += bf
+		for b := range seq {
+			if c1[b] {
+				weights[b] += bf
+			}
+		}
+		for _, w2 := range windows[i+1:] {
+			c2 := covers(w2)
+			overlap := false
+			for b := range seq {
+				if c1[b] && c2[b] {
+					overlap = true
+				}
+			}
+			if overlap {
+				continue
+			}
+			bf := computeBoltzmannFactor(energy(w1, w2), model.T)
+			z += bf
+			for b := range seq {
+				if c1[b] || c2[b] {
+					weights[b] += bf
+				}
+			}
+		}
+	}
+	for b := range weights {
+		weights[b] /= z
+	}
+	return weights
+}
+
+func TestComputeMultiLoopEnsemble(t *testing.T) {
+	gene := &Gene{Sequence: []rune("GGGCTTAGCCATTGCGCAAT")}
+	// a favorable homopolymer energy and a small nucleation cost give two-loop states real weight
+	model := NewParamsReasonableDefaults()
+	model.SetN(40)
+	model.SetHomopolymerOverride(-0.4)
+	model.SetNucleationFreeEnergy(2)
+	ec := &ExecutionContext{
+		NumThreads: 3,
+		WaitGroup:  &sync.WaitGroup{},
+	}
+
+	for _, wp := range []WindowParams{
+		{MinLength: 3},
+		{MinLength: 3, Circular: true},
+		{MinLength: 3, MaxLength: 8, Circular: true},
+		{MinLength: 3, Circular: true, Initiation: InitiationMask(len(gene.Sequence), []Zone{{0, 2}, {10, 12}})},
+	} {
+		circular := wp.Circular
+		single, err := gene.BasePairProbabilities(ec, &model, wp)
+		if err != nil {
+			t.Fatalf("BasePairProbabilities returned error: %v", err)
+		}
+		result, err := gene.ComputeMultiLoopEnsemble(ec, &model, wp, 2)
+		if err != nil {
+			t.Fatalf("ComputeMultiLoopEnsemble returned error: %v", err)
+		}
+		expected := bruteForceTwoLoop(gene.Sequence, &model, wp)
+
+		for i := range gene.Sequence {
+			if math.Abs(result.SingleLoopBpProbability[i]-single[i]) > 1e-9 {
+				t.Errorf("circular=%v: single-loop probability at %d = %v, want %v", circular, i, result.SingleLoopBpProbability[i], single[i])
+			}
+			if math.Abs(result.BpProbability[i]-expected[i]) > 1e-9 {
+				t.Errorf("circular=%v: two-loop probability at %d = %v, want %v", circular, i, result.BpProbability[i], expected[i])
+			}
+		}
+
+		if result.LoopCountProbability[2] < 0.01 {
+			t.Errorf("circular=%v: expected two-loop states to carry weight, got %v", circular, result.LoopCountProbability[2])
+		}
+		total := 0.0
+		for _, p := range result.LoopCountProbability {
+			total += p
+		}
+		if math.Abs(total-1) > 1e-9 {
+			t.Errorf("circular=%v: loop count probabilities sum to %v, want 1", circular, total)
+		}
+	}
+}
+
+func TestComputeMultiLoopEnsembleSingleLoop(t *testing.T) {
+	gene := &Gene{Sequence: []rune("GATTACAGATTACA")}
+	model := NewParamsReasonableDefaults()
+	ec := &ExecutionContext{
+		NumThreads: 2,
+		WaitGroup:  &sync.WaitGroup{},
+	}
+
+	result, err := gene.ComputeMultiLoopEnsemble(ec, &model, WindowParams{MinLength: 2}, 1)
+	if err != nil {
+		t.Fatalf("ComputeMultiLoopEnsemble returned error: %v", err)
+	}
+	if maxDelta, _ := result.MaxAbsDelta(); maxDelta > 1e-12 {
+		t.Errorf("single-loop ensemble should match the approximation, got max delta %v", maxDelta)
+	}
+}
+
+func TestComputeMultiLoopEnsembleLimit(t *testing.T) {
+	seq := make([]rune, 1000)
+	for i := range seq {
+		seq[i] = rune("GATC"[i%4])
+	}
+	gene := &Gene{Sequence: seq}
+	model := NewParamsReasonableDefaults()
+	ec := &ExecutionContext{
+		NumThreads: 2,
+		WaitGroup:  &sync.WaitGroup{},
+	}
+
+	if _, err := gene.ComputeMultiLoopEnsemble(ec, &model, WindowParams{MinLength: 2}, 2); !errors.Is(err, ErrTooManyConfigurations) {
+		t.Errorf("expected two loops on 1000 bases to be refused, got %v", err)
+	}
+	// capping loop length leaves few enough configurations
+	if _, err := gene.ComputeMultiLoopEnsemble(ec, &model, WindowParams{MinLength: 2, MaxLength: 5}, 2); err != nil {
+		t.Errorf("expected two loops of at most 5 bases to be enumerated, got %v", err)
+	}
+	// the estimate is exact for loops of any length on a linear sequence
+	if c := multiLoopConfigurations(10, WindowParams{MinLength: 1}, 2); math.Abs(c-(55+495)) > 1e-6 {
+		t.Errorf("expected 550 configurations of up to two loops on 10 bases, got %v", c)
+	}
+}
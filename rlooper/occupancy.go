@@ -0,0 +1,24 @@
+package rlooper
+
+// OccupancyPenalty destabilizes windows that cover bases occupied by nucleosomes or other proteins. the
+// penalty of a window is the sum of the per-base penalties it covers, looked up from prefix sums.
+type OccupancyPenalty struct {
+	prefix []float64
+}
+
+// NewOccupancyPenalty returns a penalty of energyPerBase Kcal/mol for every base, scaled by its occupancy.
+// occupancy holds one value per sequence position; 1 is fully occupied and 0 is free.
+func NewOccupancyPenalty(occupancy []float64, energyPerBase float64) OccupancyPenalty {
+	prefix := make([]float64, len(occupancy)+1)
+	for i, o := range occupancy {
+		prefix[i+1] = prefix[i] + o*energyPerBase
+	}
+	return OccupancyPenalty{prefix: prefix}
+}
+
+func (p OccupancyPenalty) WindowEnergy(n int, w Window) float64 {
+	if w.End >= w.Start {
+		return p.prefix[w.End+1] - p.prefix[w.Start]
+	}
+	return p.prefix[n] - p.prefix[w.Start] + p.prefix[w.End+1]
+}
@@ -0,0 +1,21 @@
+package rlooper
+
+import "testing"
+
+func TestOccupancyPenalty(t *testing.T) {
+	penalty := NewOccupancyPenalty([]float64{0, 1, 0.5, 0, 0, 1}, 2)
+	cases := []struct {
+		w        Window
+		expected float64
+	}{
+		{Window{0, 5}, 5},
+		{Window{1, 2}, 3},
+		{Window{3, 4}, 0},
+		{Window{5, 1}, 4}, // crosses the boundary
+	}
+	for _, c := range cases {
+		if e := penalty.WindowEnergy(6, c.w); e != c.expected {
+			t.Errorf("WindowEnergy(%v) = %v, want %v", c.w, e, c.expected)
+		}
+	}
+}
@@ -26,7 +26,7 @@ func NewParamsReasonableDefaults() ModelParams {
 		C:     1.8,
 		T:     310,
 		a:     10,
-		sigma: -0.07,
+		sigma: -0.07, // negatively supercoiled, see SetSuperhelicity
 	}
 
 	p.k = (2200 * 0.0019858775 * p.T) / p.N
@@ -48,6 +48,9 @@ func (p *ModelParams) SetA(A float64) {
 	p.alpha = p.N * p.sigma * p.A
 }
 
+// SetSuperhelicity sets the superhelical density sigma, the fractional change in linking number relative
+// to relaxed DNA. negative values are underwound (negatively supercoiled) DNA, which R-loops relax, e.g.
+// -0.07 for a typical plasmid; positive values are overwound DNA, which R-loops wind further.
 func (p *ModelParams) SetSuperhelicity(sigma float64) {
 	p.sigma = sigma
 	p.alpha = p.N * p.sigma * p.A
@@ -58,14 +61,57 @@ func (p *ModelParams) SetT(T float64) {
 	p.k = (2200 * 0.0019858775 * p.T) / p.N
 }
 
+func (p *ModelParams) SetNucleationFreeEnergy(a float64) {
+	p.a = a
+}
+
+func (p *ModelParams) Sigma() float64 {
+	return p.sigma
+}
+
+func (p *ModelParams) NucleationFreeEnergy() float64 {
+	return p.a
+}
+
+// Alpha is the linking difference imposed on the domain, N*sigma*A
+func (p *ModelParams) Alpha() float64 {
+	return p.alpha
+}
+
+// K is the torsional stiffness of the domain, which scales with T/N
+func (p *ModelParams) K() float64 {
+	return p.k
+}
+
+// HomopolymerOverride returns the constant base pairing energy and whether it replaces the sequence energies
+func (p *ModelParams) HomopolymerOverride() (float64, bool) {
+	return p.overrideEnergy, p.homopolymerOverride
+}
+
+// BasePairEnergyTable returns the energy of each dinucleotide step of the sequence, keyed by its two bases,
+// as the model applies it, after any homopolymer override
+func (p *ModelParams) BasePairEnergyTable() map[string]float64 {
+	table := make(map[string]float64, 16)
+	for _, first := range "ACGT" {
+		for _, second := range "ACGT" {
+			table[string([]rune{first, second})] = p.computeBpsInterval(first, second)
+		}
+	}
+	return table
+}
+
 func (p *ModelParams) SetHomopolymerOverride(energy float64) {
 	p.homopolymerOverride = true
 	p.overrideEnergy = energy
 }
 
+// gasConstant is the gas constant in Kcal/(mol K)
+const gasConstant = 0.0019858775
+
+// computeBoltzmannFactor returns exp(-E/RT) for a free energy E in Kcal/mol at T Kelvin
 func computeBoltzmannFactor(E float64, T float64) float64 {
-	R := 0.0019858775
-	return math.Exp(-1 * E / R * T)
+	R := gasConstant
+	return math.Exp(-1 * E / (R * T))
 }
 
 // computeBpsInterval takes two characters representing DNA bases and returns the energy
@@ -117,18 +163,47 @@ func (p *ModelParams) computeBpsInterval(first rune, second rune) float64 {
 	}
 }
 
-// ComputeStructure computes the free energy of a structure
-// handles structures that cross circular boundaries automatically
-func (p *ModelParams) ComputeStructure(seq []rune, w Window, structure *Structure) {
-	var nBases int
-	if w.End > w.Start { // if structure doesn't cross circular boundary
-		nBases = w.End - w.Start + 1
-	} else { // else structure includes the boundary of a circular dna piece
-		nBases = len(seq) - w.Start + w.End // TODO: test
+// superhelicalEnergy returns the torsional free energy of the domain when nBases are held in R-loops.
+// it depends only on the number of bases, not on where they are.
+func (p *ModelParams) superhelicalEnergy(nBases int) float64 {
+	return 2 * math.Pow(math.Pi, 2) * p.C * p.k * math.Pow(p.alpha+float64(nBases)*p.A, 2) /
+		(4*math.Pow(math.Pi, 2)*p.C + p.k*float64(nBases))
+}
+
+// bpEnergyPrefix returns prefix sums of the base pairing energy of every dinucleotide in seq, including the
+// one that joins the last base to the first. the base pairing energy of a window is then windowBpEnergy.
+func (p *ModelParams) bpEnergyPrefix(seq []rune) []float64 {
+	prefix := make([]float64, len(seq)+1)
+	for i := range seq {
+		prefix[i+1] = prefix[i] + p.computeBpsInterval(seq[i], seq[(i+1)%len(seq)])
 	}
+	return prefix
+}
 
-	freeEnergy := 2 * math.Pow(math.Pi, 2) * p.C * p.k * math.Pow(p.alpha+float64(nBases)+p.A, 2) /
-		(4*math.Pow(math.Pi, 2)*p.C + p.k*float64(nBases))
+// windowBpEnergy returns the base pairing energy of w from prefix sums computed by bpEnergyPrefix, giving
+// the same result as the dinucleotide walk in ComputeStructure
+func windowBpEnergy(prefix []float64, w Window) float64 {
+	if w.End >= w.Start {
+		return prefix[w.End] - prefix[w.Start]
+	}
+	return prefix[len(prefix)-1] - prefix[w.Start] + prefix[w.End]
+}
+
+// windowLength returns the number of bases in w on a sequence of length n
+func windowLength(n int, w Window) int {
+	if w.End >= w.Start {
+		return w.End - w.Start + 1
+	}
+	return n - w.Start + w.End + 1
+}
+
+// ComputeStructure computes the free energy of a structure: the torsional energy left in the domain with
+// the loop open plus the base pairing energy of its dinucleotides. its Boltzmann factor weighs that whole
+// energy. handles structures that cross circular boundaries automatically
+func (p *ModelParams) ComputeStructure(seq []rune, w Window, structure *Structure) {
+	nBases := windowLength(len(seq), w)
+
+	freeEnergy := p.superhelicalEnergy(nBases)
 
 	var bpEnergy float64
 	for i := w.Start; i != w.End; { // TODO: test
@@ -144,14 +219,19 @@ func (p *ModelParams) ComputeStructure(seq []rune, w Window, structure *Structur
 		}
 	}
 
+	structure.Length = nBases
 	structure.FreeEnergy = freeEnergy + bpEnergy
-	structure.BoltzmannFactor = computeBoltzmannFactor(freeEnergy, p.T)
+	structure.BoltzmannFactor = computeBoltzmannFactor(structure.FreeEnergy, p.T)
 }
 
 func (p *ModelParams) GroundStateFactor() float64 {
-	return computeBoltzmannFactor(p.k*math.Pow(p.alpha, 2)-p.a, p.T)
+	return computeBoltzmannFactor(p.GroundStateEnergy(), p.T)
 }
 
+// GroundStateEnergy is the torsional energy of the unopened domain, k*alpha^2/2, which is what
+// superhelicalEnergy gives for a loop of length zero, less the nucleation energy. the ground state and the
+// loops have to share that reference: with k*alpha^2 relaxed DNA came out less likely to form R-loops than
+// overwound DNA.
 func (p *ModelParams) GroundStateEnergy() float64 {
-	return p.k*math.Pow(p.alpha, 2) - p.a
+	return p.superhelicalEnergy(0) - p.a
 }
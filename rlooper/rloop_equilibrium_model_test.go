@@ -0,0 +1,104 @@
+package rlooper
+
+import (
+	"math"
+	"testing"
+)
+
+func TestComputeBoltzmannFactor(t *testing.T) {
+	// an energy of RT weighs 1/e. dividing by R*T without parentheses multiplied by T instead, which gave
+	// exp(-T^2) here
+	T := 310.0
+	if got, want := computeBoltzmannFactor(gasConstant*T, T), math.Exp(-1); math.Abs(got-want) > 1e-12 {
+		t.Errorf("Boltzmann factor of RT = %v, want %v", got, want)
+	}
+	if got := computeBoltzmannFactor(0, T); got != 1 {
+		t.Errorf("Boltzmann factor of 0 = %v, want 1", got)
+	}
+}
+
+func TestSuperhelicalEnergyRelaxedByLoop(t *testing.T) {
+	// a loop of n bases unwinds n*A turns, so the torsional energy vanishes when the loop takes up the whole
+	// linking difference alpha: at N*|sigma| = 105 bases for the defaults. adding A to n rather than
+	// multiplying by it put the minimum at about 10 bases, independent of the helical repeat.
+	model := NewParamsReasonableDefaults()
+	relaxing := int(math.Round(-model.Alpha() / model.A))
+	if relaxing != 105 {
+		t.Fatalf("expected the defaults to be relaxed by a loop of 105 bases, got %d", relaxing)
+	}
+	if e := model.superhelicalEnergy(relaxing); math.Abs(e) > 1e-9 {
+		t.Errorf("torsional energy with a loop of %d bases = %v, want 0", relaxing, e)
+	}
+	if !(model.superhelicalEnergy(relaxing-10) > 0 && model.superhelicalEnergy(relaxing+10) > 0) {
+		t.Errorf("expected the torsional energy to rise on both sides of %d bases", relaxing)
+	}
+	if model.superhelicalEnergy(10) < model.superhelicalEnergy(50) {
+		t.Error("expected a 10 base loop to leave more torsional energy than a 50 base loop")
+	}
+}
+
+func TestComputeStructureCircularWindow(t *testing.T) {
+	// a window across the end of a circular sequence is the same loop as in the sequence rotated to start
+	// there. the wrapped window used to count one base fewer than it covers.
+	model := NewParamsReasonableDefaults()
+	seq := []rune("GATTACAGGGCCCA")
+	rotated := append(append([]rune{}, seq[12:]...), seq[:12]...)
+
+	var wrapped, linear Structure
+	model.ComputeStructure(seq, Window{Start: 12, End: 1}, &wrapped)
+	model.ComputeStructure(rotated, Window{Start: 0, End: 3}, &linear)
+	if wrapped.Length != 4 || linear.Length != 4 {
+		t.Errorf("expected both windows to cover 4 bases, got %d wrapped and %d linear", wrapped.Length, linear.Length)
+	}
+	if math.Abs(wrapped.FreeEnergy-linear.FreeEnergy) > 1e-12 {
+		t.Errorf("wrapped window free energy %v, the same loop in the rotated sequence has %v", wrapped.FreeEnergy, linear.FreeEnergy)
+	}
+}
+
+func TestComputeStructureBoltzmannFactor(t *testing.T) {
+	// the Boltzmann factor weighs the whole free energy. it used to leave out the base pairing energy, so
+	// every window of a given length had the same weight whatever its sequence.
+	model := NewParamsReasonableDefaults()
+	seq := []rune("GGGGGGAAAAAA")
+	var gc, at Structure
+	model.ComputeStructure(seq, Window{Start: 0, End: 4}, &gc)
+	model.ComputeStructure(seq, Window{Start: 6, End: 10}, &at)
+	for _, s := range []Structure{gc, at} {
+		if want := computeBoltzmannFactor(s.FreeEnergy, model.T); math.Abs(s.BoltzmannFactor-want) > 1e-12*want {
+			t.Errorf("Boltzmann factor %v, want %v for free energy %v", s.BoltzmannFactor, want, s.FreeEnergy)
+		}
+	}
+	if gc.BoltzmannFactor == at.BoltzmannFactor {
+		t.Error("expected loops of the same length with different sequences to have different weights")
+	}
+}
+
+func TestComputeStructureSingleBase(t *testing.T) {
+	// a window starting and ending on the same base is a loop of one base, not one around the whole circle
+	model := NewParamsReasonableDefaults()
+	seq := []rune("GATTACAGGGCCCA")
+	var s Structure
+	model.ComputeStructure(seq, Window{Start: 3, End: 3}, &s)
+	if s.Length != 1 {
+		t.Errorf("expected a one base window to have length 1, got %d", s.Length)
+	}
+	if want := model.superhelicalEnergy(1); s.FreeEnergy != want {
+		t.Errorf("one base window free energy %v, want the torsional energy of one base %v", s.FreeEnergy, want)
+	}
+}
+
+func TestGroundStateEnergy(t *testing.T) {
+	// the unopened domain holds k*alpha^2/2, the torsional energy superhelicalEnergy gives a loop of length
+	// zero. k*alpha^2, used before, counted that torsion twice relative to the loops, which made overwound
+	// DNA more likely to form R-loops than relaxed DNA.
+	model := NewParamsReasonableDefaults()
+	if got, want := model.GroundStateEnergy(), 36.017974039617236; math.Abs(got-want) > 1e-9 {
+		t.Errorf("ground state energy of the defaults = %v, want %v", got, want)
+	}
+	for _, sigma := range []float64{-0.07, 0, 0.05} {
+		model.SetSuperhelicity(sigma)
+		if e := model.superhelicalEnergy(0) - model.a; math.Abs(model.GroundStateEnergy()-e) > 1e-12 {
+			t.Errorf("sigma=%g: ground state energy %v, want the torsional energy of an empty loop %v", sigma, model.GroundStateEnergy(), e)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package rlooper
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// SamplerParams configures the Metropolis sampler used by SampleEnsemble
+type SamplerParams struct {
+	Steps    int   // steps per chain after burn-in
+	BurnIn   int   // steps discarded at the start of every chain
+	Seed     int64 // chain i is seeded with Seed+i
+	MaxLoops int   // maximum number of simultaneous loops in a configuration
+	Batches  int   // batches per chain used for the batch means confidence intervals
+	MaxShift int   // largest boundary or translation move in bases
+}
+
+// NewSamplerParamsReasonableDefaults returns sampler settings suitable for domains of a few kb
+func NewSamplerParamsReasonableDefaults() SamplerParams {
+	return SamplerParams{
+		Steps:    1000000,
+		BurnIn:   100000,
+		Seed:     1,
+		MaxLoops: 1,
+		Batches:  10,
+		MaxShift: 10,
+	}
+}
+
+// SampledResult holds per-base probabilities estimated by SampleEnsemble, with 95% confidence intervals
+// computed from batch means pooled across all chains
+type SampledResult struct {
+	Chains               int
+	BpProbability        []float64
+	ConfidenceLow        []float64
+	ConfidenceHigh       []float64
+	LoopCountProbability []float64 // index k is the fraction of samples with exactly k loops
+	AcceptanceRate       float64
+}
+
+// sampledLoop is a loop in a sampled configuration, stored by start and length so that moves don't need
+// to care whether the loop crosses the circular boundary
+type sampledLoop struct {
+	start  int
+	length int
+}
+
+// sampleChain is a single Metropolis chain over configurations of non-overlapping loops
+type sampleChain struct {
+	seq    []rune
+	model  *ModelParams
+	prefix []float64
+	wp     WindowParams
+	params SamplerParams
+	rng    *rand.Rand
+
+	loops    []sampledLoop
+	spare    []sampledLoop // holds proposals, swapped with loops when one is accepted
+	energy   float64
+	accepted int
+	proposed int
+}
+
+// nWindowProposals is the number of (start, length) pairs a birth move draws from uniformly. in linear
+// mode some of them run off the end of the sequence and are rejected outright.
+func (c *sampleChain) nWindowProposals() float64 {
+	return float64(len(c.seq)) * float64(c.wp.maxLength(len(c.seq))-c.wp.MinLength+1)
+}
+
+func (c *sampleChain) window(l sampledLoop) Window {
+	return Window{Start: l.start, End: (l.start + l.length - 1) % len(c.seq)}
+}
+
+// valid reports whether l fits the sequence and doesn't overlap any loop in loops other than skip
+func (c *sampleChain) valid(loops []sampledLoop, l sampledLoop, skip int) bool {
+	n := len(c.seq)
+	if l.length < c.wp.MinLength || l.length > c.wp.maxLength(n) || l.start < 0 || l.start >= n {
+		return false
+	}
+	if !c.wp.Circular && l.start+l.length > n {
+		return false
+	}
+	if !c.wp.initiates(c.window(l)) {
+		return false
+	}
+	for i, other := range loops {
+		if i == skip {
+			continue
+		}
+		// two arcs on a circle of n bases overlap when either one starts inside the other
+		if (other.start-l.start+n)%n < l.length || (l.start-other.start+n)%n < other.length {
+			return false
+		}
+	}
+	return true
+}
+
+// configurationEnergy returns the free energy of a configuration, consistent with ComputeMultiLoopEnsemble.
+// length priors are folded in as the energy whose Boltzmann factor is the prior weight.
+func (c *sampleChain) configurationEnergy(loops []sampledLoop) float64 {
+	if len(loops) == 0 {
+		return c.model.GroundStateEnergy()
+	}
+	totalLength, bpEnergy, priorEnergy := 0, 0.0, 0.0
+	for _, l := range loops {
+		totalLength += l.length
+		bpEnergy += windowBpEnergy(c.prefix, c.window(l)) + c.wp.adjustmentEnergy(len(c.seq), c.window(l))
+		priorEnergy -= gasConstant * c.model.T * math.Log(c.wp.lengthWeight(l.length))
+	}
+	return c.model.superhelicalEnergy(totalLength) + bpEnergy + float64(len(loops)-1)*c.model.a + priorEnergy
+}
+
+// accept applies the Metropolis-Hastings rule to a proposal with the given proposal ratio
+func (c *sampleChain) accept(proposal []sampledLoop, proposalRatio float64) {
+	c.proposed++
+	energy := c.configurationEnergy(proposal)
+	ratio := proposalRatio * computeBoltzmannFactor(energy-c.energy, c.model.T)
+	if ratio >= 1 || c.rng.Float64() < ratio {
+		c.loops, c.spare = proposal, c.loops
+		c.energy = energy
+		c.accepted++
+	}
+}
+
+// shift draws a non-zero move size in [-MaxShift, MaxShift]
+func (c *sampleChain) shift() int {
+	d := 1 + c.rng.Intn(c.params.MaxShift)
+	if c.rng.Intn(2) == 0 {
+		return -d
+	}
+	return d
+}
+
+// step proposes one move. birth and death are chosen with equal probability so that their proposal
+// ratio reduces to the number of window proposals over the number of loops; every other move is symmetric.
+func (c *sampleChain) step() {
+	n := len(c.seq)
+	k := len(c.loops)
+	proposal := c.spare[:k]
+	copy(proposal, c.loops)
+
+	switch c.rng.Intn(5) {
+	case 0: // birth
+		if k >= c.params.MaxLoops {
+			return
+		}
+		l := sampledLoop{start: c.rng.Intn(n), length: c.wp.MinLength + c.rng.Intn(c.wp.maxLength(n)-c.wp.MinLength+1)}
+		if !c.valid(c.loops, l, -1) {
+			c.proposed++
+			return
+		}
+		c.accept(append(proposal, l), c.nWindowProposals()/float64(k+1))
+	case 1: // death
+		if k == 0 {
+			return
+		}
+		i := c.rng.Intn(k)
+		proposal = append(proposal[:i], proposal[i+1:]...)
+		c.accept(proposal, float64(k)/c.nWindowProposals())
+	default: // move the start, the end, or the whole of one loop
+		if k == 0 {
+			return
+		}
+		i := c.rng.Intn(k)
+		l := proposal[i]
+		d := c.shift()
+		switch c.rng.Intn(3) {
+		case 0:
+			l.start, l.length = l.start+d, l.length-d
+		case 1:
+			l.length += d
+		default:
+			l.start += d
+		}
+		if c.wp.Circular {
+			l.start = (l.start%n + n) % n
+		}
+		if !c.valid(c.loops, l, i) {
+			c.proposed++
+			return
+		}
+		proposal[i] = l
+		c.accept(proposal, 1)
+	}
+}
+
+// addCoverage records the current configuration in a per-base difference array
+func (c *sampleChain) addCoverage(diff []float64) {
+	for _, l := range c.loops {
+		addCoverage(diff, c.window(l), 1)
+	}
+}
+
+// cancelCheckSteps is how many steps a chain takes between checks for cancellation
+const cancelCheckSteps = 4096
+
+// SampleEnsemble estimates per-base R-loop probabilities by Metropolis sampling over loop positions rather
+// than enumerating every window, which makes domains of 100 kb and more tractable. one chain is run per
+// ExecutionContext thread; results are reproducible for a given seed and thread count.
+func (g *Gene) SampleEnsemble(ec *ExecutionContext, model *ModelParams, wp WindowParams, params SamplerParams) (*SampledResult, error) {
+	numChains := ec.NumThreads
+	if numChains <= 0 {
+		numChains = 1
+	}
+	if params.MaxLoops < 1 {
+		params.MaxLoops = 1
+	}
+	if params.Batches < 1 {
+		params.Batches = 1
+	}
+	if params.MaxShift < 1 {
+		params.MaxShift = 1
+	}
+	if params.Steps < params.Batches {
+		return nil, fmt.Errorf("%d sampling steps cannot be split into %d batches for the confidence intervals; sample at least %d steps", params.Steps, params.Batches, params.Batches)
+	}
+	n := len(g.Sequence)
+	if wp.maxLength(n) < wp.MinLength {
+		// no loop fits the sequence, so every sample is the ground state, as the other engines find
+		result := &SampledResult{
+			Chains:               numChains,
+			BpProbability:        make([]float64, n),
+			ConfidenceLow:        make([]float64, n),
+			ConfidenceHigh:       make([]float64, n),
+			LoopCountProbability: make([]float64, params.MaxLoops+1),
+		}
+		result.LoopCountProbability[0] = 1
+		return result, nil
+	}
+	prefix := model.bpEnergyPrefix(g.Sequence)
+	// the steps are split as evenly as possible, the first batches taking one more when they don't divide
+	batchSteps := make([]int, params.Batches)
+	for b := range batchSteps {
+		batchSteps[b] = params.Steps / params.Batches
+		if b < params.Steps%params.Batches {
+			batchSteps[b]++
+		}
+	}
+
+	// batch diff arrays and loop counts per chain, merged in chain order afterwards
+	batches := make([][][]float64, numChains)
+	loopCounts := make([][]int, numChains)
+	chains := make([]*sampleChain, numChains)
+
+	ec.WaitGroup.Add(numChains)
+	for ci := 0; ci < numChains; ci++ {
+		go func(ci int) {
+			defer ec.WaitGroup.Done()
+			c := &sampleChain{
+				seq:    g.Sequence,
+				model:  model,
+				prefix: prefix,
+				wp:     wp,
+				params: params,
+				rng:    rand.New(rand.NewSource(params.Seed + int64(ci))),
+				loops:  make([]sampledLoop, 0, params.MaxLoops+1),
+				spare:  make([]sampledLoop, 0, params.MaxLoops+1),
+			}
+			c.energy = c.configurationEnergy(nil)
+			for s := 0; s < params.BurnIn; s++ {
+				if s%cancelCheckSteps == 0 && ec.Err() != nil {
+					return
+				}
+				c.step()
+			}
+			c.accepted, c.proposed = 0, 0
+
+			counts := make([]int, params.MaxLoops+1)
+			chainBatches := make([][]float64, params.Batches)
+			for b := range chainBatches {
+				chainBatches[b] = make([]float64, n+1)
+				for s := 0; s < batchSteps[b]; s++ {
+					if s%cancelCheckSteps == 0 && ec.Err() != nil {
+						return
+					}
+					c.step()
+					c.addCoverage(chainBatches[b])
+					counts[len(c.loops)]++
+				}
+			}
+			batches[ci] = chainBatches
+			loopCounts[ci] = counts
+			chains[ci] = c
+		}(ci)
+	}
+	ec.WaitGroup.Wait()
+	if err := ec.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &SampledResult{
+		Chains:               numChains,
+		BpProbability:        make([]float64, n),
+		ConfidenceLow:        make([]float64, n),
+		ConfidenceHigh:       make([]float64, n),
+		LoopCountProbability: make([]float64, params.MaxLoops+1),
+	}
+
+	var means [][]float64
+	for ci := range batches {
+		for b, diff := range batches[ci] {
+			means = append(means, coverageFromDiff(diff, float64(batchSteps[b])))
+		}
+	}
+	m := float64(len(means))
+	for i := 0; i < n; i++ {
+		sum, sumSquares := 0.0, 0.0
+		for _, batch := range means {
+			sum += batch[i]
+			sumSquares += batch[i] * batch[i]
+		}
+		mean := sum / m
+		halfWidth := 0.0
+		if m > 1 {
+			variance := math.Max(0, (sumSquares-m*mean*mean)/(m-1))
+			halfWidth = 1.96 * math.Sqrt(variance/m)
+		}
+		result.BpProbability[i] = mean
+		result.ConfidenceLow[i] = math.Max(0, mean-halfWidth)
+		result.ConfidenceHigh[i] = math.Min(1, mean+halfWidth)
+	}
+
+	totalSamples, accepted, proposed := 0, 0, 0
+	for ci := range chains {
+		for k, count := range loopCounts[ci] {
+			result.LoopCountProbability[k] += float64(count)
+			totalSamples += count
+		}
+		accepted += chains[ci].accepted
+		proposed += chains[ci].proposed
+	}
+	for k := range result.LoopCountProbability {
+		result.LoopCountProbability[k] /= float64(totalSamples)
+	}
+	if proposed > 0 {
+		result.AcceptanceRate = float64(accepted) / float64(proposed)
+	}
+	return result, nil
+}
@@ -0,0 +1,124 @@
+package rlooper
+
+import (
+	"math"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestSampleEnsemble(t *testing.T) {
+	gene := &Gene{Sequence: []rune("GGGCTTAGCCATTGCGCAAT")}
+	// same favorable model as TestComputeMultiLoopEnsemble, so that two-loop states are visited often
+	model := NewParamsReasonableDefaults()
+	model.SetN(40)
+	model.SetHomopolymerOverride(-0.4)
+	model.SetNucleationFreeEnergy(2)
+	ec := &ExecutionContext{
+		NumThreads: 4,
+		WaitGroup:  &sync.WaitGroup{},
+	}
+	params := NewSamplerParamsReasonableDefaults()
+	params.Steps = 200000
+	params.BurnIn = 10000
+	params.MaxLoops = 2
+	params.MaxShift = 3
+
+	for _, circular := range []bool{false, true} {
+		wp := WindowParams{MinLength: 3, Circular: circular}
+		exact, err := gene.ComputeMultiLoopEnsemble(ec, &model, wp, 2)
+		if err != nil {
+			t.Fatalf("ComputeMultiLoopEnsemble returned error: %v", err)
+		}
+		sampled, err := gene.SampleEnsemble(ec, &model, wp, params)
+		if err != nil {
+			t.Fatalf("SampleEnsemble returned error: %v", err)
+		}
+
+		for i := range gene.Sequence {
+			if math.Abs(sampled.BpProbability[i]-exact.BpProbability[i]) > 0.02 {
+				t.Errorf("circular=%v: sampled probability at %d = %v, exact %v", circular, i, sampled.BpProbability[i], exact.BpProbability[i])
+			}
+			if sampled.ConfidenceLow[i] > sampled.BpProbability[i] || sampled.ConfidenceHigh[i] < sampled.BpProbability[i] {
+				t.Errorf("circular=%v: confidence interval at %d does not contain the estimate", circular, i)
+			}
+		}
+		for k := range exact.LoopCountProbability {
+			if math.Abs(sampled.LoopCountProbability[k]-exact.LoopCountProbability[k]) > 0.02 {
+				t.Errorf("circular=%v: sampled P(%d loops) = %v, exact %v", circular, k, sampled.LoopCountProbability[k], exact.LoopCountProbability[k])
+			}
+		}
+	}
+}
+
+func TestSampleEnsembleReproducible(t *testing.T) {
+	gene := &Gene{Sequence: []rune("GATTACAGATTACAGATTACA")}
+	model := NewParamsReasonableDefaults()
+	ec := &ExecutionContext{
+		NumThreads: 2,
+		WaitGroup:  &sync.WaitGroup{},
+	}
+	params := NewSamplerParamsReasonableDefaults()
+	params.Steps = 5000
+	params.BurnIn = 500
+
+	first, _ := gene.SampleEnsemble(ec, &model, WindowParams{MinLength: 2}, params)
+	second, _ := gene.SampleEnsemble(ec, &model, WindowParams{MinLength: 2}, params)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("expected identical results for the same seed")
+	}
+}
+
+func TestSampleEnsembleSteps(t *testing.T) {
+	gene := &Gene{Sequence: []rune("GATTACAGATTACAGATTACA")}
+	model := NewParamsReasonableDefaults()
+	ec := &ExecutionContext{
+		NumThreads: 2,
+		WaitGroup:  &sync.WaitGroup{},
+	}
+	params := NewSamplerParamsReasonableDefaults()
+	params.BurnIn = 100
+
+	params.Steps = params.Batches - 1
+	if _, err := gene.SampleEnsemble(ec, &model, WindowParams{MinLength: 2}, params); err == nil {
+		t.Error("expected fewer steps than batches to be rejected")
+	}
+
+	// every requested step is sampled when the steps don't divide into the batches
+	params.Steps = 10*params.Batches + 3
+	result, err := gene.SampleEnsemble(ec, &model, WindowParams{MinLength: 2}, params)
+	if err != nil {
+		t.Fatalf("SampleEnsemble returned error: %v", err)
+	}
+	samples := float64(ec.NumThreads * params.Steps)
+	for k, p := range result.LoopCountProbability {
+		if count := p * samples; math.Abs(count-math.Round(count)) > 1e-6 {
+			t.Errorf("P(%d loops) = %v is not a whole number of %v samples", k, p, samples)
+		}
+	}
+}
+
+func TestSampleEnsembleShorterThanMinLength(t *testing.T) {
+	// a record shorter than the minimum loop length has no loops to sample, which used to panic in a chain
+	gene := &Gene{Sequence: []rune("GATTA")}
+	model := NewParamsReasonableDefaults()
+	ec := &ExecutionContext{
+		NumThreads: 2,
+		WaitGroup:  &sync.WaitGroup{},
+	}
+	params := NewSamplerParamsReasonableDefaults()
+	params.Steps = 100
+	params.BurnIn = 10
+	result, err := gene.SampleEnsemble(ec, &model, WindowParams{MinLength: 10}, params)
+	if err != nil {
+		t.Fatalf("SampleEnsemble returned error: %v", err)
+	}
+	for i, p := range result.BpProbability {
+		if p != 0 || result.ConfidenceLow[i] != 0 || result.ConfidenceHigh[i] != 0 {
+			t.Errorf("expected probability 0 at %d, got %v [%v, %v]", i, p, result.ConfidenceLow[i], result.ConfidenceHigh[i])
+		}
+	}
+	if len(result.BpProbability) != 5 || result.LoopCountProbability[0] != 1 {
+		t.Errorf("expected 5 bases in the ground state, got %d bases and P(0 loops) = %v", len(result.BpProbability), result.LoopCountProbability[0])
+	}
+}
@@ -2,6 +2,7 @@ package rlooper
 
 type Structure struct {
 	Pos             Loci
+	Length          int
 	FreeEnergy      float64
 	BoltzmannFactor float64
 	Probability     float64
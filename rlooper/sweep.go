@@ -0,0 +1,66 @@
+package rlooper
+
+// SweepCondition is one combination of the model parameters varied by a sweep
+type SweepCondition struct {
+	Sigma      float64 // superhelical density
+	N          float64 // superhelical domain size in bases
+	Nucleation float64 // nucleation free energy a in Kcal/mol
+	T          float64 // temperature in Kelvin
+}
+
+// Apply returns a copy of model with the parameters of the condition set
+func (c SweepCondition) Apply(model ModelParams) ModelParams {
+	model.SetT(c.T)
+	model.SetN(c.N)
+	model.SetSuperhelicity(c.Sigma)
+	model.SetNucleationFreeEnergy(c.Nucleation)
+	return model
+}
+
+// SweepEnsembles computes the single-loop ensemble under every condition, as ComputeEnsembleDP would for
+// model with the condition applied. none of the swept parameters touch base pairing, so the sequence
+// energies are computed once and shared, and only the torsional term is recomputed per condition.
+// conditions are spread across workers, each running one condition at a time; results are in the order of
+// conditions.
+func (g *Gene) SweepEnsembles(ec *ExecutionContext, model *ModelParams, wp WindowParams, conditions []SweepCondition) ([]*EnsembleResult, error) {
+	numThreads := ec.NumThreads
+	if numThreads <= 0 {
+		numThreads = 1
+	}
+	n := len(g.Sequence)
+	prefix := model.bpEnergyPrefix(g.Sequence)
+	results := make([]*EnsembleResult, len(conditions))
+
+	indices := make(chan int)
+	go func() {
+		defer close(indices)
+		for i := range conditions {
+			select {
+			case indices <- i:
+			case <-ec.done():
+				return
+			}
+		}
+	}()
+
+	ec.WaitGroup.Add(numThreads)
+	for t := 0; t < numThreads; t++ {
+		go func() {
+			defer ec.WaitGroup.Done()
+			for i := range indices {
+				condition := conditions[i].Apply(*model)
+				diff := make([]float64, n+1)
+				z := condition.GroundStateFactor()
+				for length := wp.dpMinLength(); length <= wp.maxLength(n); length++ {
+					z += accumulateLength(&condition, prefix, wp, length, diff)
+				}
+				results[i] = newEnsembleResult(&condition, diff, z)
+			}
+		}()
+	}
+	ec.WaitGroup.Wait()
+	if err := ec.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
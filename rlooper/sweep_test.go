@@ -0,0 +1,47 @@
+package rlooper
+
+import (
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestSweepEnsembles(t *testing.T) {
+	gene := &Gene{Sequence: []rune("GGGCTTAGCCATTGCGCAATCCGGATTAGCAGGTTTACGCGCATTAGGCCCT")}
+	model := NewParamsReasonableDefaults()
+	ec := &ExecutionContext{
+		NumThreads: 3,
+		WaitGroup:  &sync.WaitGroup{},
+	}
+	wp := WindowParams{MinLength: 3, MaxLength: 30, Circular: true}
+	conditions := []SweepCondition{
+		{Sigma: -0.07, N: 1500, Nucleation: 10, T: 310},
+		{Sigma: 0, N: 1500, Nucleation: 10, T: 310},
+		{Sigma: -0.05, N: 200, Nucleation: 8, T: 300},
+		{Sigma: 0.03, N: 60, Nucleation: 12, T: 320},
+	}
+
+	results, err := gene.SweepEnsembles(ec, &model, wp, conditions)
+	if err != nil {
+		t.Fatalf("SweepEnsembles returned error: %v", err)
+	}
+	if len(results) != len(conditions) {
+		t.Fatalf("expected %d results, got %d", len(conditions), len(results))
+	}
+	for i, c := range conditions {
+		conditionModel := c.Apply(model)
+		expected, err := gene.ComputeEnsembleDP(ec, &conditionModel, wp)
+		if err != nil {
+			t.Fatalf("ComputeEnsembleDP returned error: %v", err)
+		}
+		if math.Abs(results[i].LoopProbability()-expected.LoopProbability()) > 1e-12 {
+			t.Errorf("condition %d: P(loop) %v, want %v", i, results[i].LoopProbability(), expected.LoopProbability())
+		}
+		for j := range expected.BpProbability {
+			if math.Abs(results[i].BpProbability[j]-expected.BpProbability[j]) > 1e-12 {
+				t.Errorf("condition %d, base %d: probability %v, want %v", i, j, results[i].BpProbability[j], expected.BpProbability[j])
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package rlooper
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// rankedBelow orders structures by Boltzmann factor, breaking ties by position and then length so that
+// the top structures do not depend on the order workers deliver them in
+func rankedBelow(a, b Structure) bool {
+	if a.BoltzmannFactor != b.BoltzmannFactor {
+		return a.BoltzmannFactor < b.BoltzmannFactor
+	}
+	if a.Pos.StartPos != b.Pos.StartPos {
+		return a.Pos.StartPos > b.Pos.StartPos
+	}
+	return a.Length > b.Length
+}
+
+// structureHeap is a min-heap with the lowest ranked structure on top
+type structureHeap []Structure
+
+func (h structureHeap) Len() int           { return len(h) }
+func (h structureHeap) Less(i, j int) bool { return rankedBelow(h[i], h[j]) }
+func (h structureHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *structureHeap) Push(x any)        { *h = append(*h, x.(Structure)) }
+func (h *structureHeap) Pop() any {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
+
+// TopStructures returns the k most probable single loop structures, most probable first, along with the
+// partition function. a single pass sums the partition function while keeping the k best, so the
+// ensemble never has to be held in memory.
+func (g *Gene) TopStructures(ec *ExecutionContext, model *ModelParams, wp WindowParams, k int) ([]Structure, float64, error) {
+	z := model.GroundStateFactor()
+	top := make(structureHeap, 0, k)
+	err := g.streamStructures(ec, model, wp, func(batch []Structure) error {
+		for _, s := range batch {
+			z += s.BoltzmannFactor
+			if k <= 0 {
+				continue
+			}
+			if len(top) < k {
+				heap.Push(&top, s)
+			} else if rankedBelow(top[0], s) {
+				top[0] = s
+				heap.Fix(&top, 0)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sort.Slice(top, func(i, j int) bool { return rankedBelow(top[j], top[i]) })
+	for i := range top {
+		top[i].Probability = top[i].BoltzmannFactor / z
+	}
+	return top, z, nil
+}
@@ -0,0 +1,49 @@
+package rlooper
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestTopStructures(t *testing.T) {
+	gene := &Gene{Sequence: []rune("GATTACAGGGCCCGATTACAGGGAAATTTCCC")}
+	model := NewParamsReasonableDefaults()
+	wp := WindowParams{MinLength: 2}
+	ec := &ExecutionContext{
+		NumThreads: 3,
+		WaitGroup:  &sync.WaitGroup{},
+	}
+
+	all := gene.computeStructuresSerial(&model, wp)
+	sort.Slice(all, func(i, j int) bool { return rankedBelow(all[j], all[i]) })
+	zExpected, _ := gene.PartitionFunction(ec, &model, wp)
+
+	top, z, err := gene.TopStructures(ec, &model, wp, 5)
+	if err != nil {
+		t.Fatalf("TopStructures returned error: %v", err)
+	}
+	if math.Abs(z-zExpected)/zExpected > 1e-12 {
+		t.Errorf("expected partition function %v, got %v", zExpected, z)
+	}
+	if len(top) != 5 {
+		t.Fatalf("expected 5 structures, got %d", len(top))
+	}
+	for i, s := range top {
+		if s.Pos != all[i].Pos || s.Length != all[i].Length {
+			t.Errorf("structure %d: expected %+v, got %+v", i, all[i], s)
+		}
+		if math.Abs(s.Probability-s.BoltzmannFactor/zExpected) > 1e-15 {
+			t.Errorf("structure %d: probability %v not normalized", i, s.Probability)
+		}
+	}
+
+	top, _, err = gene.TopStructures(ec, &model, wp, len(all)+10)
+	if err != nil || len(top) != len(all) {
+		t.Errorf("expected every structure when k exceeds the ensemble, got %d (err %v)", len(top), err)
+	}
+	if top, _, _ = gene.TopStructures(ec, &model, wp, 0); len(top) != 0 {
+		t.Errorf("expected no structures for k=0, got %d", len(top))
+	}
+}
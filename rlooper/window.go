@@ -1,16 +1,184 @@
 package rlooper
 
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
 type Window struct {
 	Start int
 	End   int
 }
 
+// WindowParams restricts which windows are considered as R-loops, how their lengths are weighted and which
+// sequence dependent energy terms apply to them. every engine honors the same settings, so their partition
+// functions stay comparable.
+type WindowParams struct {
+	MinLength   int
+	MaxLength   int // 0 leaves loop length uncapped
+	Circular    bool
+	LengthPrior LengthPrior // nil weights every length equally
+
+	// Initiation, when set, marks the positions where loops may initiate. loops extend in the direction of
+	// transcription from there: they initiate at their first base, or at their last base when
+	// InitiateFromEnd is set for genes transcribed toward lower positions.
+	Initiation      []bool
+	InitiateFromEnd bool
+
+	// Adjustments are added to the free energy of every window on top of the model
+	Adjustments []EnergyAdjustment
+}
+
+// EnergyAdjustment is a sequence dependent free energy term in Kcal/mol that the model itself doesn't know
+// about, such as a bonus for structures forming on the displaced strand
+type EnergyAdjustment interface {
+	WindowEnergy(n int, w Window) float64
+}
+
+// adjustmentEnergy returns the sum of every adjustment in wp for w on a sequence of n bases
+func (wp WindowParams) adjustmentEnergy(n int, w Window) float64 {
+	energy := 0.0
+	for _, a := range wp.Adjustments {
+		energy += a.WindowEnergy(n, w)
+	}
+	return energy
+}
+
+// Zone is an inclusive range of sequence positions
+type Zone struct {
+	Start int
+	End   int
+}
+
+// InitiationMask returns a mask for WindowParams.Initiation that allows initiation inside any of zones on
+// a sequence of n bases. zones are clipped to the sequence.
+func InitiationMask(n int, zones []Zone) []bool {
+	mask := make([]bool, n)
+	for _, z := range zones {
+		for i := max(z.Start, 0); i <= min(z.End, n-1); i++ {
+			mask[i] = true
+		}
+	}
+	return mask
+}
+
+// initiates reports whether w initiates at a position allowed by wp
+func (wp WindowParams) initiates(w Window) bool {
+	if wp.Initiation == nil {
+		return true
+	}
+	if wp.InitiateFromEnd {
+		return wp.Initiation[w.End]
+	}
+	return wp.Initiation[w.Start]
+}
+
+// maxLength returns the longest loop allowed on a sequence of n bases
+func (wp WindowParams) maxLength(n int) int {
+	if wp.MaxLength <= 0 || wp.MaxLength > n {
+		return n
+	}
+	return wp.MaxLength
+}
+
+// lengthWeight returns the prior weight that multiplies the Boltzmann factor of a loop of the given length
+func (wp WindowParams) lengthWeight(length int) float64 {
+	if wp.LengthPrior == nil {
+		return 1
+	}
+	return wp.LengthPrior.Weight(length)
+}
+
+// Windows returns every window allowed by wp, linear windows first
+func (wp WindowParams) Windows(seq []rune) []Window {
+	windows := FromLinearWindows(seq, wp.MinLength, wp.MaxLength)
+	if wp.Circular {
+		windows = append(windows, FromCircularWindows(seq, wp.MinLength, wp.MaxLength)...)
+	}
+	if wp.Initiation == nil {
+		return windows
+	}
+	allowed := windows[:0]
+	for _, w := range windows {
+		if wp.initiates(w) {
+			allowed = append(allowed, w)
+		}
+	}
+	return allowed
+}
+
+// LengthPrior weights R-loops by their length on top of their Boltzmann factor
+type LengthPrior interface {
+	Weight(length int) float64
+	String() string
+}
+
+// NormalLengthPrior is a gaussian over loop length, scaled to a peak weight of 1 so that it only ever
+// penalizes lengths away from the mean
+type NormalLengthPrior struct {
+	Mean float64
+	SD   float64
+}
+
+func (p NormalLengthPrior) Weight(length int) float64 {
+	d := (float64(length) - p.Mean) / p.SD
+	return math.Exp(-d * d / 2)
+}
+
+func (p NormalLengthPrior) String() string {
+	return fmt.Sprintf("normal:%g:%g", p.Mean, p.SD)
+}
+
+// ExponentialLengthPrior decays with loop length on the given length scale
+type ExponentialLengthPrior struct {
+	Scale float64
+}
+
+func (p ExponentialLengthPrior) Weight(length int) float64 {
+	return math.Exp(-float64(length) / p.Scale)
+}
+
+func (p ExponentialLengthPrior) String() string {
+	return fmt.Sprintf("exponential:%g", p.Scale)
+}
+
+// ParseLengthPrior parses a prior written as "normal:MEAN:SD" or "exponential:SCALE". an empty string or
+// "none" returns a nil prior.
+func ParseLengthPrior(s string) (LengthPrior, error) {
+	if s == "" || s == "none" {
+		return nil, nil
+	}
+	fields := strings.Split(s, ":")
+	values := make([]float64, len(fields)-1)
+	for i, f := range fields[1:] {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("invalid length prior %q: parameters must be positive numbers", s)
+		}
+		values[i] = v
+	}
+	switch {
+	case fields[0] == "normal" && len(values) == 2:
+		return NormalLengthPrior{Mean: values[0], SD: values[1]}, nil
+	case fields[0] == "exponential" && len(values) == 1:
+		return ExponentialLengthPrior{Scale: values[0]}, nil
+	default:
+		return nil, fmt.Errorf("invalid length prior %q (expected normal:MEAN:SD or exponential:SCALE)", s)
+	}
+}
+
 // FromLinearWindows generates all index ranges as Window >= min window length (mll).
-// all possible structures >= minLoopLength
-func FromLinearWindows(seq []rune, minLoopLength int) []Window {
+// all possible structures >= minLoopLength and, when maxLoopLength > 0, <= maxLoopLength
+func FromLinearWindows(seq []rune, minLoopLength int, maxLoopLength int) []Window {
 	var result []Window
 	for i := range seq {
-		for j := i + minLoopLength - 1; j < len(seq); j++ {
+		last := len(seq) - 1
+		if maxLoopLength > 0 && i+maxLoopLength-1 < last {
+			last = i + maxLoopLength - 1
+		}
+		for j := i + minLoopLength - 1; j <= last; j++ {
 			result = append(result, Window{Start: i, End: j})
 		}
 	}
@@ -25,12 +193,12 @@ func FromLinearWindows(seq []rune, minLoopLength int) []Window {
 // the beginning and end of the input sequence.
 // FromCircularWindows union FromLinearWindows should produce all possible
 // windows > minLoopLength on some input sequence.
-func FromCircularWindows(seq []rune, minLoopLength int) []Window {
+func FromCircularWindows(seq []rune, minLoopLength int, maxLoopLength int) []Window {
 	var result []Window
 	for i := 1; i < len(seq); i++ {
 		for j := 0; j < i; j++ {
 			length := (len(seq) - i) + j + 1
-			if length >= minLoopLength {
+			if length >= minLoopLength && (maxLoopLength <= 0 || length <= maxLoopLength) {
 				result = append(result, Window{Start: i, End: j})
 			}
 		}
@@ -8,9 +8,9 @@ import (
 func TestFromLinearWindows(t *testing.T) {
 	inputSeq := []rune{'G', 'A', 'T'}
 	//expected := []Window{}
-	test1 := FromLinearWindows(inputSeq, 2)
-	test2 := FromLinearWindows(inputSeq, 3)
-	test3 := FromLinearWindows(inputSeq, 4)
+	test1 := FromLinearWindows(inputSeq, 2, 0)
+	test2 := FromLinearWindows(inputSeq, 3, 0)
+	test3 := FromLinearWindows(inputSeq, 4, 0)
 	expected1 := []Window{{0, 1}, {0, 2}, {1, 2}}
 	expected2 := []Window{{0, 2}}
 	expected3 := []Window{}
@@ -30,9 +30,9 @@ func TestFromLinearWindows(t *testing.T) {
 func TestFromCircularWindows(t *testing.T) {
 	inputSeq := []rune{'G', 'A', 'T'}
 	inputSeq2 := []rune{'G', 'A', 'T', 'T'}
-	test1 := FromCircularWindows(inputSeq, 2)
-	test2 := FromCircularWindows(inputSeq2, 2)
-	test3 := FromCircularWindows(inputSeq2, 3)
+	test1 := FromCircularWindows(inputSeq, 2, 0)
+	test2 := FromCircularWindows(inputSeq2, 2, 0)
+	test3 := FromCircularWindows(inputSeq2, 3, 0)
 	expected1 := []Window{{1, 0}, {2, 0}, {2, 1}}
 	expected2 := []Window{{1, 0}, {2, 0}, {2, 1}, {3, 0}, {3, 1}, {3, 2}}
 	expected3 := []Window{{1, 0}, {2, 0}, {2, 1}, {3, 1}, {3, 2}}
@@ -48,6 +48,77 @@ func TestFromCircularWindows(t *testing.T) {
 	}
 }
 
+func TestMaxLoopLength(t *testing.T) {
+	inputSeq := []rune{'G', 'A', 'T', 'T'}
+	linear := FromLinearWindows(inputSeq, 2, 3)
+	circular := FromCircularWindows(inputSeq, 2, 3)
+	expectedLinear := []Window{{0, 1}, {0, 2}, {1, 2}, {1, 3}, {2, 3}}
+	expectedCircular := []Window{{2, 0}, {3, 0}, {3, 1}}
+
+	if !reflect.DeepEqual(linear, expectedLinear) {
+		t.Errorf("FromLinearWindows(%v, 2, 3) = %v, want %v", inputSeq, linear, expectedLinear)
+	}
+	if !reflect.DeepEqual(circular, expectedCircular) {
+		t.Errorf("FromCircularWindows(%v, 2, 3) = %v, want %v", inputSeq, circular, expectedCircular)
+	}
+
+	// windowsFrom must agree with the window generators for every start position
+	wp := WindowParams{MinLength: 2, MaxLength: 3, Circular: true}
+	var streamed []Window
+	for i := range inputSeq {
+		streamed = append(streamed, windowsFrom(inputSeq, i, wp)...)
+	}
+	if len(streamed) != len(wp.Windows(inputSeq)) {
+		t.Errorf("windowsFrom produced %d windows, want %d", len(streamed), len(wp.Windows(inputSeq)))
+	}
+}
+
+func TestInitiationZones(t *testing.T) {
+	inputSeq := []rune{'G', 'A', 'T', 'T', 'A'}
+	mask := InitiationMask(len(inputSeq), []Zone{{-2, 0}, {3, 3}})
+	if !reflect.DeepEqual(mask, []bool{true, false, false, true, false}) {
+		t.Errorf("InitiationMask = %v", mask)
+	}
+
+	downstream := WindowParams{MinLength: 2, MaxLength: 3, Initiation: mask}
+	expected := []Window{{0, 1}, {0, 2}, {3, 4}}
+	if windows := downstream.Windows(inputSeq); !reflect.DeepEqual(windows, expected) {
+		t.Errorf("Windows initiating at their start = %v, want %v", windows, expected)
+	}
+
+	upstream := WindowParams{MinLength: 2, MaxLength: 3, Initiation: mask, InitiateFromEnd: true}
+	expected = []Window{{1, 3}, {2, 3}}
+	if windows := upstream.Windows(inputSeq); !reflect.DeepEqual(windows, expected) {
+		t.Errorf("Windows initiating at their end = %v, want %v", windows, expected)
+	}
+}
+
+func TestParseLengthPrior(t *testing.T) {
+	valid := map[string]LengthPrior{
+		"":                nil,
+		"none":            nil,
+		"normal:300:100":  NormalLengthPrior{Mean: 300, SD: 100},
+		"exponential:500": ExponentialLengthPrior{Scale: 500},
+	}
+	for s, expected := range valid {
+		prior, err := ParseLengthPrior(s)
+		if err != nil {
+			t.Errorf("ParseLengthPrior(%q) returned error: %v", s, err)
+		}
+		if prior != expected {
+			t.Errorf("ParseLengthPrior(%q) = %v, want %v", s, prior, expected)
+		}
+	}
+	for _, s := range []string{"normal:300", "exponential:-1", "gamma:1:2", "normal:a:b"} {
+		if _, err := ParseLengthPrior(s); err == nil {
+			t.Errorf("ParseLengthPrior(%q) should fail", s)
+		}
+	}
+	if w := (NormalLengthPrior{Mean: 300, SD: 100}).Weight(300); w != 1 {
+		t.Errorf("normal prior should peak at 1, got %v", w)
+	}
+}
+
 func TestWindowToString(t *testing.T) {
 	inputSeq := []rune{'G', 'A', 'T', 'T', 'A', 'C', 'A'}
 
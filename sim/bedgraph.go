@@ -0,0 +1,55 @@
+package sim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"golooper/rlooper"
+)
+
+// bedGraphPrecision is the number of significant digits written per value. consecutive bases whose values
+// print the same are merged into one interval.
+const bedGraphPrecision = 6
+
+// bedGraphRun is a half-open interval of sequence positions sharing one printed value
+type bedGraphRun struct {
+	start int
+	end   int
+	value string
+}
+
+// mergeRuns run-length encodes per-base values by their printed form. NaN marks a base with no value and
+// is left out.
+func mergeRuns(values []float64) []bedGraphRun {
+	var runs []bedGraphRun
+	for i, v := range values {
+		if math.IsNaN(v) {
+			continue
+		}
+		value := strconv.FormatFloat(v, 'g', bedGraphPrecision, 64)
+		if last := len(runs) - 1; last >= 0 && runs[last].end == i && runs[last].value == value {
+			runs[len(runs)-1].end = i + 1
+			continue
+		}
+		runs = append(runs, bedGraphRun{start: i, end: i + 1, value: value})
+	}
+	return runs
+}
+
+// writeBedGraph writes per-base values as bedGraph in genomic coordinates, merging consecutive bases with
+// identical values. no track line or comments are written, so the output can go straight to
+// bedGraphToBigWig.
+func writeBedGraph(w io.Writer, gene *rlooper.Gene, values []float64) error {
+	chrom := chromName(gene)
+	offset := genomicOffset(gene)
+	buf := bufio.NewWriter(w)
+	for _, run := range mergeRuns(values) {
+		if _, err := fmt.Fprintf(buf, "%s\t%d\t%d\t%s\n", chrom, offset+int64(run.start), offset+int64(run.end), run.value); err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
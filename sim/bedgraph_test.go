@@ -0,0 +1,41 @@
+package sim
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"golooper/rlooper"
+)
+
+func TestMergeRuns(t *testing.T) {
+	values := []float64{0, 0, 0, 0.25, 0.25, 0.1234567, 0.12345671, 0}
+	expected := []bedGraphRun{
+		{start: 0, end: 3, value: "0"},
+		{start: 3, end: 5, value: "0.25"},
+		{start: 5, end: 7, value: "0.123457"},
+		{start: 7, end: 8, value: "0"},
+	}
+	if runs := mergeRuns(values); !reflect.DeepEqual(runs, expected) {
+		t.Errorf("expected %v, got %v", expected, runs)
+	}
+	if runs := mergeRuns(nil); len(runs) != 0 {
+		t.Errorf("expected no runs for no values, got %v", runs)
+	}
+}
+
+func TestWriteBedGraph(t *testing.T) {
+	gene := &rlooper.Gene{
+		GeneName: "test",
+		Pos:      rlooper.Loci{Chromosome: "chr1", Strand: "+", StartPos: 101, EndPos: 105},
+		Sequence: []rune("GATTA"),
+	}
+	var buf bytes.Buffer
+	if err := writeBedGraph(&buf, gene, []float64{0, 0, 0.5, 0.5, 1}); err != nil {
+		t.Fatalf("writeBedGraph returned error: %v", err)
+	}
+	expected := "chr1\t100\t102\t0\nchr1\t102\t104\t0.5\nchr1\t104\t105\t1\n"
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
@@ -0,0 +1,606 @@
+package sim
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golooper/rlooper"
+)
+
+// bigWig layout constants, see Kent et al. 2010, "BigWig and BigBed: enabling browsing of large
+// distributed datasets"
+const (
+	bigWigMagic         = 0x888FFC26
+	chromTreeMagic      = 0x78CA8C91
+	rTreeMagic          = 0x2468ACE0
+	bigWigVersion       = 4
+	bigWigHeaderSize    = 64
+	zoomHeaderSize      = 24
+	totalSummarySize    = 40
+	bigWigBlockSize     = 256  // children per R-tree node
+	bigWigItemsPerSlot  = 1024 // items per compressed data section
+	bigWigMaxZoomLevels = 10
+	bigWigZoomFactor    = 4
+	bedGraphSection     = 1 // section type of bedGraph records
+)
+
+var bigWigByteOrder = binary.LittleEndian
+
+// bigWigTrack is per-base values of one sequence placed on a chromosome at a 0-based offset. NaN values
+// are left out of the track.
+type bigWigTrack struct {
+	chrom  string
+	offset int64
+	values []float64
+}
+
+// geneBigWigTrack places per-base values of gene at its genomic coordinates
+func geneBigWigTrack(gene *rlooper.Gene, values []float64) bigWigTrack {
+	return bigWigTrack{chrom: chromName(gene), offset: genomicOffset(gene), values: values}
+}
+
+// readChromSizes reads a UCSC chrom.sizes file of chromosome names and lengths
+func readChromSizes(path string) (map[string]uint32, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening chrom sizes: %v", err)
+	}
+	defer file.Close()
+
+	sizes := make(map[string]uint32)
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("error reading chrom sizes %s line %d: expected a name and a size", path, line)
+		}
+		size, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("error reading chrom sizes %s line %d: %v", path, line, err)
+		}
+		sizes[fields[0]] = uint32(size)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading chrom sizes: %v", err)
+	}
+	return sizes, nil
+}
+
+// bigWigInterval is a run of bases sharing one value
+type bigWigInterval struct {
+	chromID uint32
+	start   uint32
+	end     uint32
+	value   float32
+}
+
+// bigWigSection is a compressed data section and the range of bases it covers
+type bigWigSection struct {
+	chromID uint32
+	start   uint32
+	end     uint32
+	offset  uint64
+	size    uint64
+}
+
+// bigWigZoomRecord summarizes the values within one bin of a zoom level. sums are accumulated in double
+// precision and stored as floats.
+type bigWigZoomRecord struct {
+	chromID    uint32
+	start      uint32
+	end        uint32
+	validCount uint32
+	min        float64
+	max        float64
+	sum        float64
+	sumSquares float64
+}
+
+// bigWigSummary summarizes every value in the file
+type bigWigSummary struct {
+	basesCovered uint64
+	min          float64
+	max          float64
+	sum          float64
+	sumSquares   float64
+}
+
+// offsetWriter tracks the file offset of a buffered writer
+type offsetWriter struct {
+	w      *bufio.Writer
+	offset uint64
+	err    error
+}
+
+func (o *offsetWriter) write(v any) {
+	if o.err != nil {
+		return
+	}
+	o.err = binary.Write(o.w, bigWigByteOrder, v)
+	o.offset += uint64(binary.Size(v))
+}
+
+func (o *offsetWriter) writeBytes(b []byte) {
+	if o.err != nil {
+		return
+	}
+	_, o.err = o.w.Write(b)
+	o.offset += uint64(len(b))
+}
+
+// bigWigWriter writes one bigWig file. the header is written last, once every offset is known.
+type bigWigWriter struct {
+	file              *outputFile
+	out               *offsetWriter
+	uncompressBufSize uint32
+}
+
+// writeSection compresses raw and writes it, returning its offset and compressed size
+func (bw *bigWigWriter) writeSection(raw []byte) (uint64, uint64, error) {
+	bw.uncompressBufSize = max(bw.uncompressBufSize, uint32(len(raw)))
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(raw); err != nil {
+		return 0, 0, fmt.Errorf("error compressing bigWig section: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		return 0, 0, fmt.Errorf("error compressing bigWig section: %v", err)
+	}
+	offset := bw.out.offset
+	bw.out.writeBytes(compressed.Bytes())
+	return offset, uint64(compressed.Len()), nil
+}
+
+// writeBigWig writes tracks as a bigWig file at path, see writeBigWigFile
+func writeBigWig(path string, chromSizes map[string]uint32, tracks []bigWigTrack) error {
+	file, err := createFileWithDir(path)
+	if err != nil {
+		return fmt.Errorf("error creating bigWig at %s: %v", path, err)
+	}
+	if err := writeBigWigFile(file, chromSizes, tracks); err != nil {
+		file.Abort()
+		return err
+	}
+	return file.Close()
+}
+
+// writeBigWigFile writes tracks as a bigWig with an index and zoom levels to file, leaving it open.
+// chromosomes are numbered in name order, as the chromosome B+ tree requires, and every track must fit
+// within its chromosome size.
+func writeBigWigFile(file *outputFile, chromSizes map[string]uint32, tracks []bigWigTrack) error {
+	chroms := make([]string, 0, len(chromSizes))
+	for name := range chromSizes {
+		chroms = append(chroms, name)
+	}
+	slices.Sort(chroms)
+	chromIDs := make(map[string]uint32, len(chroms))
+	for i, name := range chroms {
+		chromIDs[name] = uint32(i)
+	}
+
+	intervals, err := bigWigIntervals(chromSizes, chromIDs, tracks)
+	if err != nil {
+		return err
+	}
+	zoomLevels := bigWigZoomLevels(intervals)
+
+	bw := &bigWigWriter{file: file, out: &offsetWriter{w: bufio.NewWriter(file)}}
+
+	// header, zoom headers and summary are rewritten once their contents are known
+	bw.out.writeBytes(make([]byte, bigWigHeaderSize+zoomHeaderSize*len(zoomLevels)))
+	totalSummaryOffset := bw.out.offset
+	bw.out.writeBytes(make([]byte, totalSummarySize))
+
+	chromTreeOffset := bw.out.offset
+	bw.writeChromTree(chroms, chromSizes)
+
+	fullDataOffset := bw.out.offset
+	sections, err := bw.writeDataSections(intervals)
+	if err != nil {
+		return err
+	}
+	fullIndexOffset := bw.out.offset
+	bw.writeRTree(sections, fullIndexOffset)
+
+	zoomDataOffsets := make([]uint64, len(zoomLevels))
+	zoomIndexOffsets := make([]uint64, len(zoomLevels))
+	for i, level := range zoomLevels {
+		zoomDataOffsets[i] = bw.out.offset
+		sections, err := bw.writeZoomSections(level.records)
+		if err != nil {
+			return err
+		}
+		zoomIndexOffsets[i] = bw.out.offset
+		bw.writeRTree(sections, zoomIndexOffsets[i])
+	}
+
+	if bw.out.err == nil {
+		bw.out.err = bw.out.w.Flush()
+	}
+	if bw.out.err != nil {
+		return fmt.Errorf("error writing bigWig: %v", bw.out.err)
+	}
+
+	var header bytes.Buffer
+	for _, v := range []any{
+		uint32(bigWigMagic),
+		uint16(bigWigVersion),
+		uint16(len(zoomLevels)),
+		chromTreeOffset,
+		fullDataOffset,
+		fullIndexOffset,
+		uint16(0), // field count, only used by bigBed
+		uint16(0), // defined field count
+		uint64(0), // autoSql offset
+		totalSummaryOffset,
+		bw.uncompressBufSize,
+		uint64(0), // extension offset
+	} {
+		binary.Write(&header, bigWigByteOrder, v)
+	}
+	for i, level := range zoomLevels {
+		for _, v := range []any{level.reduction, uint32(0), zoomDataOffsets[i], zoomIndexOffsets[i]} {
+			binary.Write(&header, bigWigByteOrder, v)
+		}
+	}
+	summary := summarizeIntervals(intervals)
+	for _, v := range []any{summary.basesCovered, summary.min, summary.max, summary.sum, summary.sumSquares} {
+		binary.Write(&header, bigWigByteOrder, v)
+	}
+	if _, err := file.WriteAt(header.Bytes(), 0); err != nil {
+		return fmt.Errorf("error writing bigWig header: %v", err)
+	}
+	return nil
+}
+
+// bigWigIntervals merges each track into runs of equal values, dropping NaN, sorted by chromosome and start
+func bigWigIntervals(chromSizes map[string]uint32, chromIDs map[string]uint32, tracks []bigWigTrack) ([]bigWigInterval, error) {
+	var intervals []bigWigInterval
+	for _, track := range tracks {
+		chromID, ok := chromIDs[track.chrom]
+		if !ok {
+			return nil, fmt.Errorf("chromosome %s has no size", track.chrom)
+		}
+		if end := track.offset + int64(len(track.values)); track.offset < 0 || end > int64(chromSizes[track.chrom]) {
+			return nil, fmt.Errorf("track %s:%d-%d runs past the chromosome size %d", track.chrom, track.offset, end, chromSizes[track.chrom])
+		}
+		for _, run := range mergeRuns(track.values) {
+			value, _ := strconv.ParseFloat(run.value, 32)
+			intervals = append(intervals, bigWigInterval{
+				chromID: chromID,
+				start:   uint32(track.offset + int64(run.start)),
+				end:     uint32(track.offset + int64(run.end)),
+				value:   float32(value),
+			})
+		}
+	}
+	slices.SortStableFunc(intervals, func(a, b bigWigInterval) int {
+		return cmp.Or(cmp.Compare(a.chromID, b.chromID), cmp.Compare(a.start, b.start))
+	})
+	for i := 1; i < len(intervals); i++ {
+		if intervals[i].chromID == intervals[i-1].chromID && intervals[i].start < intervals[i-1].end {
+			return nil, fmt.Errorf("tracks overlap at base %d", intervals[i].start)
+		}
+	}
+	return intervals, nil
+}
+
+func summarizeIntervals(intervals []bigWigInterval) bigWigSummary {
+	summary := bigWigSummary{min: math.Inf(1), max: math.Inf(-1)}
+	for _, iv := range intervals {
+		bases, v := float64(iv.end-iv.start), float64(iv.value)
+		summary.basesCovered += uint64(iv.end - iv.start)
+		summary.min = math.Min(summary.min, v)
+		summary.max = math.Max(summary.max, v)
+		summary.sum += v * bases
+		summary.sumSquares += v * v * bases
+	}
+	if len(intervals) == 0 {
+		summary.min, summary.max = 0, 0
+	}
+	return summary
+}
+
+// writeChromTree writes the chromosome B+ tree as a single leaf holding every chromosome
+func (bw *bigWigWriter) writeChromTree(chroms []string, chromSizes map[string]uint32) {
+	keySize := 1
+	for _, name := range chroms {
+		keySize = max(keySize, len(name))
+	}
+	for _, v := range []any{
+		uint32(chromTreeMagic),
+		uint32(max(len(chroms), 1)), // block size
+		uint32(keySize),
+		uint32(8), // value size: chromosome id and size
+		uint64(len(chroms)),
+		uint64(0), // reserved
+		uint8(1),  // leaf node
+		uint8(0),  // reserved
+		uint16(len(chroms)),
+	} {
+		bw.out.write(v)
+	}
+	for i, name := range chroms {
+		key := make([]byte, keySize)
+		copy(key, name)
+		bw.out.writeBytes(key)
+		bw.out.write(uint32(i))
+		bw.out.write(chromSizes[name])
+	}
+}
+
+// writeDataSections writes intervals as bedGraph sections of up to bigWigItemsPerSlot records, never
+// spanning chromosomes, preceded by the section count
+func (bw *bigWigWriter) writeDataSections(intervals []bigWigInterval) ([]bigWigSection, error) {
+	var sections []bigWigSection
+	countOffset := bw.out.offset
+	bw.out.write(uint64(0)) // section count, patched below
+	for len(intervals) > 0 {
+		count := 1
+		for count < len(intervals) && count < bigWigItemsPerSlot && intervals[count].chromID == intervals[0].chromID {
+			count++
+		}
+		block := intervals[:count]
+		intervals = intervals[count:]
+
+		var raw bytes.Buffer
+		first, last := block[0], block[len(block)-1]
+		for _, v := range []any{first.chromID, first.start, last.end, uint32(0), uint32(0), uint8(bedGraphSection), uint8(0), uint16(len(block))} {
+			binary.Write(&raw, bigWigByteOrder, v)
+		}
+		for _, iv := range block {
+			for _, v := range []any{iv.start, iv.end, iv.value} {
+				binary.Write(&raw, bigWigByteOrder, v)
+			}
+		}
+		offset, size, err := bw.writeSection(raw.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		sections = append(sections, bigWigSection{chromID: first.chromID, start: first.start, end: last.end, offset: offset, size: size})
+	}
+	bw.patchCount(countOffset, uint64(len(sections)))
+	return sections, nil
+}
+
+// patchCount rewrites a count written before its value was known
+func (bw *bigWigWriter) patchCount(offset uint64, count any) {
+	if bw.out.err != nil {
+		return
+	}
+	if bw.out.err = bw.out.w.Flush(); bw.out.err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, bigWigByteOrder, count)
+	_, bw.out.err = bw.file.WriteAt(buf.Bytes(), int64(offset))
+}
+
+// bigWigZoomLevel is the summary of the intervals at one reduction
+type bigWigZoomLevel struct {
+	reduction uint32
+	records   []bigWigZoomRecord
+}
+
+// bigWigZoomLevels picks reductions starting at ten times the mean interval length and growing by
+// bigWigZoomFactor, keeping levels while each at least halves the number of records
+func bigWigZoomLevels(intervals []bigWigInterval) []bigWigZoomLevel {
+	if len(intervals) == 0 {
+		return nil
+	}
+	var bases uint64
+	for _, iv := range intervals {
+		bases += uint64(iv.end - iv.start)
+	}
+	reduction := uint64(max(10*bases/uint64(len(intervals)), 10))
+
+	var levels []bigWigZoomLevel
+	previous := len(intervals)
+	for len(levels) < bigWigMaxZoomLevels && reduction <= math.MaxUint32 {
+		records := zoomRecords(intervals, uint32(reduction))
+		if 2*len(records) > previous {
+			break
+		}
+		levels = append(levels, bigWigZoomLevel{reduction: uint32(reduction), records: records})
+		previous = len(records)
+		reduction *= bigWigZoomFactor
+	}
+	return levels
+}
+
+// zoomRecords summarizes intervals in bins of reduction bases aligned to the chromosome start. each
+// record spans the covered bases of its bin.
+func zoomRecords(intervals []bigWigInterval, reduction uint32) []bigWigZoomRecord {
+	var records []bigWigZoomRecord
+	for _, iv := range intervals {
+		for start := iv.start; start < iv.end; {
+			binStart := start - start%reduction
+			end := min(iv.end, binStart+reduction)
+			if binStart+reduction < binStart { // the last bin of a chromosome near the 32-bit limit
+				end = iv.end
+			}
+			bases, v := end-start, float64(iv.value)
+			n := len(records)
+			if n == 0 || records[n-1].chromID != iv.chromID || records[n-1].start-records[n-1].start%reduction != binStart {
+				records = append(records, bigWigZoomRecord{chromID: iv.chromID, start: start, min: v, max: v})
+				n++
+			}
+			r := &records[n-1]
+			r.end = end
+			r.validCount += bases
+			r.min = min(r.min, v)
+			r.max = max(r.max, v)
+			r.sum += v * float64(bases)
+			r.sumSquares += v * v * float64(bases)
+			start = end
+		}
+	}
+	return records
+}
+
+// writeZoomSections writes zoom records in sections of up to bigWigItemsPerSlot records, never spanning
+// chromosomes, preceded by the record count
+func (bw *bigWigWriter) writeZoomSections(records []bigWigZoomRecord) ([]bigWigSection, error) {
+	var sections []bigWigSection
+	bw.out.write(uint32(len(records)))
+	for len(records) > 0 {
+		count := 1
+		for count < len(records) && count < bigWigItemsPerSlot && records[count].chromID == records[0].chromID {
+			count++
+		}
+		block := records[:count]
+		records = records[count:]
+
+		var raw bytes.Buffer
+		for _, r := range block {
+			for _, v := range []any{r.chromID, r.start, r.end, r.validCount, float32(r.min), float32(r.max), float32(r.sum), float32(r.sumSquares)} {
+				binary.Write(&raw, bigWigByteOrder, v)
+			}
+		}
+		offset, size, err := bw.writeSection(raw.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		first, last := block[0], block[len(block)-1]
+		sections = append(sections, bigWigSection{chromID: first.chromID, start: first.start, end: last.end, offset: offset, size: size})
+	}
+	return sections, nil
+}
+
+// rTreeBounds is the range of bases covered by an R-tree item, from the start of one chromosome position
+// to the end of another
+type rTreeBounds struct {
+	startChrom, startBase, endChrom, endBase uint32
+}
+
+func (b rTreeBounds) union(other rTreeBounds) rTreeBounds {
+	if other.startChrom < b.startChrom || (other.startChrom == b.startChrom && other.startBase < b.startBase) {
+		b.startChrom, b.startBase = other.startChrom, other.startBase
+	}
+	if other.endChrom > b.endChrom || (other.endChrom == b.endChrom && other.endBase > b.endBase) {
+		b.endChrom, b.endBase = other.endChrom, other.endBase
+	}
+	return b
+}
+
+// writeRTree writes the R-tree index of sections at indexOffset, the current offset. leaves hold up to
+// bigWigBlockSize sections and each level above holds the bounds of up to bigWigBlockSize nodes below;
+// nodes are written root first, level by level.
+func (bw *bigWigWriter) writeRTree(sections []bigWigSection, indexOffset uint64) {
+	const leafItemSize, branchItemSize, nodeHeaderSize = 32, 24, 4
+
+	// levels[0] holds the bounds of each section, levels[k+1] the bounds of each node of level k
+	levels := [][]rTreeBounds{make([]rTreeBounds, len(sections))}
+	for i, s := range sections {
+		levels[0][i] = rTreeBounds{s.chromID, s.start, s.chromID, s.end}
+	}
+	for len(levels[len(levels)-1]) > bigWigBlockSize {
+		below := levels[len(levels)-1]
+		var above []rTreeBounds
+		for i := 0; i < len(below); i += bigWigBlockSize {
+			bounds := below[i]
+			for _, b := range below[i+1 : min(i+bigWigBlockSize, len(below))] {
+				bounds = bounds.union(b)
+			}
+			above = append(above, bounds)
+		}
+		levels = append(levels, above)
+	}
+
+	var total rTreeBounds
+	for i, b := range levels[0] {
+		if i == 0 {
+			total = b
+		}
+		total = total.union(b)
+	}
+	for _, v := range []any{
+		uint32(rTreeMagic),
+		uint32(bigWigBlockSize),
+		uint64(len(sections)),
+		total.startChrom, total.startBase, total.endChrom, total.endBase,
+		indexOffset, // end of the data the index covers
+		uint32(bigWigItemsPerSlot),
+		uint32(0), // reserved
+	} {
+		bw.out.write(v)
+	}
+
+	// the root holds every item of the top level. below it, item j of level k+1 points at node j of level k,
+	// made of items j*blockSize onwards, so every node but the last of a level is full.
+	top := len(levels) - 1
+	itemSize := func(k int) uint64 {
+		if k == 0 {
+			return leafItemSize
+		}
+		return branchItemSize
+	}
+	nodeCount := func(k int) int {
+		if k == top {
+			return 1
+		}
+		return (len(levels[k]) + bigWigBlockSize - 1) / bigWigBlockSize
+	}
+	levelOffsets := make([]uint64, len(levels))
+	offset := bw.out.offset
+	for k := top; k >= 0; k-- {
+		levelOffsets[k] = offset
+		offset += uint64(nodeCount(k))*nodeHeaderSize + uint64(len(levels[k]))*itemSize(k)
+	}
+
+	for k := top; k >= 0; k-- {
+		items := levels[k]
+		for i := 0; i < nodeCount(k); i++ {
+			lo, hi := 0, len(items)
+			if k < top {
+				lo, hi = i*bigWigBlockSize, min((i+1)*bigWigBlockSize, len(items))
+			}
+			bw.out.write(uint8(min(k, 1) ^ 1)) // leaf flag
+			bw.out.write(uint8(0))
+			bw.out.write(uint16(hi - lo))
+			for j := lo; j < hi; j++ {
+				b := items[j]
+				for _, v := range []any{b.startChrom, b.startBase, b.endChrom, b.endBase} {
+					bw.out.write(v)
+				}
+				if k == 0 {
+					bw.out.write(sections[j].offset)
+					bw.out.write(sections[j].size)
+				} else {
+					bw.out.write(levelOffsets[k-1] + uint64(j)*(nodeHeaderSize+bigWigBlockSize*itemSize(k-1)))
+				}
+			}
+		}
+	}
+}
+
+// writeBigWigTracks writes tracks as a bigWig to file, leaving it open. sizes come from chromSizesPath when
+// set, otherwise every chromosome is taken to end with the last track on it.
+func writeBigWigTracks(file *outputFile, chromSizesPath string, tracks []bigWigTrack) error {
+	chromSizes := make(map[string]uint32)
+	for _, track := range tracks {
+		chromSizes[track.chrom] = max(chromSizes[track.chrom], uint32(track.offset+int64(len(track.values))))
+	}
+	if chromSizesPath != "" {
+		sizes, err := readChromSizes(chromSizesPath)
+		if err != nil {
+			return err
+		}
+		chromSizes = sizes
+	}
+	return writeBigWigFile(file, chromSizes, tracks)
+}
@@ -0,0 +1,263 @@
+package sim
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golooper/config"
+	"golooper/rlooper"
+)
+
+// bigWigContents is what readBigWig recovers from a file
+type bigWigContents struct {
+	chroms       map[string]uint32 // name to size
+	chromIDs     map[uint32]string
+	intervals    []bigWigInterval
+	zoomBases    []uint64 // bases covered at each zoom level
+	basesCovered uint64
+	sum          float64
+}
+
+// readBigWig parses a bigWig following the layout in the format specification: header, chromosome B+ tree,
+// then every data section reached through the R-tree index
+func readBigWig(t *testing.T, path string) bigWigContents {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read bigWig: %v", err)
+	}
+	le := binary.LittleEndian
+	u16 := func(off uint64) uint16 { return le.Uint16(data[off:]) }
+	u32 := func(off uint64) uint32 { return le.Uint32(data[off:]) }
+	u64 := func(off uint64) uint64 { return le.Uint64(data[off:]) }
+	f64 := func(off uint64) float64 { return math.Float64frombits(u64(off)) }
+
+	if u32(0) != bigWigMagic {
+		t.Fatalf("bad magic %x", u32(0))
+	}
+	if u16(4) != bigWigVersion {
+		t.Fatalf("bad version %d", u16(4))
+	}
+	zoomLevels := int(u16(6))
+	chromTreeOffset, fullIndexOffset := u64(8), u64(24)
+	totalSummaryOffset, uncompressBufSize := u64(44), u32(52)
+
+	contents := bigWigContents{
+		chroms:       make(map[string]uint32),
+		chromIDs:     make(map[uint32]string),
+		basesCovered: u64(totalSummaryOffset),
+		sum:          f64(totalSummaryOffset + 24),
+	}
+
+	if u32(chromTreeOffset) != chromTreeMagic {
+		t.Fatalf("bad chromosome tree magic %x", u32(chromTreeOffset))
+	}
+	keySize := uint64(u32(chromTreeOffset + 8))
+	var readChromNode func(off uint64)
+	readChromNode = func(off uint64) {
+		isLeaf, count := data[off], uint64(u16(off+2))
+		off += 4
+		for i := uint64(0); i < count; i++ {
+			key := string(bytes.TrimRight(data[off:off+keySize], "\x00"))
+			if isLeaf == 1 {
+				id, size := u32(off+keySize), u32(off+keySize+4)
+				contents.chroms[key] = size
+				contents.chromIDs[id] = key
+				off += keySize + 8
+			} else {
+				readChromNode(u64(off + keySize))
+				off += keySize + 8
+			}
+		}
+	}
+	readChromNode(chromTreeOffset + 32)
+
+	// readRTree returns the offset and size of every section under the index at off
+	readRTree := func(off uint64) [][2]uint64 {
+		if u32(off) != rTreeMagic {
+			t.Fatalf("bad R-tree magic %x at %d", u32(off), off)
+		}
+		var sections [][2]uint64
+		var readNode func(off uint64)
+		readNode = func(off uint64) {
+			isLeaf, count := data[off], uint64(u16(off+2))
+			off += 4
+			for i := uint64(0); i < count; i++ {
+				if isLeaf == 1 {
+					sections = append(sections, [2]uint64{u64(off + 16), u64(off + 24)})
+					off += 32
+				} else {
+					readNode(u64(off + 16))
+					off += 24
+				}
+			}
+		}
+		readNode(off + 48)
+		if count := u64(off + 8); count != uint64(len(sections)) {
+			t.Fatalf("R-tree at %d claims %d items, found %d", off, count, len(sections))
+		}
+		return sections
+	}
+	inflate := func(section [2]uint64) []byte {
+		r, err := zlib.NewReader(bytes.NewReader(data[section[0] : section[0]+section[1]]))
+		if err != nil {
+			t.Fatalf("Failed to open section: %v", err)
+		}
+		raw, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Failed to inflate section: %v", err)
+		}
+		if len(raw) > int(uncompressBufSize) {
+			t.Fatalf("section of %d bytes exceeds uncompressBufSize %d", len(raw), uncompressBufSize)
+		}
+		return raw
+	}
+
+	for _, section := range readRTree(fullIndexOffset) {
+		raw := inflate(section)
+		chromID, sectionType, count := le.Uint32(raw), raw[20], int(le.Uint16(raw[22:]))
+		if sectionType != bedGraphSection {
+			t.Fatalf("unexpected section type %d", sectionType)
+		}
+		for i := 0; i < count; i++ {
+			item := raw[24+12*i:]
+			contents.intervals = append(contents.intervals, bigWigInterval{
+				chromID: chromID,
+				start:   le.Uint32(item),
+				end:     le.Uint32(item[4:]),
+				value:   math.Float32frombits(le.Uint32(item[8:])),
+			})
+		}
+	}
+
+	for z := 0; z < zoomLevels; z++ {
+		header := uint64(bigWigHeaderSize + zoomHeaderSize*z)
+		var bases uint64
+		for _, section := range readRTree(u64(header + 16)) {
+			raw := inflate(section)
+			for i := 0; i+32 <= len(raw); i += 32 {
+				bases += uint64(le.Uint32(raw[i+12:]))
+			}
+		}
+		contents.zoomBases = append(contents.zoomBases, bases)
+	}
+	return contents
+}
+
+func TestWriteBigWig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.bw")
+	values := []float64{0, 0, 0.5, 0.5, math.NaN(), 1, 0.25}
+	tracks := []bigWigTrack{
+		{chrom: "chr2", offset: 100, values: values},
+		{chrom: "chr1", offset: 0, values: []float64{3, 3, 3}},
+	}
+	sizes := map[string]uint32{"chr1": 10, "chr2": 1000, "chrM": 16569}
+	if err := writeBigWig(path, sizes, tracks); err != nil {
+		t.Fatalf("writeBigWig returned error: %v", err)
+	}
+
+	contents := readBigWig(t, path)
+	for name, size := range sizes {
+		if contents.chroms[name] != size {
+			t.Errorf("chromosome %s: expected size %d, got %d", name, size, contents.chroms[name])
+		}
+	}
+	chr1, chr2 := uint32(0), uint32(1) // numbered in name order
+	expected := []bigWigInterval{
+		{chromID: chr1, start: 0, end: 3, value: 3},
+		{chromID: chr2, start: 100, end: 102, value: 0},
+		{chromID: chr2, start: 102, end: 104, value: 0.5},
+		{chromID: chr2, start: 105, end: 106, value: 1},
+		{chromID: chr2, start: 106, end: 107, value: 0.25},
+	}
+	if len(contents.intervals) != len(expected) {
+		t.Fatalf("expected %d intervals, got %v", len(expected), contents.intervals)
+	}
+	for i := range expected {
+		if contents.intervals[i] != expected[i] {
+			t.Errorf("interval %d: expected %+v, got %+v", i, expected[i], contents.intervals[i])
+		}
+	}
+	if contents.basesCovered != 9 || contents.sum != 9+1+1+0.25 {
+		t.Errorf("unexpected summary: %d bases, sum %v", contents.basesCovered, contents.sum)
+	}
+
+	if err := writeBigWig(path, map[string]uint32{"chr2": 104}, tracks[:1]); err == nil {
+		t.Error("expected an error for a track past the end of its chromosome")
+	}
+	if err := writeBigWig(path, map[string]uint32{"chr1": 10}, tracks[:1]); err == nil {
+		t.Error("expected an error for a chromosome with no size")
+	}
+}
+
+func TestWriteBigWigLargeIndex(t *testing.T) {
+	// enough distinct values for more than bigWigBlockSize sections, so the R-tree needs a second level
+	values := make([]float64, bigWigItemsPerSlot*(bigWigBlockSize+10))
+	for i := range values {
+		values[i] = float64(i % 7)
+	}
+	path := filepath.Join(t.TempDir(), "large.bw")
+	size := uint32(len(values) + 500)
+	if err := writeBigWig(path, map[string]uint32{"chr1": size}, []bigWigTrack{{chrom: "chr1", offset: 500, values: values}}); err != nil {
+		t.Fatalf("writeBigWig returned error: %v", err)
+	}
+
+	contents := readBigWig(t, path)
+	if len(contents.intervals) != len(values) {
+		t.Fatalf("expected %d intervals, got %d", len(values), len(contents.intervals))
+	}
+	for i, iv := range contents.intervals {
+		if iv.start != uint32(500+i) || iv.end != iv.start+1 || iv.value != float32(i%7) {
+			t.Fatalf("interval %d: unexpected %+v", i, iv)
+		}
+	}
+	if len(contents.zoomBases) == 0 {
+		t.Fatal("expected zoom levels")
+	}
+	for z, bases := range contents.zoomBases {
+		if bases != uint64(len(values)) {
+			t.Errorf("zoom level %d covers %d bases, want %d", z, bases, len(values))
+		}
+	}
+}
+
+func TestWriteBigWigGene(t *testing.T) {
+	gene := &rlooper.Gene{
+		GeneName: "test",
+		Pos:      rlooper.Loci{Chromosome: "chr1", Strand: "+", StartPos: 101, EndPos: 105},
+		Sequence: []rune("GATTA"),
+	}
+	dir := t.TempDir()
+	// a run writes its bigWig tracks through FileOps, which writes them when the outputs are closed
+	writeGene := func(chromSizes string) bigWigContents {
+		testConfig := &config.Config{OutfileName: filepath.Join(dir, "gene"), Format: FormatBigWig, ChromSizes: chromSizes}
+		fileOps, err := NewFileOps(testConfig)
+		if err != nil {
+			t.Fatalf("Failed to set up output files: %v", err)
+		}
+		if err := writeTrack(testConfig, gene, fileOps, "bpprob", []float64{0.1, 0.2, 0.3, 0.4, 0.5}); err != nil {
+			t.Fatalf("writeTrack returned error: %v", err)
+		}
+		if err := fileOps.Close(); err != nil {
+			t.Fatalf("Close returned error: %v", err)
+		}
+		return readBigWig(t, testConfig.OutfileName+"_bpprob.bw")
+	}
+	if contents := writeGene(""); contents.chroms["chr1"] != 105 || len(contents.intervals) != 5 {
+		t.Errorf("expected chr1 sized to the end of the sequence with 5 intervals, got %v and %d intervals", contents.chroms, len(contents.intervals))
+	}
+
+	sizesPath := filepath.Join(dir, "chrom.sizes")
+	if err := os.WriteFile(sizesPath, []byte("chr1\t248956422\nchr2\t242193529\n"), 0644); err != nil {
+		t.Fatalf("Failed to write chrom sizes: %v", err)
+	}
+	if contents := writeGene(sizesPath); contents.chroms["chr1"] != 248956422 || contents.chroms["chr2"] != 242193529 {
+		t.Errorf("expected sizes from chrom.sizes, got %v", contents.chroms)
+	}
+}
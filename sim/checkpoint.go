@@ -0,0 +1,251 @@
+package sim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"golooper/config"
+	"golooper/rlooper"
+)
+
+// CheckpointSchema names the layout of the checkpoint file, versioned like ManifestSchema
+const (
+	CheckpointSchema        = "golooper.checkpoint"
+	CheckpointSchemaVersion = 1
+)
+
+// checkpointInterval is how often the checkpoint is brought up to date while genes are written. a run killed
+// outright loses at most the genes written since.
+const checkpointInterval = 30 * time.Second
+
+// Checkpoint records the genes a run has finished and how much of each output they filled, so that an
+// interrupted run can be continued with --resume
+type Checkpoint struct {
+	Schema        string           `json:"schema"`
+	SchemaVersion int              `json:"schema_version"`
+	Updated       time.Time        `json:"updated"`
+	Config        *config.Config   `json:"config"` // the settings that decide the outputs, see checkpointConfig
+	Input         InputFile        `json:"input"`
+	Genes         []GeneTiming     `json:"genes"`   // finished genes in input order, including those timed out
+	Outputs       map[string]int64 `json:"outputs"` // bytes written by the finished genes, by file name
+}
+
+// checkpointPath returns where the checkpoint of the run configured by config is written
+func checkpointPath(config *config.Config) string {
+	return outputBasePath(config) + "_checkpoint.json"
+}
+
+// checkpointConfig returns config with the model defaults resolved and the settings that do not change the
+// outputs cleared, so that two runs writing the same outputs have equal checkpoint configs. the input is
+// compared by checksum rather than path.
+func checkpointConfig(cfg *config.Config) *config.Config {
+	model := modelFromConfig(cfg, &rlooper.Gene{})
+	wp := rlooper.WindowParams{MinLength: minLoopLength(cfg)}
+	if cfg.MaxRLoopLength != nil {
+		wp.MaxLength = *cfg.MaxRLoopLength
+	}
+	resolved := effectiveConfig(cfg, &model, wp)
+	resolved.DomainSizeText = ""
+	resolved.InfileName = ""
+	resolved.ChunkSize = 0
+	resolved.GeneTimeout = 0
+	resolved.Resume = false
+	if cfg.SampleSteps == 0 {
+		// samples depend on the number of chains, one per thread; the other results do not
+		resolved.Threads = 0
+	}
+	return resolved
+}
+
+// newCheckpoint starts the checkpoint of a run with no genes finished
+func newCheckpoint(cfg *config.Config) (*Checkpoint, error) {
+	size, sum, err := fileChecksum(cfg.InfileName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading input file %s: %v", cfg.InfileName, err)
+	}
+	return &Checkpoint{
+		Schema:        CheckpointSchema,
+		SchemaVersion: CheckpointSchemaVersion,
+		Config:        checkpointConfig(cfg),
+		Input:         InputFile{Role: "sequence", Path: cfg.InfileName, Bytes: size, SHA256: sum},
+		Genes:         []GeneTiming{},
+		Outputs:       map[string]int64{},
+	}, nil
+}
+
+// readCheckpoint reads the checkpoint an interrupted run left next to its outputs
+func readCheckpoint(cfg *config.Config) (*Checkpoint, error) {
+	path := checkpointPath(cfg)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no checkpoint at %s to resume from; run without --resume to start over", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoint: %v", err)
+	}
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("error reading checkpoint %s: %v", path, err)
+	}
+	if checkpoint.Schema != CheckpointSchema || checkpoint.SchemaVersion != CheckpointSchemaVersion {
+		return nil, fmt.Errorf("%s is not a checkpoint this release can resume (schema %q version %d)", path, checkpoint.Schema, checkpoint.SchemaVersion)
+	}
+	return &checkpoint, nil
+}
+
+// verify returns an error unless the checkpoint was written by a run of the same input with the same
+// settings as cfg
+func (c *Checkpoint) verify(cfg *config.Config) error {
+	_, sum, err := fileChecksum(cfg.InfileName)
+	if err != nil {
+		return fmt.Errorf("error reading input file %s: %v", cfg.InfileName, err)
+	}
+	if sum != c.Input.SHA256 {
+		return fmt.Errorf("cannot resume: input %s has changed since the checkpoint was written from %s", cfg.InfileName, c.Input.Path)
+	}
+	if diffs := configDifferences(c.Config, checkpointConfig(cfg)); len(diffs) > 0 {
+		return fmt.Errorf("cannot resume: settings differ from the checkpoint:\n  %s", strings.Join(diffs, "\n  "))
+	}
+	return nil
+}
+
+// configDifferences lists the settings that differ between the checkpointed config and the current one
+func configDifferences(checkpointed, current *config.Config) []string {
+	a, b := reflect.ValueOf(*checkpointed), reflect.ValueOf(*current)
+	var diffs []string
+	for i := 0; i < a.NumField(); i++ {
+		if reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			continue
+		}
+		was, _ := json.Marshal(a.Field(i).Interface())
+		now, _ := json.Marshal(b.Field(i).Interface())
+		diffs = append(diffs, fmt.Sprintf("%s: checkpoint %s, now %s", a.Type().Field(i).Name, was, now))
+	}
+	slices.Sort(diffs)
+	return diffs
+}
+
+// write replaces the checkpoint next to the outputs
+func (c *Checkpoint) write(cfg *config.Config) error {
+	c.Updated = time.Now()
+	path := checkpointPath(cfg)
+	file, err := createFileWithDir(path)
+	if err != nil {
+		return fmt.Errorf("error creating checkpoint at %s: %v", path, err)
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(c); err != nil {
+		file.Abort()
+		return fmt.Errorf("error writing checkpoint: %v", err)
+	}
+	return file.Close()
+}
+
+// resumable returns an error naming the selected outputs that are written whole at the end of a run, which
+// a checkpoint cannot continue
+func resumable(cfg *config.Config) error {
+	var names []string
+	if cfg.Format == FormatBigWig {
+		names = append(names, "--format bigwig")
+	}
+	if selected, err := parseOutputs(cfg.Outputs); err == nil {
+		for _, name := range []string{"json", "parquet"} {
+			if selected[name] {
+				names = append(names, "the "+name+" output")
+			}
+		}
+	}
+	if cfg.Dump && cfg.DumpFormat != "" && cfg.DumpFormat != "tsv" {
+		names = append(names, "--dump-format "+cfg.DumpFormat)
+	}
+	if len(names) > 0 {
+		return fmt.Errorf("%s cannot be resumed, as those outputs are written whole at the end of a run", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// checkResume returns an error for --resume with outputs that cannot be resumed
+func checkResume(cfg *config.Config) error {
+	if !cfg.Resume {
+		return nil
+	}
+	return resumable(cfg)
+}
+
+// checkpointer keeps the checkpoint of a run up to date with the genes written to its outputs
+type checkpointer struct {
+	checkpoint *Checkpoint
+	config     *config.Config
+	outputs    *FileOps
+	dump       *tsvStructureWriter // the structure dump when it is appended to like the outputs, or nil
+	saved      bool                // whether a checkpoint describing the outputs is on disk
+	lastSaved  time.Time
+}
+
+// newCheckpointer keeps checkpoint up to date with outputs and dump. a resumed run starts with its
+// checkpoint already on disk.
+func newCheckpointer(cfg *config.Config, checkpoint *Checkpoint, outputs *FileOps, dump *tsvStructureWriter) *checkpointer {
+	return &checkpointer{
+		checkpoint: checkpoint,
+		config:     cfg,
+		outputs:    outputs,
+		dump:       dump,
+		saved:      cfg.Resume,
+		lastSaved:  time.Now(),
+	}
+}
+
+// save flushes the outputs to disk and writes the checkpoint with their sizes
+func (c *checkpointer) save() error {
+	sizes, err := c.outputs.sizes()
+	if err != nil {
+		return err
+	}
+	if c.dump != nil {
+		size, err := c.dump.syncedSize()
+		if err != nil {
+			return fmt.Errorf("error flushing structure dump: %v", err)
+		}
+		sizes[filepath.Base(c.dump.file.path)] = size
+	}
+	c.checkpoint.Outputs = sizes
+	c.lastSaved = time.Now()
+	if err := c.checkpoint.write(c.config); err != nil {
+		return err
+	}
+	c.saved = true
+	return nil
+}
+
+// finished records gene as written, saving the checkpoint once checkpointInterval has passed since the last
+// save
+func (c *checkpointer) finished(gene GeneTiming) error {
+	c.checkpoint.Genes = append(c.checkpoint.Genes, gene)
+	if time.Since(c.lastSaved) < checkpointInterval {
+		return nil
+	}
+	return c.save()
+}
+
+// stopped saves the checkpoint of a run that did not finish and reports whether its outputs can be kept
+// for --resume. an earlier checkpoint still matches the start of the outputs if this one cannot be written,
+// as they only grow.
+func (c *checkpointer) stopped() bool {
+	if len(c.checkpoint.Genes) == 0 {
+		return false
+	}
+	if err := c.save(); err != nil {
+		log.Printf("WARN: error updating checkpoint: %v", err)
+	}
+	return c.saved
+}
@@ -0,0 +1,59 @@
+package sim
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golooper/config"
+)
+
+func TestCheckpointer(t *testing.T) {
+	testConfig := &config.Config{OutfileName: filepath.Join(t.TempDir(), "test_output"), Outputs: "bpprob"}
+	outputs, err := NewFileOps(testConfig)
+	if err != nil {
+		t.Fatalf("Failed to set up output files: %v", err)
+	}
+	defer outputs.Abort()
+	checkpoint := &Checkpoint{Schema: CheckpointSchema, SchemaVersion: CheckpointSchemaVersion, Genes: []GeneTiming{}, Outputs: map[string]int64{}}
+	c := newCheckpointer(testConfig, checkpoint, outputs, nil)
+
+	if c.stopped() {
+		t.Error("expected a run stopped before its first gene to have nothing to keep")
+	}
+	if err := outputs.Write("bpprob", func(f *os.File) error { _, err := f.WriteString("1\n"); return err }); err != nil {
+		t.Fatalf("Failed to write output: %v", err)
+	}
+
+	// genes are recorded at once but only saved every checkpointInterval
+	if err := c.finished(GeneTiming{Name: "first"}); err != nil {
+		t.Fatalf("finished returned error: %v", err)
+	}
+	if _, err := os.Stat(checkpointPath(testConfig)); !os.IsNotExist(err) {
+		t.Errorf("expected no checkpoint within the interval, got %v", err)
+	}
+	c.lastSaved = time.Now().Add(-checkpointInterval)
+	if err := c.finished(GeneTiming{Name: "second"}); err != nil {
+		t.Fatalf("finished returned error: %v", err)
+	}
+	saved, err := readCheckpoint(testConfig)
+	if err != nil {
+		t.Fatalf("expected a checkpoint once the interval passed: %v", err)
+	}
+	if len(saved.Genes) != 2 || saved.Genes[1].Name != "second" {
+		t.Errorf("expected both genes in the checkpoint, got %+v", saved.Genes)
+	}
+	file, _ := outputs.File("bpprob")
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := saved.Outputs["test_output_bpprob.wig"]; size != info.Size() {
+		t.Errorf("checkpoint records %d bytes of the track, it holds %d", size, info.Size())
+	}
+
+	if !c.stopped() {
+		t.Error("expected a stopped run with a saved checkpoint to keep its outputs")
+	}
+}
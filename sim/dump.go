@@ -0,0 +1,289 @@
+package sim
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+
+	"golooper/config"
+	"golooper/rlooper"
+)
+
+// binaryDumpMagic identifies a binary structure dump and its format version
+const binaryDumpMagic = "GLDUMP01"
+
+// binary dump record tags
+const (
+	dumpTagChromosome byte = 'C'
+	dumpTagStructure  byte = 'S'
+)
+
+// StructureWriter writes computed structures to a dump file as they are produced
+type StructureWriter interface {
+	WriteStructures(structures []rlooper.Structure) error
+	Close() error
+	// Abort discards the dump, leaving nothing at its path
+	Abort() error
+}
+
+// dumpFileSuffix returns the file suffix used for a dump of the given format
+func dumpFileSuffix(format string) (string, error) {
+	switch format {
+	case "", "tsv":
+		return "_structures.tsv", nil
+	case "binary":
+		return "_structures.bin", nil
+	case "parquet":
+		return "_structures.parquet", nil
+	default:
+		return "", fmt.Errorf("unknown dump format %q (expected tsv, binary or parquet)", format)
+	}
+}
+
+// checkDumpFormat returns an error for an unknown --dump-format
+func checkDumpFormat(config *config.Config) error {
+	_, err := dumpFileSuffix(config.DumpFormat)
+	return err
+}
+
+// geneStructureWriter is a StructureWriter that records which gene the structures that follow belong to
+type geneStructureWriter interface {
+	StartGene(name string) error
+}
+
+// NewStructureWriter creates a dump file at path in the given format ("tsv", "binary" or "parquet")
+func NewStructureWriter(path string, format string) (StructureWriter, error) {
+	if _, err := dumpFileSuffix(format); err != nil {
+		return nil, err
+	}
+	file, err := createFileWithDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating structure dump at %s: %v", path, err)
+	}
+	var w StructureWriter
+	switch format {
+	case "binary":
+		w, err = newBinaryStructureWriter(file)
+	case "parquet":
+		w = newParquetStructureWriter(file)
+	default:
+		w, err = newTSVStructureWriter(file)
+	}
+	if err != nil {
+		file.Abort()
+		return nil, err
+	}
+	return w, nil
+}
+
+// tsvStructureWriter writes one tab separated row per structure
+type tsvStructureWriter struct {
+	file *outputFile
+	buf  *bufio.Writer
+	line []byte
+}
+
+func newTSVStructureWriter(file *outputFile) (*tsvStructureWriter, error) {
+	w := &tsvStructureWriter{file: file, buf: bufio.NewWriterSize(file, 1<<20)}
+	if _, err := w.buf.WriteString("chromosome\tstart\tend\tlength\tfree_energy\tboltzmann_factor\tprobability\n"); err != nil {
+		return nil, fmt.Errorf("error writing structure dump header: %v", err)
+	}
+	return w, nil
+}
+
+// resumeTSVStructureWriter reopens the TSV dump an interrupted run left at path to append to it after its
+// first size bytes
+func resumeTSVStructureWriter(path string, size int64) (*tsvStructureWriter, error) {
+	file, err := resumeOutputFile(path, size)
+	if err != nil {
+		return nil, err
+	}
+	return &tsvStructureWriter{file: file, buf: bufio.NewWriterSize(file, 1<<20)}, nil
+}
+
+func (w *tsvStructureWriter) WriteStructures(structures []rlooper.Structure) error {
+	for _, s := range structures {
+		line := append(w.line[:0], s.Pos.Chromosome...)
+		line = append(line, '\t')
+		line = strconv.AppendInt(line, s.Pos.StartPos, 10)
+		line = append(line, '\t')
+		line = strconv.AppendInt(line, s.Pos.EndPos, 10)
+		line = append(line, '\t')
+		line = strconv.AppendInt(line, int64(s.Length), 10)
+		line = append(line, '\t')
+		line = strconv.AppendFloat(line, s.FreeEnergy, 'g', -1, 64)
+		line = append(line, '\t')
+		line = strconv.AppendFloat(line, s.BoltzmannFactor, 'g', -1, 64)
+		line = append(line, '\t')
+		line = strconv.AppendFloat(line, s.Probability, 'g', -1, 64)
+		line = append(line, '\n')
+		w.line = line
+		if _, err := w.buf.Write(line); err != nil {
+			return fmt.Errorf("error writing structure dump: %v", err)
+		}
+	}
+	return nil
+}
+
+func (w *tsvStructureWriter) Abort() error {
+	return w.file.Abort()
+}
+
+// syncedSize flushes the dump to disk and returns the bytes written to it
+func (w *tsvStructureWriter) syncedSize() (int64, error) {
+	if err := w.buf.Flush(); err != nil {
+		return 0, err
+	}
+	return w.file.syncedSize()
+}
+
+// Keep closes the dump, leaving it under its partial name for --resume
+func (w *tsvStructureWriter) Keep() error {
+	if err := w.buf.Flush(); err != nil {
+		w.file.Keep()
+		return fmt.Errorf("error flushing structure dump: %v", err)
+	}
+	return w.file.Keep()
+}
+
+func (w *tsvStructureWriter) Close() error {
+	if err := w.buf.Flush(); err != nil {
+		w.file.Abort()
+		return fmt.Errorf("error flushing structure dump: %v", err)
+	}
+	return w.file.Close()
+}
+
+// binaryStructureWriter writes a compact little endian dump. The file starts with binaryDumpMagic and is
+// followed by tagged records:
+//
+//	'C' uint16 name length, name bytes            defines the next chromosome id, starting at 0
+//	'S' uint32 chromosome id, int64 start, int64 end, uint32 length,
+//	    float64 free energy, float64 boltzmann factor, float64 probability
+type binaryStructureWriter struct {
+	file        *outputFile
+	buf         *bufio.Writer
+	chromosomes map[string]uint32
+	record      []byte
+}
+
+func newBinaryStructureWriter(file *outputFile) (*binaryStructureWriter, error) {
+	w := &binaryStructureWriter{
+		file:        file,
+		buf:         bufio.NewWriterSize(file, 1<<20),
+		chromosomes: make(map[string]uint32),
+	}
+	if _, err := w.buf.WriteString(binaryDumpMagic); err != nil {
+		return nil, fmt.Errorf("error writing structure dump header: %v", err)
+	}
+	return w, nil
+}
+
+// chromosomeID returns the id for name, writing a chromosome record the first time name is seen
+func (w *binaryStructureWriter) chromosomeID(name string) (uint32, error) {
+	if id, ok := w.chromosomes[name]; ok {
+		return id, nil
+	}
+	if len(name) > math.MaxUint16 {
+		return 0, fmt.Errorf("chromosome name too long for structure dump: %d bytes", len(name))
+	}
+	record := []byte{dumpTagChromosome}
+	record = binary.LittleEndian.AppendUint16(record, uint16(len(name)))
+	record = append(record, name...)
+	if _, err := w.buf.Write(record); err != nil {
+		return 0, err
+	}
+	id := uint32(len(w.chromosomes))
+	w.chromosomes[name] = id
+	return id, nil
+}
+
+func (w *binaryStructureWriter) WriteStructures(structures []rlooper.Structure) error {
+	for _, s := range structures {
+		id, err := w.chromosomeID(s.Pos.Chromosome)
+		if err != nil {
+			return fmt.Errorf("error writing structure dump: %v", err)
+		}
+		record := append(w.record[:0], dumpTagStructure)
+		record = binary.LittleEndian.AppendUint32(record, id)
+		record = binary.LittleEndian.AppendUint64(record, uint64(s.Pos.StartPos))
+		record = binary.LittleEndian.AppendUint64(record, uint64(s.Pos.EndPos))
+		record = binary.LittleEndian.AppendUint32(record, uint32(s.Length))
+		record = binary.LittleEndian.AppendUint64(record, math.Float64bits(s.FreeEnergy))
+		record = binary.LittleEndian.AppendUint64(record, math.Float64bits(s.BoltzmannFactor))
+		record = binary.LittleEndian.AppendUint64(record, math.Float64bits(s.Probability))
+		w.record = record
+		if _, err := w.buf.Write(record); err != nil {
+			return fmt.Errorf("error writing structure dump: %v", err)
+		}
+	}
+	return nil
+}
+
+func (w *binaryStructureWriter) Abort() error {
+	return w.file.Abort()
+}
+
+func (w *binaryStructureWriter) Close() error {
+	if err := w.buf.Flush(); err != nil {
+		w.file.Abort()
+		return fmt.Errorf("error flushing structure dump: %v", err)
+	}
+	return w.file.Close()
+}
+
+// DumpStructures streams every structure of gene, with its probability, to a dump file next to the
+// other outputs. Structures are written as the workers produce them rather than collected first.
+func DumpStructures(config *config.Config, gene *rlooper.Gene, ec *rlooper.ExecutionContext, model *rlooper.ModelParams, wp rlooper.WindowParams) error {
+	w, err := newDumpWriter(config)
+	if err != nil {
+		return err
+	}
+	if _, err := dumpGene(w, gene, ec, model, wp); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// newDumpWriter creates the dump file next to the other outputs in the format set by --dump-format
+func newDumpWriter(config *config.Config) (StructureWriter, error) {
+	suffix, err := dumpFileSuffix(config.DumpFormat)
+	if err != nil {
+		return nil, err
+	}
+	return NewStructureWriter(outputBasePath(config)+suffix, config.DumpFormat)
+}
+
+// resumeDumpWriter reopens the dump an interrupted run left, cut back to its size in sizes, or creates a new
+// one if the run had not started it. only TSV dumps can be resumed.
+func resumeDumpWriter(config *config.Config, sizes map[string]int64) (StructureWriter, error) {
+	suffix, err := dumpFileSuffix(config.DumpFormat)
+	if err != nil {
+		return nil, err
+	}
+	path := outputBasePath(config) + suffix
+	size, ok := sizes[filepath.Base(path)]
+	if !ok {
+		return newDumpWriter(config)
+	}
+	return resumeTSVStructureWriter(path, size)
+}
+
+// dumpGene streams every structure of gene to w, starting a new gene for the writers that record one, and
+// returns the base pair probabilities the enumeration gives
+func dumpGene(w StructureWriter, gene *rlooper.Gene, ec *rlooper.ExecutionContext, model *rlooper.ModelParams, wp rlooper.WindowParams) ([]float64, error) {
+	if gw, ok := w.(geneStructureWriter); ok {
+		if err := gw.StartGene(gene.GeneName); err != nil {
+			return nil, err
+		}
+	}
+	probabilities, err := gene.StreamBasePairProbabilities(ec, model, wp, w.WriteStructures)
+	if err != nil {
+		return nil, fmt.Errorf("error dumping structures: %v", err)
+	}
+	return probabilities, nil
+}
@@ -0,0 +1,99 @@
+package sim
+
+import (
+	"bufio"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golooper/rlooper"
+)
+
+var dumpTestStructures = []rlooper.Structure{
+	{Pos: rlooper.Loci{Chromosome: "chr1", StartPos: 0, EndPos: 4}, Length: 5, FreeEnergy: -1.5, BoltzmannFactor: 2.5, Probability: 0.25},
+	{Pos: rlooper.Loci{Chromosome: "chr1", StartPos: 3, EndPos: 1}, Length: 4, FreeEnergy: 0.5, BoltzmannFactor: 0.75, Probability: 0.125},
+}
+
+func TestTSVStructureWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.tsv")
+	w, err := NewStructureWriter(path, "tsv")
+	if err != nil {
+		t.Fatalf("Failed to create structure writer: %v", err)
+	}
+	if err := w.WriteStructures(dumpTestStructures); err != nil {
+		t.Fatalf("Failed to write structures: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close structure writer: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open dump: %v", err)
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	expected := []string{
+		"chromosome\tstart\tend\tlength\tfree_energy\tboltzmann_factor\tprobability",
+		"chr1\t0\t4\t5\t-1.5\t2.5\t0.25",
+		"chr1\t3\t1\t4\t0.5\t0.75\t0.125",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("TSV dump mismatch. Got:\n%s\nExpected:\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestBinaryStructureWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.bin")
+	w, err := NewStructureWriter(path, "binary")
+	if err != nil {
+		t.Fatalf("Failed to create structure writer: %v", err)
+	}
+	if err := w.WriteStructures(dumpTestStructures); err != nil {
+		t.Fatalf("Failed to write structures: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close structure writer: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read dump: %v", err)
+	}
+	// magic, one chromosome record and two fixed size structure records
+	expectedSize := len(binaryDumpMagic) + (1 + 2 + len("chr1")) + 2*49
+	if len(data) != expectedSize {
+		t.Fatalf("Expected %d bytes, got %d", expectedSize, len(data))
+	}
+	if string(data[:len(binaryDumpMagic)]) != binaryDumpMagic {
+		t.Errorf("Missing binary dump magic")
+	}
+
+	record := data[len(binaryDumpMagic)+7+49:]
+	if record[0] != dumpTagStructure {
+		t.Fatalf("Expected structure record, got tag %q", record[0])
+	}
+	if start := int64(binary.LittleEndian.Uint64(record[5:])); start != 3 {
+		t.Errorf("Expected start 3, got %d", start)
+	}
+	if length := binary.LittleEndian.Uint32(record[21:]); length != 4 {
+		t.Errorf("Expected length 4, got %d", length)
+	}
+	if p := math.Float64frombits(binary.LittleEndian.Uint64(record[41:])); p != 0.125 {
+		t.Errorf("Expected probability 0.125, got %v", p)
+	}
+}
+
+func TestNewStructureWriterUnknownFormat(t *testing.T) {
+	if _, err := NewStructureWriter(filepath.Join(t.TempDir(), "dump"), "csv"); err == nil {
+		t.Errorf("Expected error for unknown dump format")
+	}
+}
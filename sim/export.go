@@ -0,0 +1,285 @@
+package sim
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"golooper/config"
+	"golooper/rlooper"
+)
+
+// ResultSchema names the layout of the JSON and NDJSON results. ResultSchemaVersion is bumped whenever a
+// field is renamed, removed or changes meaning; adding a field does not bump it.
+const (
+	ResultSchema        = "golooper.result"
+	ResultSchemaVersion = 1
+)
+
+// jsonNumber is a float that is written as null when it is NaN or infinite, which JSON cannot represent
+type jsonNumber float64
+
+func (v jsonNumber) MarshalJSON() ([]byte, error) {
+	f := float64(v)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return []byte("null"), nil
+	}
+	return strconv.AppendFloat(nil, f, 'g', -1, 64), nil
+}
+
+// jsonNumbers is a per-base array written with null for bases that have no value
+type jsonNumbers []float64
+
+func (values jsonNumbers) MarshalJSON() ([]byte, error) {
+	if values == nil {
+		return []byte("null"), nil
+	}
+	buf := []byte{'['}
+	for i, v := range values {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		b, _ := jsonNumber(v).MarshalJSON()
+		buf = append(buf, b...)
+	}
+	return append(buf, ']'), nil
+}
+
+// FastaHeaderRecord holds the fields parsed from the FASTA header
+type FastaHeaderRecord struct {
+	GeneName      string `json:"gene_name"`
+	Chromosome    string `json:"chromosome"`
+	BasePairRange string `json:"bp_range"`
+	Start         int64  `json:"start"`
+	End           int64  `json:"end"`
+	FivePad       int    `json:"five_pad"`
+	ThreePad      int    `json:"three_pad"`
+	Strand        string `json:"strand"`
+	RepeatMasking string `json:"repeat_masking"`
+}
+
+// GeneMetadata describes the simulated sequence. start is the 0-based genomic position of the first base,
+// so base i of every per-base array is at start+i.
+type GeneMetadata struct {
+	Name        string            `json:"name"`
+	Header      string            `json:"header"`
+	Chromosome  string            `json:"chromosome"`
+	Start       int64             `json:"start"`
+	Strand      string            `json:"strand"`
+	Length      int               `json:"length"`
+	FastaHeader FastaHeaderRecord `json:"fasta_header"`
+}
+
+// ModelMetadata holds the model parameters and loop restrictions the results were computed with, after
+// defaults and automatic sizing were applied
+type ModelMetadata struct {
+	Engine               string   `json:"engine"`
+	N                    float64  `json:"N"`
+	A                    float64  `json:"A"`
+	C                    float64  `json:"C"`
+	T                    float64  `json:"T"`
+	K                    float64  `json:"k"`
+	Sigma                float64  `json:"sigma"`
+	Alpha                float64  `json:"alpha"`
+	NucleationFreeEnergy float64  `json:"a"`
+	GroundStateEnergy    float64  `json:"ground_state_energy"`
+	HomopolymerEnergy    *float64 `json:"homopolymer_energy,omitempty"`
+	MinLength            int      `json:"min_length"`
+	MaxLength            int      `json:"max_length,omitempty"`
+	Circular             bool     `json:"circular"`
+	LengthPrior          string   `json:"length_prior,omitempty"`
+	MaxLoops             int      `json:"max_loops"`
+	PeakThreshold        float64  `json:"peak_threshold"`
+	MfeMargin            float64  `json:"mfe_margin"`
+}
+
+// ResultSummary condenses the per-base arrays. the partition function and loop probability are those of
+// the single loop ensemble that the top structures are drawn from.
+type ResultSummary struct {
+	PartitionFunction      jsonNumber `json:"partition_function"`
+	LoopProbability        jsonNumber `json:"loop_probability"`
+	MeanBpProbability      jsonNumber `json:"mean_bp_probability"`
+	MaxBpProbability       jsonNumber `json:"max_bp_probability"`
+	MaxBpProbabilityOffset int        `json:"max_bp_probability_offset"`
+	MinFreeEnergy          jsonNumber `json:"min_free_energy"`
+	ProbabilityPeaks       int        `json:"probability_peaks"`
+	EnergyPeaks            int        `json:"energy_peaks"`
+}
+
+// ResultTracks are the per-base arrays, null where a base has no value
+type ResultTracks struct {
+	BpProbability jsonNumbers `json:"bp_probability"`
+	AverageEnergy jsonNumbers `json:"average_energy"`
+	MinFreeEnergy jsonNumbers `json:"min_free_energy"`
+}
+
+// StructureRecord is one of the most probable loops. start and end are 0-based half-open genomic
+// positions; a loop wrapping around a circular sequence ends past the end of the sequence.
+type StructureRecord struct {
+	Chromosome      string     `json:"chromosome"`
+	Start           int64      `json:"start"`
+	End             int64      `json:"end"`
+	Length          int        `json:"length"`
+	FreeEnergy      jsonNumber `json:"free_energy"`
+	BoltzmannFactor jsonNumber `json:"boltzmann_factor"`
+	Probability     jsonNumber `json:"probability"`
+}
+
+// GeneResult is everything computed for one gene
+type GeneResult struct {
+	Gene          GeneMetadata      `json:"gene"`
+	Model         ModelMetadata     `json:"model"`
+	Summary       ResultSummary     `json:"summary"`
+	Tracks        ResultTracks      `json:"tracks"`
+	TopStructures []StructureRecord `json:"top_structures"`
+}
+
+// resultDocument is the layout of the JSON output
+type resultDocument struct {
+	Schema        string       `json:"schema"`
+	SchemaVersion int          `json:"schema_version"`
+	Genes         []GeneResult `json:"genes"`
+}
+
+// resultLine is the layout of each NDJSON line, one gene per line
+type resultLine struct {
+	Schema        string `json:"schema"`
+	SchemaVersion int    `json:"schema_version"`
+	GeneResult
+}
+
+// engineName returns the engine that computes the probabilities for config
+func engineName(config *config.Config) string {
+	switch {
+	case config.SampleSteps > 0:
+		return "sample"
+	case config.Engine == "":
+		return rlooper.EngineEnumerate
+	default:
+		return config.Engine
+	}
+}
+
+// newModelMetadata returns the effective model parameters and loop restrictions
+func newModelMetadata(config *config.Config, model *rlooper.ModelParams, wp rlooper.WindowParams) ModelMetadata {
+	metadata := ModelMetadata{
+		Engine:               engineName(config),
+		N:                    model.N,
+		A:                    model.A,
+		C:                    model.C,
+		T:                    model.T,
+		K:                    model.K(),
+		Sigma:                model.Sigma(),
+		Alpha:                model.Alpha(),
+		NucleationFreeEnergy: model.NucleationFreeEnergy(),
+		GroundStateEnergy:    model.GroundStateEnergy(),
+		MinLength:            wp.MinLength,
+		MaxLength:            wp.MaxLength,
+		Circular:             wp.Circular,
+		LengthPrior:          config.LengthPrior,
+		MaxLoops:             1,
+		PeakThreshold:        config.PeakThreshold,
+		MfeMargin:            config.MfeMargin,
+	}
+	if energy, ok := model.HomopolymerOverride(); ok {
+		metadata.HomopolymerEnergy = &energy
+	}
+	if config.MaxLoops != nil && *config.MaxLoops > 1 {
+		metadata.MaxLoops = *config.MaxLoops
+	}
+	return metadata
+}
+
+// newGeneResult collects the results computed for gene. energies may be nil when they were not computed.
+func newGeneResult(config *config.Config, gene *rlooper.Gene, model *rlooper.ModelParams, wp rlooper.WindowParams, probabilities []float64, energies *rlooper.EnergyTracks, top []rlooper.Structure, z float64) GeneResult {
+	offset := genomicOffset(gene)
+	h := gene.HeaderFields
+	result := GeneResult{
+		Gene: GeneMetadata{
+			Name:       gene.GeneName,
+			Header:     gene.Header,
+			Chromosome: chromName(gene),
+			Start:      offset,
+			Strand:     bedStrand(gene),
+			Length:     len(gene.Sequence),
+			FastaHeader: FastaHeaderRecord{
+				GeneName:      h.GeneName,
+				Chromosome:    h.Chromosome,
+				BasePairRange: h.BasePairRange,
+				Start:         h.Start,
+				End:           h.End,
+				FivePad:       h.FivePad,
+				ThreePad:      h.ThreePad,
+				Strand:        h.Strand,
+				RepeatMasking: h.RepeatMasking,
+			},
+		},
+		Model:         newModelMetadata(config, model, wp),
+		Tracks:        ResultTracks{BpProbability: probabilities},
+		TopStructures: make([]StructureRecord, len(top)),
+	}
+	summary := &result.Summary
+	summary.PartitionFunction = jsonNumber(z)
+	summary.LoopProbability = jsonNumber(1 - model.GroundStateFactor()/z)
+	summary.MinFreeEnergy = jsonNumber(math.NaN())
+	for i, p := range probabilities {
+		summary.MeanBpProbability += jsonNumber(p)
+		if i == 0 || p > float64(summary.MaxBpProbability) {
+			summary.MaxBpProbability, summary.MaxBpProbabilityOffset = jsonNumber(p), i
+		}
+	}
+	if len(probabilities) > 0 {
+		summary.MeanBpProbability /= jsonNumber(len(probabilities))
+	}
+	summary.ProbabilityPeaks = len(probabilityPeaks(probabilities, config.PeakThreshold))
+	if energies != nil {
+		result.Tracks.AverageEnergy = energies.AverageEnergy
+		result.Tracks.MinFreeEnergy = energies.MinFreeEnergy
+		for _, e := range energies.MinFreeEnergy {
+			if !math.IsNaN(e) && (math.IsNaN(float64(summary.MinFreeEnergy)) || e < float64(summary.MinFreeEnergy)) {
+				summary.MinFreeEnergy = jsonNumber(e)
+			}
+		}
+		summary.EnergyPeaks = len(energyPeaks(energies.MinFreeEnergy, config.MfeMargin))
+	}
+
+	for i, s := range top {
+		start := offset + s.Pos.StartPos
+		result.TopStructures[i] = StructureRecord{
+			Chromosome:      chromName(gene),
+			Start:           start,
+			End:             start + int64(s.Length),
+			Length:          s.Length,
+			FreeEnergy:      jsonNumber(s.FreeEnergy),
+			BoltzmannFactor: jsonNumber(s.BoltzmannFactor),
+			Probability:     jsonNumber(s.Probability),
+		}
+	}
+	return result
+}
+
+// writeResultJSON writes results as a single indented JSON document
+func writeResultJSON(w io.Writer, results []GeneResult) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(resultDocument{Schema: ResultSchema, SchemaVersion: ResultSchemaVersion, Genes: results}); err != nil {
+		return fmt.Errorf("error encoding JSON results: %v", err)
+	}
+	return nil
+}
+
+// writeResultNDJSON writes one JSON object per gene per line, each carrying the schema so that lines can
+// be consumed on their own
+func writeResultNDJSON(w io.Writer, results []GeneResult) error {
+	buf := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buf)
+	for _, result := range results {
+		if err := encoder.Encode(resultLine{Schema: ResultSchema, SchemaVersion: ResultSchemaVersion, GeneResult: result}); err != nil {
+			return fmt.Errorf("error encoding NDJSON results: %v", err)
+		}
+	}
+	return buf.Flush()
+}
@@ -0,0 +1,145 @@
+package sim
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+
+	"golooper/config"
+	"golooper/rlooper"
+)
+
+func TestJSONNumbers(t *testing.T) {
+	b, err := json.Marshal(jsonNumbers{0.5, math.NaN(), -2, math.Inf(1)})
+	if err != nil {
+		t.Fatalf("Marshal returned error: %v", err)
+	}
+	if string(b) != "[0.5,null,-2,null]" {
+		t.Errorf("expected NaN and Inf as null, got %s", b)
+	}
+}
+
+// testGeneResult computes a small result the way SimulationA does
+func testGeneResult(t *testing.T) GeneResult {
+	t.Helper()
+	gene := &rlooper.Gene{
+		GeneName: "test",
+		Header:   ">test range=chr1:101-132 5'pad=0 3'pad=0 strand=+",
+		HeaderFields: rlooper.FastaHeader{
+			GeneName: "test", Chromosome: "chr1", Start: 101, End: 132, Strand: "+",
+		},
+		Pos:      rlooper.Loci{Chromosome: "chr1", Strand: "+", StartPos: 101, EndPos: 132},
+		Sequence: []rune("GATTACAGGGCCCGATTACAGGGAAATTTCCC"),
+	}
+	cfg := &config.Config{PeakThreshold: DefaultPeakThreshold, TopStructures: 3}
+	model := rlooper.NewParamsReasonableDefaults()
+	wp := rlooper.WindowParams{MinLength: 2, MaxLength: 20}
+	ec := &rlooper.ExecutionContext{NumThreads: 2, WaitGroup: &sync.WaitGroup{}}
+
+	probabilities, err := gene.BasePairProbabilities(ec, &model, wp)
+	if err != nil {
+		t.Fatalf("BasePairProbabilities returned error: %v", err)
+	}
+	energies, err := gene.ComputeEnergyTracks(ec, &model, wp)
+	if err != nil {
+		t.Fatalf("ComputeEnergyTracks returned error: %v", err)
+	}
+	top, z, err := gene.TopStructures(ec, &model, wp, cfg.TopStructures)
+	if err != nil {
+		t.Fatalf("TopStructures returned error: %v", err)
+	}
+	return newGeneResult(cfg, gene, &model, wp, probabilities, energies, top, z)
+}
+
+func TestNewGeneResult(t *testing.T) {
+	result := testGeneResult(t)
+	if result.Gene.Start != 100 || result.Gene.Length != 32 || result.Gene.FastaHeader.Chromosome != "chr1" {
+		t.Errorf("unexpected gene metadata %+v", result.Gene)
+	}
+	if result.Model.Engine != rlooper.EngineEnumerate || result.Model.MaxLength != 20 || result.Model.MaxLoops != 1 {
+		t.Errorf("unexpected model metadata %+v", result.Model)
+	}
+	if len(result.TopStructures) != 3 {
+		t.Fatalf("expected 3 top structures, got %d", len(result.TopStructures))
+	}
+	for i, s := range result.TopStructures {
+		if s.End-s.Start != int64(s.Length) || s.Start < 100 {
+			t.Errorf("structure %d: unexpected coordinates %+v", i, s)
+		}
+		if i > 0 && s.Probability > result.TopStructures[i-1].Probability {
+			t.Errorf("structure %d is more probable than the one before it", i)
+		}
+	}
+	summary := result.Summary
+	if summary.LoopProbability < 0 || summary.LoopProbability > 1 {
+		t.Errorf("loop probability %v out of range", summary.LoopProbability)
+	}
+	if p := result.Tracks.BpProbability[summary.MaxBpProbabilityOffset]; jsonNumber(p) != summary.MaxBpProbability {
+		t.Errorf("max bp probability %v is not at offset %d", summary.MaxBpProbability, summary.MaxBpProbabilityOffset)
+	}
+}
+
+func TestWriteResults(t *testing.T) {
+	results := []GeneResult{testGeneResult(t), testGeneResult(t)}
+
+	var buf bytes.Buffer
+	if err := writeResultJSON(&buf, results); err != nil {
+		t.Fatalf("writeResultJSON returned error: %v", err)
+	}
+	var document struct {
+		Schema        string `json:"schema"`
+		SchemaVersion int    `json:"schema_version"`
+		Genes         []struct {
+			Gene   map[string]any        `json:"gene"`
+			Tracks map[string][]*float64 `json:"tracks"`
+		} `json:"genes"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &document); err != nil {
+		t.Fatalf("JSON output does not parse: %v", err)
+	}
+	if document.Schema != ResultSchema || document.SchemaVersion != ResultSchemaVersion || len(document.Genes) != 2 {
+		t.Errorf("unexpected document %s v%d with %d genes", document.Schema, document.SchemaVersion, len(document.Genes))
+	}
+	if len(document.Genes) > 0 && len(document.Genes[0].Tracks["bp_probability"]) != 32 {
+		t.Errorf("expected 32 probabilities, got %d", len(document.Genes[0].Tracks["bp_probability"]))
+	}
+
+	buf.Reset()
+	if err := writeResultNDJSON(&buf, results); err != nil {
+		t.Fatalf("writeResultNDJSON returned error: %v", err)
+	}
+	scanner := bufio.NewScanner(&buf)
+	scanner.Buffer(nil, 1<<20)
+	lines := 0
+	for scanner.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("NDJSON line %d does not parse: %v", lines, err)
+		}
+		if line["schema"] != ResultSchema || line["schema_version"] != float64(ResultSchemaVersion) || line["gene"] == nil {
+			t.Errorf("NDJSON line %d lacks the schema or gene: %v", lines, line["schema"])
+		}
+		lines++
+	}
+	if lines != 2 {
+		t.Errorf("expected 2 NDJSON lines, got %d", lines)
+	}
+}
+
+func TestParseOutputsOnRequest(t *testing.T) {
+	selected, err := parseOutputs("")
+	if err != nil || selected["json"] || selected["ndjson"] || !selected["bpprob"] {
+		t.Errorf("expected all to leave out json and ndjson, got %v (err %v)", selected, err)
+	}
+	selected, err = parseOutputs("all,json")
+	if err != nil || !selected["json"] || selected["ndjson"] || !selected["mfe-bed"] {
+		t.Errorf("expected all plus json, got %v (err %v)", selected, err)
+	}
+	if !strings.Contains(strings.Join(outputNames(), ","), "ndjson") {
+		t.Error("expected ndjson to be registered")
+	}
+}
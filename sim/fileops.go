@@ -1,181 +1,174 @@
 package sim
 
 import (
+	"errors"
 	"fmt"
 	"golooper/config"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 )
 
-// createFileWithDir creates a file and its parent directories if they don't exist
-func createFileWithDir(path string) (*os.File, error) {
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return nil, fmt.Errorf("error creating directory %s: %v", dir, err)
+// partialSuffix marks an output that is still being written. outputs are written under the partial name and
+// only renamed to their own once complete, so an interrupted run never leaves a truncated file that looks
+// like a finished one.
+const partialSuffix = ".partial"
+
+// outputFile is an output being written under its partial name. Close moves it into place and Abort
+// removes it.
+type outputFile struct {
+	*os.File
+	path string // where the file is moved by Close
+}
+
+// Close flushes the file to disk, closes it and renames it to its own path, so that a crash after the
+// rename never leaves a truncated output in place. a file that cannot be flushed is removed.
+func (f *outputFile) Close() error {
+	if err := f.finish(); err != nil {
+		return err
 	}
-	return os.Create(path)
+	return f.commit()
 }
 
-// createOutputFile is a helper function that creates a file and writes its header
-func createOutputFile(path string, headerFunc func(*os.File) error, headerName string) (*os.File, error) {
-	file, err := createFileWithDir(path)
-	if err != nil {
-		return nil, fmt.Errorf("error creating %s file at %s: %v", headerName, path, err)
+// finish flushes the file to disk and closes it under its partial name, removing it if either fails
+func (f *outputFile) finish() error {
+	if err := f.Sync(); err != nil {
+		f.File.Close()
+		os.Remove(f.Name())
+		return err
 	}
-	if err := headerFunc(file); err != nil {
-		file.Close()
-		return nil, fmt.Errorf("error writing %s header: %v", headerName, err)
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
 	}
-	return file, nil
+	return nil
 }
 
-type FileOps struct {
-	BasePairProbWig         *os.File
-	AverageEnergyWig        *os.File
-	MinFreeEnergyWig        *os.File
-	BasePairProbBed         *os.File
-	MinFreeEnergyBed        *os.File
-	ExtendedBasePairProbWig *os.File
+// commit moves a finished file into place
+func (f *outputFile) commit() error {
+	return os.Rename(f.Name(), f.path)
 }
 
-func writeWigfileHeader(outfile *os.File, trackname string) error {
-	// Compose .wig header with track definition line
-	header := fmt.Sprintf("track type=wiggle_0 name=\"%s\" visibility=full autoscale=off color=50,150,255 priority=10\n", trackname)
-
-	// Write header to file
-	_, err := outfile.WriteString(header)
-	if err != nil {
-		return fmt.Errorf("error writing wig header: %v", err)
+// Abort closes the file and removes it, leaving nothing at its path
+func (f *outputFile) Abort() error {
+	f.File.Close()
+	if err := os.Remove(f.Name()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
 	}
-
 	return nil
 }
 
-func writeBedfileHeader(outfile *os.File, trackname string) error {
-	// Compose bed header with track definition line
-	header := fmt.Sprintf("track name=rLooper description=\"%s\" useScore=1\n", trackname)
+// Keep closes the file and leaves it under its partial name for --resume to continue
+func (f *outputFile) Keep() error {
+	return f.File.Close()
+}
 
-	// Write header to file
-	_, err := outfile.WriteString(header)
-	if err != nil {
-		return fmt.Errorf("error writing bed header: %v", err)
+// syncedSize flushes the file to disk and returns the bytes written to it
+func (f *outputFile) syncedSize() (int64, error) {
+	if err := f.Sync(); err != nil {
+		return 0, err
 	}
-
-	return nil
+	return f.Seek(0, io.SeekCurrent)
 }
 
-// Close closes all open files in the FileOps struct
-func (f *FileOps) Close() error {
-	var errs []error
-
-	if f.BasePairProbWig != nil {
-		if err := f.BasePairProbWig.Close(); err != nil {
-			errs = append(errs, fmt.Errorf("error closing base pair prob wig: %v", err))
-		}
-	}
-	if f.AverageEnergyWig != nil {
-		if err := f.AverageEnergyWig.Close(); err != nil {
-			errs = append(errs, fmt.Errorf("error closing average energy wig: %v", err))
-		}
-	}
-	if f.MinFreeEnergyWig != nil {
-		if err := f.MinFreeEnergyWig.Close(); err != nil {
-			errs = append(errs, fmt.Errorf("error closing min free energy wig: %v", err))
-		}
+// resumeOutputFile reopens the partial file an interrupted run left at path to append to it after its first
+// size bytes, dropping anything written after its checkpoint
+func resumeOutputFile(path string, size int64) (*outputFile, error) {
+	file, err := os.OpenFile(path+partialSuffix, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resume %s: %v", path, err)
 	}
-	if f.BasePairProbBed != nil {
-		if err := f.BasePairProbBed.Close(); err != nil {
-			errs = append(errs, fmt.Errorf("error closing base pair prob bed: %v", err))
-		}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("cannot resume %s: %v", path, err)
 	}
-	if f.MinFreeEnergyBed != nil {
-		if err := f.MinFreeEnergyBed.Close(); err != nil {
-			errs = append(errs, fmt.Errorf("error closing min free energy bed: %v", err))
-		}
+	if info.Size() < size {
+		file.Close()
+		return nil, fmt.Errorf("cannot resume %s: it holds %d bytes but the checkpoint records %d", path, info.Size(), size)
 	}
-	if f.ExtendedBasePairProbWig != nil {
-		if err := f.ExtendedBasePairProbWig.Close(); err != nil {
-			errs = append(errs, fmt.Errorf("error closing extended base pair prob wig: %v", err))
-		}
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("cannot resume %s: %v", path, err)
 	}
-
-	if len(errs) > 0 {
-		return fmt.Errorf("errors closing files: %v", errs)
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("cannot resume %s: %v", path, err)
 	}
-	return nil
+	return &outputFile{File: file, path: path}, nil
 }
 
-func CreateOutputFiles(config *config.Config) (*FileOps, error) {
-	fileOps := &FileOps{}
-	basePath := filepath.Join(filepath.Dir(config.OutfileName), filepath.Base(config.OutfileName))
-
-	// Helper function to clean up on error
-	cleanup := func() error {
-		return fileOps.Close()
+// createFileWithDir creates a file under its partial name and its parent directories if they don't exist
+func createFileWithDir(path string) (*outputFile, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating directory %s: %v", dir, err)
 	}
-
-	// Create all output files
-	var err error
-
-	fileOps.BasePairProbWig, err = createOutputFile(
-		basePath+"_bpprob.wig",
-		func(f *os.File) error { return writeWigfileHeader(f, "Base Pair Probability") },
-		"base pair probability wig",
-	)
+	file, err := os.Create(path + partialSuffix)
 	if err != nil {
-		cleanup()
 		return nil, err
 	}
+	return &outputFile{File: file, path: path}, nil
+}
 
-	fileOps.AverageEnergyWig, err = createOutputFile(
-		basePath+"_avgG.wig",
-		func(f *os.File) error { return writeWigfileHeader(f, "Average Energy") },
-		"average energy wig",
-	)
-	if err != nil {
-		cleanup()
-		return nil, err
+// runMetadataComment returns a comment line recording the loop length restrictions an output was computed with
+func runMetadataComment(config *config.Config) string {
+	maxLength := "none"
+	if config.MaxRLoopLength != nil && *config.MaxRLoopLength > 0 {
+		maxLength = fmt.Sprint(*config.MaxRLoopLength)
+	}
+	prior := config.LengthPrior
+	if prior == "" {
+		prior = "none"
 	}
+	return fmt.Sprintf("# golooper minlength=%d maxlength=%s length_prior=%s\n", minLoopLength(config), maxLength, prior)
+}
 
-	fileOps.MinFreeEnergyWig, err = createOutputFile(
-		basePath+"_mfe.wig",
-		func(f *os.File) error { return writeWigfileHeader(f, "Minimum Free Energy") },
-		"minimum free energy wig",
-	)
+// createOutputFile is a helper function that creates a file and writes its header followed by the metadata comment
+func createOutputFile(path string, headerFunc func(*os.File) error, headerName string, metadata string) (*outputFile, error) {
+	file, err := createFileWithDir(path)
 	if err != nil {
-		cleanup()
-		return nil, err
+		return nil, fmt.Errorf("error creating %s file at %s: %v", headerName, path, err)
+	}
+	if err := headerFunc(file.File); err != nil {
+		file.Abort()
+		return nil, fmt.Errorf("error writing %s header: %v", headerName, err)
 	}
+	if _, err := file.WriteString(metadata); err != nil {
+		file.Abort()
+		return nil, fmt.Errorf("error writing %s metadata: %v", headerName, err)
+	}
+	return file, nil
+}
 
-	fileOps.BasePairProbBed, err = createOutputFile(
-		basePath+"_bpprob.bed",
-		func(f *os.File) error { return writeBedfileHeader(f, "Base Pair Probability") },
-		"base pair probability bed",
-	)
-	if err != nil {
-		cleanup()
-		return nil, err
+func writeWigfileHeader(outfile *os.File, attrs TrackAttributes) error {
+	if err := NewWigWriter(outfile, 1).WriteTrackLine(attrs); err != nil {
+		return fmt.Errorf("error writing wig header: %v", err)
 	}
+	return nil
+}
 
-	fileOps.MinFreeEnergyBed, err = createOutputFile(
-		basePath+"_mfe.bed",
-		func(f *os.File) error { return writeBedfileHeader(f, "Minimum Free Energy") },
-		"minimum free energy bed",
-	)
-	if err != nil {
-		cleanup()
-		return nil, err
+func writeBedfileHeader(outfile *os.File, trackname string, format string) error {
+	// Compose bed header with track definition line
+	header := fmt.Sprintf("track name=rLooper description=\"%s\" useScore=1", trackname)
+	switch format {
+	case BedFormat9:
+		header += " itemRgb=\"On\""
+	case BedFormatNarrowPeak:
+		header += " type=narrowPeak"
 	}
 
-	fileOps.ExtendedBasePairProbWig, err = createOutputFile(
-		basePath+"_extbpprob.wig",
-		func(f *os.File) error { return writeWigfileHeader(f, "Extended Base Pair Probability") },
-		"extended base pair probability wig",
-	)
+	// Write header to file
+	_, err := outfile.WriteString(header + "\n")
 	if err != nil {
-		cleanup()
-		return nil, err
+		return fmt.Errorf("error writing bed header: %v", err)
 	}
 
-	return fileOps, nil
+	return nil
+}
+
+// outputBasePath returns the path every output file name is derived from by appending a suffix
+func outputBasePath(config *config.Config) string {
+	return filepath.Join(filepath.Dir(config.OutfileName), filepath.Base(config.OutfileName))
 }
@@ -2,8 +2,10 @@ package sim
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"golooper/config"
+	"golooper/rlooper"
 	"os"
 	"path/filepath"
 	"strings"
@@ -23,38 +25,60 @@ func TestFileOps(t *testing.T) {
 		OutfileName: filepath.Join(tempDir, "test_output"),
 	}
 
-	// Test file creation
-	fileOps, err := CreateOutputFiles(testConfig)
+	fileOps, err := NewFileOps(testConfig)
 	if err != nil {
-		t.Fatalf("Failed to create output files: %v", err)
+		t.Fatalf("Failed to set up output files: %v", err)
 	}
 
-	// Verify all files were created
 	expectedFiles := []string{
 		"_bpprob.wig",
 		"_avgG.wig",
 		"_mfe.wig",
 		"_bpprob.bed",
 		"_mfe.bed",
-		"_extbpprob.wig",
+		"_bpprob.bedgraph",
 	}
 
+	// Nothing is created before the first write
+	for _, suffix := range expectedFiles {
+		if _, err := os.Stat(testConfig.OutfileName + suffix); !os.IsNotExist(err) {
+			t.Errorf("Expected %s not to exist before it is written", suffix)
+		}
+	}
+
+	// Every output is selected by default
+	for _, name := range outputNames() {
+		if err := fileOps.Write(name, func(*os.File) error { return nil }); err != nil {
+			t.Fatalf("Failed to create output %s: %v", name, err)
+		}
+	}
+	// Files are written under their partial name until closed
 	for _, suffix := range expectedFiles {
 		filePath := testConfig.OutfileName + suffix
-		if _, err := os.Stat(filePath); os.IsNotExist(err) {
-			t.Errorf("Expected file %s was not created", filePath)
+		if _, err := os.Stat(filePath + partialSuffix); os.IsNotExist(err) {
+			t.Errorf("Expected file %s was not created", filePath+partialSuffix)
+		}
+		if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+			t.Errorf("Expected %s not to exist before it is closed", filePath)
+		}
+	}
+
+	file := func(name string) *os.File {
+		f, err := fileOps.File(name)
+		if err != nil {
+			t.Fatalf("Failed to get output %s: %v", name, err)
 		}
+		return f
 	}
 
 	// Test wig file headers
-	checkWigHeader(t, fileOps.BasePairProbWig, "Base Pair Probability")
-	checkWigHeader(t, fileOps.AverageEnergyWig, "Average Energy")
-	checkWigHeader(t, fileOps.MinFreeEnergyWig, "Minimum Free Energy")
-	checkWigHeader(t, fileOps.ExtendedBasePairProbWig, "Extended Base Pair Probability")
+	checkWigHeader(t, file("bpprob"), "Base Pair Probability")
+	checkWigHeader(t, file("avgG"), "Average Energy")
+	checkWigHeader(t, file("mfe"), "Minimum Free Energy")
 
 	// Test bed file headers
-	checkBedHeader(t, fileOps.BasePairProbBed, "Base Pair Probability")
-	checkBedHeader(t, fileOps.MinFreeEnergyBed, "Minimum Free Energy")
+	checkBedHeader(t, file("bpprob-bed"), "Base Pair Probability")
+	checkBedHeader(t, file("mfe-bed"), "Minimum Free Energy")
 
 	// Test file closing
 	if err := fileOps.Close(); err != nil {
@@ -73,6 +97,80 @@ func TestFileOps(t *testing.T) {
 	}
 }
 
+func TestFileOpsAbort(t *testing.T) {
+	testConfig := &config.Config{OutfileName: filepath.Join(t.TempDir(), "test_output")}
+	fileOps, err := NewFileOps(testConfig)
+	if err != nil {
+		t.Fatalf("Failed to set up output files: %v", err)
+	}
+	if err := fileOps.Write("bpprob", func(f *os.File) error { _, err := f.WriteString("1\n"); return err }); err != nil {
+		t.Fatalf("Failed to write output: %v", err)
+	}
+	fileOps.Abort()
+	entries, err := os.ReadDir(filepath.Dir(testConfig.OutfileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected an aborted run to leave no files, found %v", entries)
+	}
+	if err := fileOps.Close(); err != nil {
+		t.Errorf("Expected Close after Abort to do nothing, got %v", err)
+	}
+}
+
+func TestOutputFileCloseSyncError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.wig")
+	file, err := createFileWithDir(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// closing the underlying file makes the Sync in Close fail
+	file.File.Close()
+	if err := file.Close(); err == nil {
+		t.Error("expected Close to return the error of Sync")
+	}
+	for _, p := range []string{path, path + partialSuffix} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected nothing at %s after a failed Close, got %v", p, err)
+		}
+	}
+}
+
+func TestFileOpsSelection(t *testing.T) {
+	testConfig := &config.Config{
+		OutfileName: filepath.Join(t.TempDir(), "test_output"),
+		Outputs:     "bpprob, MFE-bed",
+	}
+	fileOps, err := NewFileOps(testConfig)
+	if err != nil {
+		t.Fatalf("Failed to set up output files: %v", err)
+	}
+	defer fileOps.Close()
+
+	if !fileOps.Selected("bpprob") || !fileOps.Selected("mfe-bed") || fileOps.Selected("avgG") {
+		t.Errorf("Unexpected selection %v", fileOps.selected)
+	}
+	called := false
+	if err := fileOps.Write("avgG", func(*os.File) error { called = true; return nil }); err != nil || called {
+		t.Errorf("Expected writing an unselected output to do nothing, got called=%v err=%v", called, err)
+	}
+	if _, err := os.Stat(testConfig.OutfileName + "_avgG.wig"); !os.IsNotExist(err) {
+		t.Error("Expected no file for an unselected output")
+	}
+	if _, err := fileOps.File("avgG"); err == nil {
+		t.Error("Expected an error opening an unselected output")
+	}
+
+	// extbpprob was advertised but never written
+	for _, outputs := range []string{"bpprob,peaks", "extbpprob"} {
+		testConfig.Outputs = outputs
+		if _, err := NewFileOps(testConfig); err == nil {
+			t.Errorf("Expected an error for unknown outputs %q", outputs)
+		}
+	}
+}
+
 func checkWigHeader(t *testing.T, file *os.File, expectedName string) {
 	t.Helper()
 
@@ -116,3 +214,120 @@ func checkBedHeader(t *testing.T, file *os.File, expectedName string) {
 		t.Errorf("Bed header mismatch. Got: %s, Expected: %s", header, expectedHeader)
 	}
 }
+
+func TestRunMetadataComment(t *testing.T) {
+	maxLength := 500
+	testConfig := &config.Config{
+		MaxRLoopLength: &maxLength,
+		LengthPrior:    "normal:300:100",
+	}
+	expected := "# golooper minlength=2 maxlength=500 length_prior=normal:300:100\n"
+	if comment := runMetadataComment(testConfig); comment != expected {
+		t.Errorf("Metadata comment mismatch. Got: %s, Expected: %s", comment, expected)
+	}
+	if comment := runMetadataComment(&config.Config{}); !strings.Contains(comment, "maxlength=none length_prior=none") {
+		t.Errorf("Expected unset restrictions to be reported as none, got: %s", comment)
+	}
+}
+
+func TestFileOpsBigWig(t *testing.T) {
+	testConfig := &config.Config{
+		OutfileName: filepath.Join(t.TempDir(), "test_output"),
+		Format:      FormatBigWig,
+	}
+	fileOps, err := NewFileOps(testConfig)
+	if err != nil {
+		t.Fatalf("Failed to set up output files: %v", err)
+	}
+	defer fileOps.Close()
+
+	gene := &rlooper.Gene{
+		GeneName: "test",
+		Pos:      rlooper.Loci{Chromosome: "chr1", StartPos: 1, EndPos: 3},
+		Sequence: []rune("GAT"),
+	}
+	other := &rlooper.Gene{
+		GeneName: "other",
+		Pos:      rlooper.Loci{Chromosome: "chr2", StartPos: 11, EndPos: 12},
+		Sequence: []rune("GA"),
+	}
+	if err := writeTrack(testConfig, gene, fileOps, "bpprob", []float64{0.1, 0.2, 0.3}); err != nil {
+		t.Fatalf("writeTrack returned error: %v", err)
+	}
+	if err := writeTrack(testConfig, other, fileOps, "bpprob", []float64{0.4, 0.5}); err != nil {
+		t.Fatalf("writeTrack returned error: %v", err)
+	}
+
+	// bigWig tracks of every gene are written in one go when the outputs are closed, so no wig file is
+	// opened for them
+	if _, err := os.Stat(testConfig.OutfileName + "_bpprob.bw"); !os.IsNotExist(err) {
+		t.Error("Expected the bigwig track to wait for Close")
+	}
+	if err := fileOps.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	b, err := os.ReadFile(testConfig.OutfileName + "_bpprob.bw")
+	if err != nil {
+		t.Fatalf("Expected a bigwig track: %v", err)
+	}
+	if !bytes.Contains(b, []byte("chr1")) || !bytes.Contains(b, []byte("chr2")) {
+		t.Error("Expected both genes in the bigwig track")
+	}
+	if _, err := os.Stat(testConfig.OutfileName + "_bpprob.wig"); !os.IsNotExist(err) {
+		t.Error("Expected no wig file for bigwig output")
+	}
+}
+
+func TestFileOpsBigWigOverlap(t *testing.T) {
+	testConfig := &config.Config{OutfileName: filepath.Join(t.TempDir(), "test_output"), Format: FormatBigWig}
+	fileOps, err := NewFileOps(testConfig)
+	if err != nil {
+		t.Fatalf("Failed to set up output files: %v", err)
+	}
+	defer fileOps.Abort()
+	gene := &rlooper.Gene{GeneName: "forward", Pos: rlooper.Loci{Chromosome: "chr1", StartPos: 11}, Sequence: []rune("GATTACA")}
+	before := &rlooper.Gene{GeneName: "before", Pos: rlooper.Loci{Chromosome: "chr1", StartPos: 1}, Sequence: []rune("GATTACAGAT")}
+	reverse := &rlooper.Gene{GeneName: "reverse", Pos: rlooper.Loci{Chromosome: "chr1", StartPos: 15}, Sequence: []rune("GATTACA")}
+	if err := writeTrack(testConfig, gene, fileOps, "bpprob", make([]float64, 7)); err != nil {
+		t.Fatalf("writeTrack returned error: %v", err)
+	}
+	if err := writeTrack(testConfig, before, fileOps, "bpprob", make([]float64, 10)); err != nil {
+		t.Errorf("expected a record ending where the next starts to be accepted, got %v", err)
+	}
+	// records overlapping on a chromosome, such as bidirectional promoters, are refused when the second is
+	// added rather than once every gene has been computed
+	if err := writeTrack(testConfig, reverse, fileOps, "bpprob", make([]float64, 7)); err == nil || !strings.Contains(err.Error(), "--format wig") {
+		t.Errorf("expected overlapping records to be refused, got %v", err)
+	}
+}
+
+func TestFileOpsCloseAbortsOnError(t *testing.T) {
+	dir := t.TempDir()
+	sizesPath := filepath.Join(dir, "chrom.sizes")
+	if err := os.WriteFile(sizesPath, []byte("chr2\t1000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testConfig := &config.Config{OutfileName: filepath.Join(dir, "out", "test_output"), Format: FormatBigWig, ChromSizes: sizesPath}
+	fileOps, err := NewFileOps(testConfig)
+	if err != nil {
+		t.Fatalf("Failed to set up output files: %v", err)
+	}
+	gene := &rlooper.Gene{GeneName: "test", Pos: rlooper.Loci{Chromosome: "chr1", StartPos: 1}, Sequence: []rune("GAT")}
+	if err := writeTrack(testConfig, gene, fileOps, "bpprob", []float64{0.1, 0.2, 0.3}); err != nil {
+		t.Fatalf("writeTrack returned error: %v", err)
+	}
+	if err := fileOps.Write("bedgraph", func(f *os.File) error { return writeBedGraph(f, gene, []float64{0.1, 0.2, 0.3}) }); err != nil {
+		t.Fatalf("Failed to write bedgraph: %v", err)
+	}
+	// chr1 is missing from chrom.sizes, so the bigWig fails and the bedGraph must not be kept either
+	if err := fileOps.Close(); err == nil {
+		t.Fatal("expected Close to fail for a chromosome with no size")
+	}
+	entries, err := os.ReadDir(filepath.Dir(testConfig.OutfileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected a failed Close to leave no outputs, found %v", entries)
+	}
+}
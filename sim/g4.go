@@ -0,0 +1,40 @@
+package sim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+
+	"golooper/rlooper"
+)
+
+// g4Motifs returns the G-quadruplexes predicted on the displaced strand of gene
+func g4Motifs(gene *rlooper.Gene) []rlooper.G4 {
+	return rlooper.FindG4Motifs(gene.Sequence, gene.Pos.Strand == "-")
+}
+
+// chromName returns the chromosome name used for gene in track files, falling back to the gene name when
+// the header has no range
+func chromName(gene *rlooper.Gene) string {
+	if gene.Pos.Chromosome != "" {
+		return gene.Pos.Chromosome
+	}
+	return gene.GeneName
+}
+
+// writeG4Bed writes a BED record per G-quadruplex of gene in genomic coordinates, scoring each motif by its
+// G4Hunter score scaled so that the maximum score of 4 maps to 1000
+func writeG4Bed(w io.Writer, gene *rlooper.Gene, motifs []rlooper.G4) error {
+	strand := bedStrand(gene)
+	offset := genomicOffset(gene)
+	buf := bufio.NewWriter(w)
+	for _, g := range motifs {
+		score := int(math.Min(1000, math.Round(g.Score/4*1000)))
+		fmt.Fprintf(buf, "%s\t%d\t%d\tG4\t%d\t%s\n", chromName(gene), offset+int64(g.Start), offset+int64(g.End)+1, score, strand)
+	}
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("error writing G-quadruplex bed: %v", err)
+	}
+	return nil
+}
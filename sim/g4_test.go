@@ -0,0 +1,60 @@
+package sim
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golooper/config"
+	"golooper/rlooper"
+)
+
+func TestWriteG4Track(t *testing.T) {
+	bonus := 1.0
+	testConfig := &config.Config{OutfileName: filepath.Join(t.TempDir(), "test_output"), G4Bonus: &bonus, Outputs: "bpprob"}
+	gene := &rlooper.Gene{
+		GeneName: "test",
+		Pos:      rlooper.Loci{Chromosome: "chr1", Strand: "+", StartPos: 101},
+		Sequence: []rune("ATGGGAGGGTTGGGCAGGGTATT"),
+	}
+
+	// the G4 track is written by the gene writer of a run, beside the tracks of the gene
+	outputs, err := NewFileOps(testConfig)
+	if err != nil {
+		t.Fatalf("Failed to set up output files: %v", err)
+	}
+	writer, err := newGeneWriter(testConfig, newExecutionContext(context.Background(), testConfig), outputs, newRunManifest(testConfig, 1), nil)
+	if err != nil {
+		t.Fatalf("newGeneWriter returned error: %v", err)
+	}
+	job := &geneJob{
+		gene:          gene,
+		model:         rlooper.NewParamsReasonableDefaults(),
+		wp:            rlooper.WindowParams{MinLength: rlooper.DefaultMinLoopLength},
+		motifs:        g4Motifs(gene),
+		probabilities: make([]float64, len(gene.Sequence)),
+	}
+	if err := writer.write(job); err != nil {
+		t.Fatalf("write returned error: %v", err)
+	}
+	if err := writer.close(); err != nil {
+		t.Fatalf("close returned error: %v", err)
+	}
+	if err := outputs.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	data, err := os.ReadFile(testConfig.OutfileName + "_g4.bed")
+	if err != nil {
+		t.Fatalf("Failed to read G4 track: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "# golooper version=") {
+		t.Fatalf("Expected header, metadata, provenance and one record, got %q", lines)
+	}
+	if expected := "chr1\t102\t119\tG4\t515\t+"; lines[3] != expected {
+		t.Errorf("G4 record = %q, want %q", lines[3], expected)
+	}
+}
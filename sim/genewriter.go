@@ -0,0 +1,219 @@
+package sim
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"golooper/config"
+	"golooper/rlooper"
+)
+
+// geneWriter writes the outputs of the genes scheduleGenes hands it in input order, and records each gene
+// in the run manifest and, when the outputs can be resumed, in the checkpoint
+type geneWriter struct {
+	config     *config.Config
+	ec         *rlooper.ExecutionContext
+	outputs    *FileOps
+	manifest   *RunManifest
+	checkpoint *checkpointer // nil when the outputs cannot be resumed
+
+	// the dump and the parquet tracks are single files that every gene is added to
+	dump        StructureWriter
+	parquet     *parquetTrackWriter
+	results     []GeneResult // the JSON results are one array, written once every gene is in
+	wantResults bool
+
+	genes, skipped int
+}
+
+// newGeneWriter starts writing the genes of a run to outputs, opening the structure dump when config asks
+// for one. checkpoint is nil when the outputs cannot be resumed; on --resume it holds the outputs to append
+// to.
+func newGeneWriter(config *config.Config, ec *rlooper.ExecutionContext, outputs *FileOps, manifest *RunManifest, checkpoint *Checkpoint) (*geneWriter, error) {
+	w := &geneWriter{
+		config:      config,
+		ec:          ec,
+		outputs:     outputs,
+		manifest:    manifest,
+		wantResults: outputs.Selected("json") || outputs.Selected("ndjson"),
+	}
+	if config.Dump {
+		var err error
+		if config.Resume {
+			w.dump, err = resumeDumpWriter(config, checkpoint.Outputs)
+		} else {
+			w.dump, err = newDumpWriter(config)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if checkpoint != nil {
+		dumpTSV, _ := w.dump.(*tsvStructureWriter)
+		w.checkpoint = newCheckpointer(config, checkpoint, outputs, dumpTSV)
+	}
+	return w, nil
+}
+
+// write writes the outputs of the gene of job, or records it as skipped when it timed out
+func (w *geneWriter) write(job *geneJob) error {
+	config, outputs := w.config, w.outputs
+	gene, model, wp := job.gene, &job.model, job.wp
+	if job.timedOut {
+		log.Printf("WARN: skipped %s, which did not finish within --gene-timeout %v", gene.GeneName, config.GeneTimeout)
+		w.manifest.addTimedOut(gene, job.started, job.threads)
+		w.skipped++
+		return w.finished()
+	}
+	if w.genes == 0 {
+		w.manifest.setModel(config, model, wp)
+		outputs.SetProvenance(provenanceComment(config, model))
+	}
+	w.genes++
+
+	if config.G4Bonus != nil {
+		if err := outputs.Write("g4", func(f *os.File) error { return writeG4Bed(f, gene, job.motifs) }); err != nil {
+			return err
+		}
+	}
+
+	probabilities := job.probabilities
+	if w.dump != nil {
+		// structures are streamed in input order, so the dump runs here rather than beside the other genes.
+		// with the enumerate engine computeGene leaves the probabilities to this pass over the structures
+		dumpEC, err := w.ec.Reserve(w.ec.NumThreads)
+		if err != nil {
+			return err
+		}
+		dumped, err := dumpGene(w.dump, gene, dumpEC, model, wp)
+		dumpEC.Release()
+		if err != nil {
+			return err
+		}
+		if probabilities == nil {
+			probabilities = dumped
+		}
+	}
+
+	if job.sampled != nil {
+		printSamplingSummary(gene, job.sampled)
+		if err := outputs.Write("sampled", func(f *os.File) error { return writeSampledProbabilities(f, gene, job.sampled) }); err != nil {
+			return err
+		}
+	}
+	if job.multiLoop != nil {
+		printMultiLoopSummary(gene, job.multiLoop)
+		if err := outputs.Write("multiloop", func(f *os.File) error { return writeMultiLoopComparison(f, gene, job.multiLoop) }); err != nil {
+			return err
+		}
+	}
+
+	if err := writeTrack(config, gene, outputs, "bpprob", probabilities); err != nil {
+		return fmt.Errorf("error writing base pair probability track: %v", err)
+	}
+	if err := outputs.Write("bedgraph", func(f *os.File) error { return writeBedGraph(f, gene, probabilities) }); err != nil {
+		return fmt.Errorf("error writing to base pair prob bedgraph: %v", err)
+	}
+	if err := outputs.Write("bpprob-bed", func(f *os.File) error {
+		return writeBedPeaks(f, config, gene, "bpprob", probabilityPeaks(probabilities, config.PeakThreshold))
+	}); err != nil {
+		return fmt.Errorf("error writing to base pair prob bed: %v", err)
+	}
+
+	if energies := job.energies; energies != nil {
+		if err := writeTrack(config, gene, outputs, "avgG", energies.AverageEnergy); err != nil {
+			return fmt.Errorf("error writing average energy track: %v", err)
+		}
+		if err := writeTrack(config, gene, outputs, "mfe", energies.MinFreeEnergy); err != nil {
+			return fmt.Errorf("error writing minimum free energy track: %v", err)
+		}
+		if err := outputs.Write("mfe-bed", func(f *os.File) error {
+			return writeBedPeaks(f, config, gene, "mfe", energyPeaks(energies.MinFreeEnergy, config.MfeMargin))
+		}); err != nil {
+			return fmt.Errorf("error writing to min free energy bed: %v", err)
+		}
+	}
+
+	if err := outputs.Write("parquet", func(f *os.File) error {
+		if w.parquet == nil {
+			w.parquet = newParquetTrackWriter(f)
+		}
+		return w.parquet.WriteGene(gene, probabilities, job.energies)
+	}); err != nil {
+		return fmt.Errorf("error writing parquet tracks: %v", err)
+	}
+	if w.wantResults {
+		result := newGeneResult(config, gene, model, wp, probabilities, job.energies, job.top, job.z)
+		if outputs.Selected("json") {
+			w.results = append(w.results, result)
+		}
+		if err := outputs.Write("ndjson", func(f *os.File) error { return writeResultNDJSON(f, []GeneResult{result}) }); err != nil {
+			return fmt.Errorf("error writing NDJSON results: %v", err)
+		}
+	}
+
+	w.manifest.addGene(gene, job.started, job.threads)
+	return w.finished()
+}
+
+// finished records the last gene of the manifest as finished in the checkpoint
+func (w *geneWriter) finished() error {
+	if w.checkpoint == nil {
+		return nil
+	}
+	return w.checkpoint.finished(w.manifest.Genes[len(w.manifest.Genes)-1])
+}
+
+// close writes the outputs that are written whole once every gene is in and closes the dump. the other
+// outputs are left to FileOps.Close.
+func (w *geneWriter) close() error {
+	if w.parquet != nil {
+		if err := w.parquet.Close(); err != nil {
+			return fmt.Errorf("error writing parquet tracks: %v", err)
+		}
+	}
+	if w.results != nil {
+		if err := w.outputs.Write("json", func(f *os.File) error { return writeResultJSON(f, w.results) }); err != nil {
+			return fmt.Errorf("error writing JSON results: %v", err)
+		}
+	}
+	if w.dump != nil {
+		err := w.dump.Close()
+		w.dump = nil
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keep closes the outputs of a run that stopped, leaving them under their partial names for --resume, and
+// reports whether it could. it does nothing unless the checkpoint describing them is on disk.
+func (w *geneWriter) keep() bool {
+	if w.checkpoint == nil || !w.checkpoint.stopped() {
+		return false
+	}
+	var dumpErr error
+	if dumpTSV, ok := w.dump.(*tsvStructureWriter); ok {
+		dumpErr = dumpTSV.Keep()
+		w.dump = nil
+	}
+	err := w.outputs.Keep()
+	if err == nil {
+		err = dumpErr
+	}
+	if err != nil {
+		log.Printf("WARN: error keeping partial outputs: %v", err)
+		return false
+	}
+	return true
+}
+
+// abort removes the structure dump unless close or keep has already closed it
+func (w *geneWriter) abort() {
+	if w.dump != nil {
+		w.dump.Abort()
+		w.dump = nil
+	}
+}
@@ -0,0 +1,121 @@
+package sim
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golooper/config"
+	"golooper/rlooper"
+)
+
+func TestGeneWriter(t *testing.T) {
+	testConfig := &config.Config{OutfileName: filepath.Join(t.TempDir(), "test_output"), Outputs: "bpprob,ndjson", WigSpan: 1}
+	outputs, err := NewFileOps(testConfig)
+	if err != nil {
+		t.Fatalf("Failed to set up output files: %v", err)
+	}
+	manifest := newRunManifest(testConfig, 1)
+	checkpoint := &Checkpoint{Schema: CheckpointSchema, SchemaVersion: CheckpointSchemaVersion, Genes: []GeneTiming{}, Outputs: map[string]int64{}}
+	writer, err := newGeneWriter(testConfig, newExecutionContext(context.Background(), testConfig), outputs, manifest, checkpoint)
+	if err != nil {
+		t.Fatalf("newGeneWriter returned error: %v", err)
+	}
+	defer writer.abort()
+	if !writer.wantResults {
+		t.Error("expected the ndjson output to ask for results")
+	}
+
+	gene := &rlooper.Gene{GeneName: "first", Pos: rlooper.Loci{Chromosome: "chr1", Strand: "+", StartPos: 1}, Sequence: []rune("GATTACA")}
+	slow := &rlooper.Gene{GeneName: "slow", Pos: rlooper.Loci{Chromosome: "chr2", Strand: "+", StartPos: 1}, Sequence: []rune("GGCC")}
+	jobs := []*geneJob{
+		{gene: gene, model: rlooper.NewParamsReasonableDefaults(), wp: rlooper.WindowParams{MinLength: 2}, probabilities: []float64{0, 0.5, 0.5, 0, 0, 0, 0}},
+		{gene: slow, timedOut: true},
+	}
+	for _, job := range jobs {
+		if err := writer.write(job); err != nil {
+			t.Fatalf("write returned error: %v", err)
+		}
+	}
+	if writer.genes != 1 || writer.skipped != 1 {
+		t.Errorf("expected 1 gene written and 1 skipped, got %d and %d", writer.genes, writer.skipped)
+	}
+	if len(manifest.Genes) != 2 || !manifest.Genes[1].TimedOut {
+		t.Errorf("expected both genes in the manifest, the second timed out, got %+v", manifest.Genes)
+	}
+	if len(checkpoint.Genes) != 2 || checkpoint.Genes[0].Name != "first" {
+		t.Errorf("expected both genes recorded as finished in the checkpoint, got %+v", checkpoint.Genes)
+	}
+
+	if err := writer.close(); err != nil {
+		t.Fatalf("close returned error: %v", err)
+	}
+	if err := outputs.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	wig, err := os.ReadFile(testConfig.OutfileName + "_bpprob.wig")
+	if err != nil {
+		t.Fatalf("Failed to read track: %v", err)
+	}
+	if !strings.Contains(string(wig), "chrom=chr1") || strings.Contains(string(wig), "chr2") {
+		t.Errorf("expected a track for the written gene only, got %q", wig)
+	}
+	results, err := os.ReadFile(testConfig.OutfileName + "_results.ndjson")
+	if err != nil {
+		t.Fatalf("Failed to read results: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(results)), "\n"); len(lines) != 1 {
+		t.Errorf("expected one result line, got %d", len(lines))
+	}
+}
+
+func TestGeneWriterKeep(t *testing.T) {
+	testConfig := &config.Config{OutfileName: filepath.Join(t.TempDir(), "test_output"), Outputs: "bpprob"}
+	outputs, err := NewFileOps(testConfig)
+	if err != nil {
+		t.Fatalf("Failed to set up output files: %v", err)
+	}
+	defer outputs.Abort()
+	writer, err := newGeneWriter(testConfig, newExecutionContext(context.Background(), testConfig), outputs, newRunManifest(testConfig, 1), nil)
+	if err != nil {
+		t.Fatalf("newGeneWriter returned error: %v", err)
+	}
+	// without a checkpoint nothing describes the outputs, so a stopped run cannot keep them
+	if writer.keep() {
+		t.Error("expected keep to refuse outputs without a checkpoint")
+	}
+}
+
+func TestSimulationADumpProbabilities(t *testing.T) {
+	// with --dump the probabilities come from the pass that writes the structures, and must be those of a
+	// run without it
+	dir := t.TempDir()
+	input := writeTestFasta(t, dir)
+	plain := &config.Config{InfileName: input, OutfileName: filepath.Join(dir, "plain", "run"), Outputs: "bpprob", Threads: 2}
+	dumped := &config.Config{InfileName: input, OutfileName: filepath.Join(dir, "dumped", "run"), Outputs: "bpprob", Threads: 2, Dump: true}
+	for _, cfg := range []*config.Config{plain, dumped} {
+		if err := SimulationA(context.Background(), cfg); err != nil {
+			t.Fatalf("SimulationA returned error: %v", err)
+		}
+	}
+	want, err := os.ReadFile(plain.OutfileName + "_bpprob.wig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(dumped.OutfileName + "_bpprob.wig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("expected the same track with --dump, got:\n%s\nwant:\n%s", got, want)
+	}
+	structures, err := os.ReadFile(dumped.OutfileName + "_structures.tsv")
+	if err != nil {
+		t.Fatalf("structure dump was not written: %v", err)
+	}
+	if strings.Count(string(structures), "\n") < 2 {
+		t.Errorf("expected structures in the dump, got:\n%s", structures)
+	}
+}
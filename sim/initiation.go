@@ -0,0 +1,83 @@
+package sim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golooper/config"
+	"golooper/rlooper"
+)
+
+// tssIndex returns the sequence position of the transcription start site. sequences are read in genomic
+// orientation, so a minus strand gene starts at the right hand end, inside its 5' pad.
+func tssIndex(gene *rlooper.Gene) int {
+	if gene.Pos.Strand == "-" {
+		return len(gene.Sequence) - 1 - gene.HeaderFields.FivePad
+	}
+	return gene.HeaderFields.FivePad
+}
+
+// parseInitiationZone parses a zone written as "tss:K", the TSS plus or minus K bases
+func parseInitiationZone(s string, gene *rlooper.Gene) (rlooper.Zone, error) {
+	k, err := parseInitiationRadius(s)
+	if err != nil {
+		return rlooper.Zone{}, err
+	}
+	tss := tssIndex(gene)
+	return rlooper.Zone{Start: tss - k, End: tss + k}, nil
+}
+
+// parseInitiationRadius returns K of an initiation zone written tss:K
+func parseInitiationRadius(s string) (int, error) {
+	fields := strings.Split(s, ":")
+	if len(fields) != 2 || fields[0] != "tss" {
+		return 0, fmt.Errorf("invalid initiation zone %q (expected tss:K)", s)
+	}
+	k, err := strconv.Atoi(fields[1])
+	if err != nil || k < 0 {
+		return 0, fmt.Errorf("invalid initiation zone %q: K must be a non-negative integer", s)
+	}
+	return k, nil
+}
+
+// checkInitiationZone returns an error for an --initiation-zone that does not parse
+func checkInitiationZone(config *config.Config) error {
+	if config.InitiationZone == "" {
+		return nil
+	}
+	_, err := parseInitiationRadius(config.InitiationZone)
+	return err
+}
+
+// readInitiationSites reads the sites of an initiation BED file, indexed for initiationZones
+func readInitiationSites(path string) (trackIndex, error) {
+	records, err := readBedRecords(path, false)
+	if err != nil {
+		return nil, fmt.Errorf("error reading initiation sites: %v", err)
+	}
+	return newTrackIndex(records), nil
+}
+
+// initiationZones returns the zones where loops may initiate on gene, combining the TSS zone and the sites
+// read from the initiation BED file, which sites is nil without. it returns nil when initiation is
+// unconstrained.
+func initiationZones(config *config.Config, sites trackIndex, gene *rlooper.Gene) ([]rlooper.Zone, error) {
+	if config.InitiationZone == "" && sites == nil {
+		return nil, nil
+	}
+	zones := []rlooper.Zone{}
+	if config.InitiationZone != "" {
+		zone, err := parseInitiationZone(config.InitiationZone, gene)
+		if err != nil {
+			return nil, err
+		}
+		zones = append(zones, zone)
+	}
+	for _, record := range sites.overlapping(gene) {
+		if zone, ok := sequenceZone(gene, record); ok {
+			zones = append(zones, zone)
+		}
+	}
+	return zones, nil
+}
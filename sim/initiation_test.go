@@ -0,0 +1,56 @@
+package sim
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golooper/config"
+	"golooper/rlooper"
+)
+
+func TestInitiationZones(t *testing.T) {
+	gene := &rlooper.Gene{
+		GeneName:     "test",
+		HeaderFields: rlooper.FastaHeader{FivePad: 3},
+		Pos:          rlooper.Loci{Chromosome: "chr1", Strand: "+", StartPos: 101, EndPos: 120},
+		Sequence:     []rune("GATTACAGATTACAGATTAC"),
+	}
+
+	bedPath := filepath.Join(t.TempDir(), "sites.bed")
+	bed := "track name=sites\nchr1\t105\t107\nchr2\t105\t107\nchr1\t118\t130\nchr1\t0\t50\n"
+	if err := os.WriteFile(bedPath, []byte(bed), 0644); err != nil {
+		t.Fatalf("Failed to write BED file: %v", err)
+	}
+
+	sites, err := readInitiationSites(bedPath)
+	if err != nil {
+		t.Fatalf("readInitiationSites returned error: %v", err)
+	}
+	zones, err := initiationZones(&config.Config{InitiationZone: "tss:2"}, sites, gene)
+	if err != nil {
+		t.Fatalf("initiationZones returned error: %v", err)
+	}
+	expected := []rlooper.Zone{{Start: 1, End: 5}, {Start: 5, End: 6}, {Start: 18, End: 29}}
+	if !reflect.DeepEqual(zones, expected) {
+		t.Errorf("initiationZones = %v, want %v", zones, expected)
+	}
+
+	// a minus strand gene starts at the right hand end of the sequence
+	gene.Pos.Strand = "-"
+	zones, err = initiationZones(&config.Config{InitiationZone: "tss:0"}, nil, gene)
+	if err != nil {
+		t.Fatalf("initiationZones returned error: %v", err)
+	}
+	if !reflect.DeepEqual(zones, []rlooper.Zone{{Start: 16, End: 16}}) {
+		t.Errorf("minus strand TSS zone = %v, want [{16 16}]", zones)
+	}
+
+	if zones, _ := initiationZones(&config.Config{}, nil, gene); zones != nil {
+		t.Errorf("expected no zones when initiation is unconstrained, got %v", zones)
+	}
+	if _, err := initiationZones(&config.Config{InitiationZone: "tes:5"}, nil, gene); err == nil {
+		t.Errorf("expected error for invalid initiation zone")
+	}
+}
@@ -0,0 +1,141 @@
+package sim
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golooper/rlooper"
+)
+
+// bedRecord is one interval of a BED or bedGraph file, in 0-based half-open genomic coordinates
+type bedRecord struct {
+	chrom string
+	start int64
+	end   int64
+	value float64 // fourth column of a bedGraph, zero for plain BED
+}
+
+// readBedRecords reads every interval from a BED or bedGraph file, skipping track, browser and comment lines.
+// when withValue is set the fourth column must hold a number.
+func readBedRecords(path string, withValue bool) ([]bedRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+	defer file.Close()
+
+	var records []bedRecord
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "track") || strings.HasPrefix(line, "browser") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 || (withValue && len(fields) < 4) {
+			return nil, fmt.Errorf("%s:%d: too few columns", path, lineNumber)
+		}
+		record := bedRecord{chrom: fields[0]}
+		if record.start, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid start: %v", path, lineNumber, err)
+		}
+		if record.end, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid end: %v", path, lineNumber, err)
+		}
+		if withValue {
+			if record.value, err = strconv.ParseFloat(fields[3], 64); err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid value: %v", path, lineNumber, err)
+			}
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	return records, nil
+}
+
+// genomicOffset returns the 0-based genomic coordinate of the first base of gene. genes without a range
+// in their header are taken to start at 0.
+func genomicOffset(gene *rlooper.Gene) int64 {
+	if gene.Pos.StartPos > 0 {
+		return gene.Pos.StartPos - 1
+	}
+	return 0
+}
+
+// sequenceZone maps a record onto gene sequence positions, returning false when it misses the gene
+func sequenceZone(gene *rlooper.Gene, record bedRecord) (rlooper.Zone, bool) {
+	if record.chrom != gene.Pos.Chromosome && record.chrom != gene.GeneName {
+		return rlooper.Zone{}, false
+	}
+	offset := genomicOffset(gene)
+	zone := rlooper.Zone{Start: int(record.start - offset), End: int(record.end-offset) - 1}
+	if zone.End < 0 || zone.Start >= len(gene.Sequence) || zone.End < zone.Start {
+		return rlooper.Zone{}, false
+	}
+	return zone, true
+}
+
+// trackIndex holds the records of a BED, bedGraph or wiggle file by chromosome, so that a run reads a
+// file once and takes the records of each gene from it
+type trackIndex map[string]*chromRecords
+
+// chromRecords are the records of one chromosome sorted by start, with maxEnd[i] the largest end of
+// records[:i+1]
+type chromRecords struct {
+	records []bedRecord
+	maxEnd  []int64
+}
+
+// newTrackIndex indexes records by chromosome. records of a chromosome that start at the same base keep
+// their order in the file.
+func newTrackIndex(records []bedRecord) trackIndex {
+	index := make(trackIndex)
+	for _, record := range records {
+		chrom := index[record.chrom]
+		if chrom == nil {
+			chrom = &chromRecords{}
+			index[record.chrom] = chrom
+		}
+		chrom.records = append(chrom.records, record)
+	}
+	for _, chrom := range index {
+		slices.SortStableFunc(chrom.records, func(a, b bedRecord) int { return cmp.Compare(a.start, b.start) })
+		chrom.maxEnd = make([]int64, len(chrom.records))
+		end := int64(math.MinInt64)
+		for i, record := range chrom.records {
+			end = max(end, record.end)
+			chrom.maxEnd[i] = end
+		}
+	}
+	return index
+}
+
+// overlapping returns the records that may overlap gene, by start, as sequenceZone matches them: on the
+// chromosome of gene or named after it
+func (index trackIndex) overlapping(gene *rlooper.Gene) []bedRecord {
+	first, last := genomicOffset(gene), genomicOffset(gene)+int64(len(gene.Sequence))
+	var records []bedRecord
+	for i, name := range []string{gene.Pos.Chromosome, gene.GeneName} {
+		chrom := index[name]
+		if chrom == nil || (i == 1 && name == gene.Pos.Chromosome) {
+			continue
+		}
+		from := sort.Search(len(chrom.records), func(j int) bool { return chrom.maxEnd[j] > first })
+		to := sort.Search(len(chrom.records), func(j int) bool { return chrom.records[j].start >= last })
+		if from < to {
+			records = append(records, chrom.records[from:to]...)
+		}
+	}
+	return records
+}
@@ -0,0 +1,234 @@
+package sim
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"golooper/config"
+	"golooper/rlooper"
+)
+
+// Version is the golooper release, reported by the version command and recorded in every run manifest
+var Version = "0.1.0"
+
+// ManifestSchema names the layout of the run manifest, versioned like ResultSchema
+const (
+	ManifestSchema        = "golooper.manifest"
+	ManifestSchemaVersion = 1
+)
+
+// BuildInfo identifies the golooper binary that produced a run
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"go_version"`
+}
+
+// buildInfo returns the version and the git commit the binary was built from, with a -dirty suffix for a
+// modified tree. the commit is "unknown" when the binary carries no VCS information, e.g. under go run.
+func buildInfo() BuildInfo {
+	info := BuildInfo{Version: Version, Commit: "unknown", GoVersion: runtime.Version()}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	modified := false
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Commit = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if modified && info.Commit != "unknown" {
+		info.Commit += "-dirty"
+	}
+	return info
+}
+
+// HostInfo describes the machine a run used
+type HostInfo struct {
+	Hostname string `json:"hostname"`
+	OS       string `json:"os"`
+	Arch     string `json:"arch"`
+	CPUs     int    `json:"cpus"`
+	Threads  int    `json:"threads"`
+}
+
+// InputFile is a file a run read, with its checksum so that a result can be matched to its inputs
+type InputFile struct {
+	Role   string `json:"role"`
+	Path   string `json:"path"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// GeneTiming records how long a gene took from loading to its last output
+type GeneTiming struct {
+	Name    string  `json:"name"`
+	Length  int     `json:"length"`
+	Seconds float64 `json:"seconds"`
+	Threads int     `json:"threads"` // threads reserved for the gene
+	// TimedOut marks a gene stopped by --gene-timeout, which has no outputs
+	TimedOut bool `json:"timed_out,omitempty"`
+}
+
+// RunManifest records everything needed to reproduce a run: the build, the command line, the effective
+// configuration and model, the energy table and checksums of the inputs
+type RunManifest struct {
+	Schema           string             `json:"schema"`
+	SchemaVersion    int                `json:"schema_version"`
+	Golooper         BuildInfo          `json:"golooper"`
+	Command          []string           `json:"command"`
+	Started          time.Time          `json:"started"`
+	Finished         time.Time          `json:"finished"`
+	Host             HostInfo           `json:"host"`
+	Config           *config.Config     `json:"config"`
+	Model            *ModelMetadata     `json:"model,omitempty"`
+	BasePairEnergies map[string]float64 `json:"base_pair_energies,omitempty"`
+	Inputs           []InputFile        `json:"inputs"`
+	Genes            []GeneTiming       `json:"genes"`
+}
+
+// newRunManifest starts the manifest of a run using threads worker threads
+func newRunManifest(config *config.Config, threads int) *RunManifest {
+	hostname, _ := os.Hostname()
+	return &RunManifest{
+		Schema:        ManifestSchema,
+		SchemaVersion: ManifestSchemaVersion,
+		Golooper:      buildInfo(),
+		Command:       os.Args,
+		Started:       time.Now(),
+		Host:          HostInfo{Hostname: hostname, OS: runtime.GOOS, Arch: runtime.GOARCH, CPUs: runtime.NumCPU(), Threads: threads},
+		Config:        config,
+		Genes:         []GeneTiming{},
+	}
+}
+
+// fileChecksum returns the size and SHA-256 of the file at path
+func fileChecksum(path string) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	n, err := io.Copy(hash, file)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// addInputs records a checksum of every input file named in config
+func (m *RunManifest) addInputs(config *config.Config) error {
+	for _, input := range []struct{ role, path string }{
+		{"sequence", config.InfileName},
+		{"initiation_bed", config.InitiationBed},
+		{"occupancy", config.OccupancyTrack},
+		{"chrom_sizes", config.ChromSizes},
+	} {
+		if input.path == "" {
+			continue
+		}
+		n, sum, err := fileChecksum(input.path)
+		if err != nil {
+			return fmt.Errorf("error computing checksum of %s: %v", input.path, err)
+		}
+		m.Inputs = append(m.Inputs, InputFile{Role: input.role, Path: input.path, Bytes: n, SHA256: sum})
+	}
+	return nil
+}
+
+// effectiveConfig returns a copy of config with every model parameter left unset filled in with the value
+// the run used, so that the manifest does not depend on the defaults of the release that wrote it
+func effectiveConfig(cfg *config.Config, model *rlooper.ModelParams, wp rlooper.WindowParams) *config.Config {
+	effective := *cfg
+	n := int(model.N)
+	sigma := model.Sigma()
+	a := model.NucleationFreeEnergy()
+	minLength := wp.MinLength
+	if !cfg.AutoDomainSize {
+		// with --N auto every gene has its own domain size, recorded with its timing
+		effective.SuperhelicityDomain = &n
+	}
+	effective.SuperhelicalDensity = &sigma
+	effective.NucleationFreeEnergy = &a
+	effective.MinRLoopLength = &minLength
+	if wp.MaxLength > 0 {
+		maxLength := wp.MaxLength
+		effective.MaxRLoopLength = &maxLength
+	}
+	if energy, ok := model.HomopolymerOverride(); ok {
+		effective.Homopolymer = &energy
+	}
+	if effective.Engine == "" {
+		effective.Engine = engineName(cfg)
+	}
+	return &effective
+}
+
+// setModel records the effective configuration and model and the energy table the model applies
+func (m *RunManifest) setModel(config *config.Config, model *rlooper.ModelParams, wp rlooper.WindowParams) {
+	m.Config = effectiveConfig(config, model, wp)
+	metadata := newModelMetadata(config, model, wp)
+	m.Model = &metadata
+	m.BasePairEnergies = model.BasePairEnergyTable()
+}
+
+// addGene records that gene finished on threads threads, having been loaded at started
+func (m *RunManifest) addGene(gene *rlooper.Gene, started time.Time, threads int) {
+	m.Genes = append(m.Genes, GeneTiming{Name: gene.GeneName, Length: len(gene.Sequence), Seconds: time.Since(started).Seconds(), Threads: threads})
+}
+
+// addTimedOut records that gene was stopped by the gene timeout, having been loaded at started
+func (m *RunManifest) addTimedOut(gene *rlooper.Gene, started time.Time, threads int) {
+	m.addGene(gene, started, threads)
+	m.Genes[len(m.Genes)-1].TimedOut = true
+}
+
+// manifestPath returns where the manifest of the run configured by config is written
+func manifestPath(config *config.Config) string {
+	return outputBasePath(config) + "_manifest.json"
+}
+
+// write finishes the manifest and writes it next to the other outputs
+func (m *RunManifest) write(config *config.Config) error {
+	m.Finished = time.Now()
+	path := manifestPath(config)
+	file, err := createFileWithDir(path)
+	if err != nil {
+		return fmt.Errorf("error creating run manifest at %s: %v", path, err)
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(m); err != nil {
+		file.Abort()
+		return fmt.Errorf("error writing run manifest: %v", err)
+	}
+	return file.Close()
+}
+
+// provenanceComment returns a comment line naming the build and the model parameters an output was
+// computed with, and the manifest that holds the rest
+func provenanceComment(config *config.Config, model *rlooper.ModelParams) string {
+	commit := buildInfo().Commit
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	n := fmt.Sprint(model.N)
+	if config.AutoDomainSize {
+		n = "auto"
+	}
+	return fmt.Sprintf("# golooper version=%s commit=%s sigma=%g N=%s a=%g T=%g engine=%s manifest=%s\n",
+		Version, commit, model.Sigma(), n, model.NucleationFreeEnergy(), model.T, engineName(config), filepath.Base(manifestPath(config)))
+}
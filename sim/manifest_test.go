@@ -0,0 +1,69 @@
+package sim
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golooper/config"
+	"golooper/rlooper"
+)
+
+func TestRunManifest(t *testing.T) {
+	dir := t.TempDir()
+	sequence := []byte(">test\nGATTACAGGGCCC\n")
+	infile := filepath.Join(dir, "test.fa")
+	if err := os.WriteFile(infile, sequence, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{InfileName: infile, OutfileName: filepath.Join(dir, "out", "run")}
+	model := rlooper.NewParamsReasonableDefaults()
+	wp := rlooper.WindowParams{MinLength: 2}
+
+	manifest := newRunManifest(cfg, 4)
+	if err := manifest.addInputs(cfg); err != nil {
+		t.Fatalf("addInputs returned error: %v", err)
+	}
+	manifest.setModel(cfg, &model, wp)
+	manifest.addGene(&rlooper.Gene{GeneName: "test", Sequence: []rune("GATTACAGGGCCC")}, manifest.Started, 2)
+	if err := manifest.write(cfg); err != nil {
+		t.Fatalf("write returned error: %v", err)
+	}
+
+	b, err := os.ReadFile(cfg.OutfileName + "_manifest.json")
+	if err != nil {
+		t.Fatalf("manifest was not written: %v", err)
+	}
+	var got RunManifest
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("manifest does not parse: %v", err)
+	}
+	if got.Schema != ManifestSchema || got.Golooper.Version != Version || got.Host.Threads != 4 {
+		t.Errorf("unexpected manifest header %s %+v %+v", got.Schema, got.Golooper, got.Host)
+	}
+	sum := sha256.Sum256(sequence)
+	if len(got.Inputs) != 1 || got.Inputs[0].SHA256 != hex.EncodeToString(sum[:]) || got.Inputs[0].Bytes != int64(len(sequence)) {
+		t.Errorf("unexpected inputs %+v", got.Inputs)
+	}
+	if got.Config.SuperhelicalDensity == nil || *got.Config.SuperhelicalDensity != model.Sigma() || got.Config.Engine != rlooper.EngineEnumerate {
+		t.Errorf("expected the defaults to be resolved in the config, got %+v", got.Config)
+	}
+	if cfg.SuperhelicalDensity != nil {
+		t.Error("resolving the defaults modified the run config")
+	}
+	if len(got.BasePairEnergies) != 16 || got.Model == nil || len(got.Genes) != 1 || got.Genes[0].Length != 13 {
+		t.Errorf("unexpected energies %v, model %v or genes %v", got.BasePairEnergies, got.Model, got.Genes)
+	}
+}
+
+func TestProvenanceComment(t *testing.T) {
+	model := rlooper.NewParamsReasonableDefaults()
+	comment := provenanceComment(&config.Config{OutfileName: "out/run"}, &model)
+	if !strings.HasPrefix(comment, "# golooper version="+Version+" commit=") || !strings.HasSuffix(comment, " engine=enumerate manifest=run_manifest.json\n") {
+		t.Errorf("unexpected provenance comment %q", comment)
+	}
+}
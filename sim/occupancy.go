@@ -0,0 +1,118 @@
+package sim
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golooper/rlooper"
+)
+
+// readWigRecords reads a fixedStep/variableStep wiggle file into records in 0-based half-open coordinates
+func readWigRecords(path string) ([]bedRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+	defer file.Close()
+
+	var records []bedRecord
+	var d *wigDeclaration
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || fields[0] == "track" || fields[0] == "browser" {
+			continue
+		}
+		if fields[0] == "fixedStep" || fields[0] == "variableStep" {
+			declaration, err := parseWigDeclaration(fields)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, lineNumber, err)
+			}
+			d = &declaration
+			continue
+		}
+		if d == nil {
+			return nil, fmt.Errorf("%s:%d: data before any fixedStep or variableStep declaration", path, lineNumber)
+		}
+
+		var position int64
+		valueField := fields[0]
+		if d.variable {
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("%s:%d: variableStep data needs a position and a value", path, lineNumber)
+			}
+			if position, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid position: %v", path, lineNumber, err)
+			}
+			valueField = fields[1]
+		} else {
+			position = d.next
+			d.next += d.step
+		}
+		value, err := strconv.ParseFloat(valueField, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid value: %v", path, lineNumber, err)
+		}
+		records = append(records, bedRecord{chrom: d.chrom, start: position - 1, end: position - 1 + d.span, value: value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	return records, nil
+}
+
+// isWigFile reports whether path holds a wiggle file rather than a bedGraph, by looking for a declaration line
+func isWigFile(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("error opening %s: %v", path, err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "fixedStep") || strings.HasPrefix(line, "variableStep") {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// readOccupancy reads a wiggle or bedGraph occupancy track, indexed for geneOccupancy
+func readOccupancy(path string) (trackIndex, error) {
+	wig, err := isWigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var records []bedRecord
+	if wig {
+		records, err = readWigRecords(path)
+	} else {
+		records, err = readBedRecords(path, true)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading occupancy track: %v", err)
+	}
+	return newTrackIndex(records), nil
+}
+
+// geneOccupancy returns one value of an occupancy track per base of gene. bases the track doesn't cover
+// are unoccupied, and where records overlap the one starting last wins.
+func geneOccupancy(track trackIndex, gene *rlooper.Gene) []float64 {
+	occupancy := make([]float64, len(gene.Sequence))
+	for _, record := range track.overlapping(gene) {
+		zone, ok := sequenceZone(gene, record)
+		if !ok {
+			continue
+		}
+		for i := max(zone.Start, 0); i <= min(zone.End, len(occupancy)-1); i++ {
+			occupancy[i] = record.value
+		}
+	}
+	return occupancy
+}
@@ -0,0 +1,70 @@
+package sim
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golooper/rlooper"
+)
+
+func TestReadOccupancy(t *testing.T) {
+	gene := &rlooper.Gene{
+		GeneName: "test",
+		Pos:      rlooper.Loci{Chromosome: "chr1", StartPos: 11},
+		Sequence: []rune("GATTACAGAT"),
+	}
+	expected := []float64{0, 0, 0.5, 0.5, 1, 0, 0, 0.25, 0.25, 0}
+
+	tracks := map[string]string{
+		"occupancy.bedGraph": "track type=bedGraph\nchr1\t12\t14\t0.5\nchr1\t14\t15\t1\nchr2\t10\t20\t9\nchr1\t17\t19\t0.25\n",
+		"fixed.wig":          "track type=wiggle_0\nfixedStep chrom=chr1 start=13 step=1\n0.5\n0.5\n1\nvariableStep chrom=chr2\n12 9\nfixedStep chrom=chr1 start=18 step=1 span=2\n0.25\n",
+		"variable.wig":       "variableStep chrom=chr1 span=2\n13 0.5\n15 1\n18 0.25\n",
+	}
+	dir := t.TempDir()
+	for name, content := range tracks {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		track, err := readOccupancy(path)
+		if err != nil {
+			t.Fatalf("readOccupancy(%s) returned error: %v", name, err)
+		}
+		occupancy := geneOccupancy(track, gene)
+		want := expected
+		if name == "variable.wig" { // span 2 at 15 also covers position 16
+			want = []float64{0, 0, 0.5, 0.5, 1, 1, 0, 0.25, 0.25, 0}
+		}
+		if !reflect.DeepEqual(occupancy, want) {
+			t.Errorf("readOccupancy(%s) = %v, want %v", name, occupancy, want)
+		}
+	}
+}
+
+func TestTrackIndexOverlapping(t *testing.T) {
+	records := []bedRecord{
+		{chrom: "chr1", start: 40, end: 45},
+		{chrom: "chr1", start: 0, end: 100}, // starts before the gene and covers all of it
+		{chrom: "chr1", start: 12, end: 14},
+		{chrom: "chr1", start: 20, end: 30},
+		{chrom: "chr2", start: 12, end: 14},
+		{chrom: "plasmid", start: 3, end: 5},
+	}
+	index := newTrackIndex(records)
+	gene := &rlooper.Gene{GeneName: "test", Pos: rlooper.Loci{Chromosome: "chr1", StartPos: 11}, Sequence: []rune("GATTACAGAT")}
+	want := []bedRecord{records[1], records[2]}
+	if got := index.overlapping(gene); !reflect.DeepEqual(got, want) {
+		t.Errorf("overlapping chr1:11-20 = %v, want %v", got, want)
+	}
+
+	// a gene without a range is matched by name, from the start of the record
+	plasmid := &rlooper.Gene{GeneName: "plasmid", Sequence: []rune("GATTACA")}
+	if got := index.overlapping(plasmid); !reflect.DeepEqual(got, []bedRecord{records[5]}) {
+		t.Errorf("overlapping plasmid = %v, want %v", got, []bedRecord{records[5]})
+	}
+	if got := index.overlapping(&rlooper.Gene{GeneName: "none", Sequence: []rune("GATTACA")}); len(got) != 0 {
+		t.Errorf("expected no records for an unknown chromosome, got %v", got)
+	}
+}
@@ -0,0 +1,358 @@
+package sim
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golooper/config"
+)
+
+// output file formats, which decide the header an output is created with
+const (
+	outputWig      = "wig"
+	outputBed      = "bed"
+	outputBedGraph = "bedgraph"
+	outputJSON     = "json"
+	outputNDJSON   = "ndjson"
+	outputParquet  = "parquet"
+	outputTSV      = "tsv"
+)
+
+// outputSpec is one file SimulationA can write, selected by name with --outputs
+type outputSpec struct {
+	name        string
+	suffix      string // appended to the output path
+	format      string
+	description string // track name, also used in error messages
+	onRequest   bool   // left out of "all", written only when named
+	// implicit outputs cannot be named in --outputs and are written whenever the settings that produce
+	// them are used
+	implicit  bool
+	bedFormat string // BED columns, overriding --bed-format
+	columns   string // header row of a TSV output
+}
+
+// outputRegistry lists every output in the order they are written and closed
+var outputRegistry = []outputSpec{
+	{name: "bpprob", suffix: "_bpprob.wig", format: outputWig, description: "Base Pair Probability"},
+	{name: "avgG", suffix: "_avgG.wig", format: outputWig, description: "Average Energy"},
+	{name: "mfe", suffix: "_mfe.wig", format: outputWig, description: "Minimum Free Energy"},
+	{name: "bpprob-bed", suffix: "_bpprob.bed", format: outputBed, description: "Base Pair Probability"},
+	{name: "mfe-bed", suffix: "_mfe.bed", format: outputBed, description: "Minimum Free Energy"},
+	{name: "bedgraph", suffix: "_bpprob.bedgraph", format: outputBedGraph, description: "Base Pair Probability"},
+	{name: "json", suffix: "_results.json", format: outputJSON, description: "Results", onRequest: true},
+	{name: "ndjson", suffix: "_results.ndjson", format: outputNDJSON, description: "Results", onRequest: true},
+	{name: "parquet", suffix: "_tracks.parquet", format: outputParquet, description: "Tracks", onRequest: true},
+	{name: "g4", suffix: "_g4.bed", format: outputBed, description: "G-quadruplexes on the displaced strand", implicit: true, bedFormat: BedFormat6},
+	{name: "sampled", suffix: "_sampled.tsv", format: outputTSV, description: "Sampled probabilities", implicit: true,
+		columns: "gene\tchromosome\tposition\tprobability\tci_low\tci_high"},
+	{name: "multiloop", suffix: "_multiloop.tsv", format: outputTSV, description: "Multi-loop comparison", implicit: true,
+		columns: "gene\tchromosome\tposition\tsingle_loop\tmulti_loop\tdelta"},
+}
+
+// lookupOutput returns the registered output called name, including implicit outputs
+func lookupOutput(name string) (outputSpec, bool) {
+	for _, spec := range outputRegistry {
+		if strings.EqualFold(spec.name, name) {
+			return spec, true
+		}
+	}
+	return outputSpec{}, false
+}
+
+// outputNames returns the names of every output that can be selected with --outputs
+func outputNames() []string {
+	var names []string
+	for _, spec := range outputRegistry {
+		if !spec.implicit {
+			names = append(names, spec.name)
+		}
+	}
+	return names
+}
+
+// parseOutputs returns the outputs selected by a comma separated list of names. "all" selects every
+// output not written only on request, and an empty list is the same as "all".
+func parseOutputs(list string) (map[string]bool, error) {
+	if strings.TrimSpace(list) == "" {
+		list = "all"
+	}
+	selected := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if strings.EqualFold(name, "all") {
+			for _, spec := range outputRegistry {
+				if !spec.onRequest && !spec.implicit {
+					selected[spec.name] = true
+				}
+			}
+			continue
+		}
+		spec, ok := lookupOutput(name)
+		if !ok || spec.implicit {
+			return nil, fmt.Errorf("unknown output %q (expected all or a list of %s)", name, strings.Join(outputNames(), ", "))
+		}
+		selected[spec.name] = true
+	}
+	return selected, nil
+}
+
+// label returns how the output is referred to in error messages, e.g. "base pair probability wig"
+func (spec outputSpec) label() string {
+	return strings.ToLower(spec.description) + " " + spec.format
+}
+
+// create creates the output file and writes its header and the run metadata. bedGraph carries neither so
+// that bedGraphToBigWig accepts it as is, and JSON and Parquet have no room for them.
+func (spec outputSpec) create(config *config.Config, provenance string) (*outputFile, error) {
+	path := outputBasePath(config) + spec.suffix
+	switch spec.format {
+	case outputWig:
+		return createOutputFile(path, func(f *os.File) error {
+			return writeWigfileHeader(f, trackAttributes(config, spec.description))
+		}, spec.label(), runMetadataComment(config)+provenance)
+	case outputBed:
+		bedFormat := config.BedFormat
+		if spec.bedFormat != "" {
+			bedFormat = spec.bedFormat
+		}
+		return createOutputFile(path, func(f *os.File) error {
+			return writeBedfileHeader(f, spec.description, bedFormat)
+		}, spec.label(), runMetadataComment(config)+provenance)
+	case outputTSV:
+		file, err := createFileWithDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("error creating %s at %s: %v", spec.label(), path, err)
+		}
+		if _, err := fmt.Fprintln(file, spec.columns); err != nil {
+			file.Abort()
+			return nil, fmt.Errorf("error writing %s header: %v", spec.label(), err)
+		}
+		return file, nil
+	default:
+		file, err := createFileWithDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("error creating %s file at %s: %v", spec.label(), path, err)
+		}
+		return file, nil
+	}
+}
+
+// checkOutputs returns an error for an --outputs list naming an unknown output
+func checkOutputs(config *config.Config) error {
+	_, err := parseOutputs(config.Outputs)
+	return err
+}
+
+// FileOps holds the output files of a run. files are created on their first write, so outputs that are
+// not selected or never written leave nothing behind.
+type FileOps struct {
+	config   *config.Config
+	selected map[string]bool
+	files    map[string]*outputFile
+	// provenance is appended to the run metadata in wig and BED headers
+	provenance string
+	// bigWigs holds the tracks of wig outputs written as bigWig, which has its index ahead of the data and
+	// so is written by Close once every gene is in
+	bigWigs map[string][]bigWigTrack
+	// bigWigSpans holds the ranges the tracks of each bigWig output cover on each chromosome, sorted by
+	// start, keyed by output name and chromosome
+	bigWigSpans map[string][][2]int64
+}
+
+// NewFileOps returns the outputs selected by config.Outputs, without creating any files
+func NewFileOps(config *config.Config) (*FileOps, error) {
+	selected, err := parseOutputs(config.Outputs)
+	if err != nil {
+		return nil, err
+	}
+	return &FileOps{config: config, selected: selected, files: make(map[string]*outputFile), bigWigs: make(map[string][]bigWigTrack), bigWigSpans: make(map[string][][2]int64)}, nil
+}
+
+// SetProvenance sets the comment written below the run metadata of files created from now on
+func (f *FileOps) SetProvenance(comment string) {
+	f.provenance = comment
+}
+
+// Selected reports whether the output called name is to be written. implicit outputs are always written
+// when asked for.
+func (f *FileOps) Selected(name string) bool {
+	spec, ok := lookupOutput(name)
+	return ok && (spec.implicit || f.selected[spec.name])
+}
+
+// File returns the file of the output called name, creating it with its header on first use
+func (f *FileOps) File(name string) (*os.File, error) {
+	spec, ok := lookupOutput(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown output %q", name)
+	}
+	if file, ok := f.files[spec.name]; ok {
+		return file.File, nil
+	}
+	if !f.Selected(spec.name) {
+		return nil, fmt.Errorf("output %s was not selected", spec.name)
+	}
+	file, err := spec.create(f.config, f.provenance)
+	if err != nil {
+		return nil, err
+	}
+	f.files[spec.name] = file
+	return file.File, nil
+}
+
+// Write calls write with the file of the output called name, and does nothing if it was not selected
+func (f *FileOps) Write(name string, write func(*os.File) error) error {
+	if !f.Selected(name) {
+		return nil
+	}
+	file, err := f.File(name)
+	if err != nil {
+		return err
+	}
+	return write(file)
+}
+
+// addBigWigTrack adds a track to the bigWig written in place of the wig output called name. a bigWig cannot
+// hold overlapping values, so a track overlapping one added before is refused as soon as it is added
+// rather than when the outputs are closed.
+func (f *FileOps) addBigWigTrack(name string, track bigWigTrack) error {
+	key := name + "\t" + track.chrom
+	spans := f.bigWigSpans[key]
+	span := [2]int64{track.offset, track.offset + int64(len(track.values))}
+	i, _ := slices.BinarySearchFunc(spans, span, func(a, b [2]int64) int { return cmp.Compare(a[0], b[0]) })
+	for _, j := range []int{i - 1, i} {
+		if j >= 0 && j < len(spans) && span[0] < spans[j][1] && spans[j][0] < span[1] {
+			return fmt.Errorf("%s:%d-%d overlaps the track of an earlier record at %s:%d-%d, and bigWig tracks cannot overlap; write overlapping records with --format wig",
+				track.chrom, span[0], span[1], track.chrom, spans[j][0], spans[j][1])
+		}
+	}
+	f.bigWigSpans[key] = slices.Insert(spans, i, span)
+	f.bigWigs[name] = append(f.bigWigs[name], track)
+	return nil
+}
+
+// bigWigPath returns where the wig output spec is written as bigWig, with the wig suffix swapped for .bw
+func bigWigPath(config *config.Config, spec outputSpec) string {
+	return outputBasePath(config) + strings.TrimSuffix(spec.suffix, ".wig") + ".bw"
+}
+
+// Close writes the bigWig outputs, flushes and closes every file that was created and only then moves them
+// into place, so that an error in any output leaves none of them behind
+func (f *FileOps) Close() error {
+	for _, spec := range outputRegistry {
+		tracks, ok := f.bigWigs[spec.name]
+		if !ok {
+			continue
+		}
+		delete(f.bigWigs, spec.name)
+		path := bigWigPath(f.config, spec)
+		file, err := createFileWithDir(path)
+		if err != nil {
+			f.Abort()
+			return fmt.Errorf("error creating %s bigWig at %s: %v", strings.ToLower(spec.description), path, err)
+		}
+		f.files[spec.name] = file
+		if err := writeBigWigTracks(file, f.config.ChromSizes, tracks); err != nil {
+			f.Abort()
+			return fmt.Errorf("error writing %s bigWig: %v", strings.ToLower(spec.description), err)
+		}
+	}
+	for _, spec := range outputRegistry {
+		if file, ok := f.files[spec.name]; ok {
+			if err := file.finish(); err != nil {
+				f.Abort()
+				return fmt.Errorf("error closing %s: %v", spec.label(), err)
+			}
+		}
+	}
+	var committed []*outputFile
+	for _, spec := range outputRegistry {
+		file, ok := f.files[spec.name]
+		if !ok {
+			continue
+		}
+		if err := file.commit(); err != nil {
+			for _, done := range committed {
+				os.Remove(done.path)
+			}
+			f.Abort()
+			return fmt.Errorf("error moving %s into place: %v", spec.label(), err)
+		}
+		committed = append(committed, file)
+	}
+	clear(f.files)
+	return nil
+}
+
+// Keep closes every file that was created, leaving them under their partial names for --resume, and drops
+// the bigWig tracks
+func (f *FileOps) Keep() error {
+	var errs []error
+	for _, spec := range outputRegistry {
+		if file, ok := f.files[spec.name]; ok {
+			if err := file.Keep(); err != nil {
+				errs = append(errs, fmt.Errorf("error closing %s: %v", spec.label(), err))
+			}
+			delete(f.files, spec.name)
+		}
+	}
+	clear(f.bigWigs)
+	clear(f.bigWigSpans)
+	if len(errs) > 0 {
+		return fmt.Errorf("errors closing files: %v", errs)
+	}
+	return nil
+}
+
+// sizes flushes every file that was created to disk and returns the bytes written to each, by file name
+func (f *FileOps) sizes() (map[string]int64, error) {
+	sizes := make(map[string]int64, len(f.files))
+	for _, spec := range outputRegistry {
+		file, ok := f.files[spec.name]
+		if !ok {
+			continue
+		}
+		size, err := file.syncedSize()
+		if err != nil {
+			return nil, fmt.Errorf("error flushing %s: %v", spec.label(), err)
+		}
+		sizes[filepath.Base(file.path)] = size
+	}
+	return sizes, nil
+}
+
+// resume reopens the files an interrupted run left, cut back to the sizes its checkpoint recorded, so that
+// writes append to them rather than starting new files
+func (f *FileOps) resume(sizes map[string]int64) error {
+	for _, spec := range outputRegistry {
+		path := outputBasePath(f.config) + spec.suffix
+		size, ok := sizes[filepath.Base(path)]
+		if !ok {
+			continue
+		}
+		file, err := resumeOutputFile(path, size)
+		if err != nil {
+			// leave the files already reopened as they were found
+			f.Keep()
+			return err
+		}
+		f.files[spec.name] = file
+	}
+	return nil
+}
+
+// Abort removes every file that was created and drops the bigWig tracks, so that a run that failed or was
+// interrupted leaves no outputs that look complete. outputs already closed are kept.
+func (f *FileOps) Abort() {
+	for name, file := range f.files {
+		file.Abort()
+		delete(f.files, name)
+	}
+	clear(f.bigWigs)
+	clear(f.bigWigSpans)
+}
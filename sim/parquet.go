@@ -0,0 +1,617 @@
+package sim
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+
+	"golooper/rlooper"
+)
+
+// Parquet constants, see parquet.thrift in the format specification
+const (
+	parquetMagic = "PAR1"
+
+	// physical types
+	parquetInt32     int32 = 1
+	parquetInt64     int32 = 2
+	parquetDouble    int32 = 5
+	parquetByteArray int32 = 6
+
+	// repetition types
+	parquetRequired int32 = 0
+	parquetOptional int32 = 1
+
+	// encodings
+	parquetPlain          int32 = 0
+	parquetRLE            int32 = 3
+	parquetRLEDictionary  int32 = 8
+	parquetConvertedUTF8  int32 = 0
+	parquetCodecGzip      int32 = 2
+	parquetDataPage       int32 = 0
+	parquetDictionaryPage int32 = 2
+
+	// parquetRowGroupRows caps the rows buffered before a row group is written, so that a gene with
+	// millions of structures is split into several row groups rather than held in memory whole
+	parquetRowGroupRows = 1 << 20
+)
+
+// thrift compact protocol field types
+const (
+	thriftI32    byte = 5
+	thriftI64    byte = 6
+	thriftBinary byte = 8
+	thriftList   byte = 9
+	thriftStruct byte = 12
+)
+
+// thriftWriter encodes the thrift compact protocol, which Parquet uses for its page headers and footer
+type thriftWriter struct {
+	buf    []byte
+	field  int16
+	fields []int16 // last field id of each enclosing struct
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	t.buf = binary.AppendUvarint(t.buf, v)
+}
+
+func (t *thriftWriter) fieldHeader(id int16, kind byte) {
+	if delta := id - t.field; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|kind)
+	} else {
+		t.buf = append(t.buf, kind)
+		t.varint(uint64(uint16((id << 1) ^ (id >> 15))))
+	}
+	t.field = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.varint(uint64(uint32((v << 1) ^ (v >> 31))))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftWriter) binary(id int16, b string) {
+	t.fieldHeader(id, thriftBinary)
+	t.varint(uint64(len(b)))
+	t.buf = append(t.buf, b...)
+}
+
+// list starts a list field of n elements of the given type
+func (t *thriftWriter) list(id int16, kind byte, n int) {
+	t.fieldHeader(id, thriftList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|kind)
+	} else {
+		t.buf = append(t.buf, 0xf0|kind)
+		t.varint(uint64(n))
+	}
+}
+
+// begin starts a struct, either a field when id is positive or a list element
+func (t *thriftWriter) begin(id int16) {
+	if id > 0 {
+		t.fieldHeader(id, thriftStruct)
+	}
+	t.fields = append(t.fields, t.field)
+	t.field = 0
+}
+
+func (t *thriftWriter) end() {
+	t.buf = append(t.buf, 0)
+	t.field = t.fields[len(t.fields)-1]
+	t.fields = t.fields[:len(t.fields)-1]
+}
+
+// appendHybrid appends values with the RLE/bit-packed hybrid encoding: runs of eight or more repeats, and
+// any run that reaches the end, are run length encoded and everything else is bit-packed in groups of
+// eight, the last group padded with zeros
+func appendHybrid(buf []byte, values []int32, bitWidth int) []byte {
+	byteWidth := (bitWidth + 7) / 8
+	var literals []int32
+	flush := func() {
+		if len(literals) == 0 {
+			return
+		}
+		for len(literals)%8 != 0 {
+			literals = append(literals, 0)
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(literals)/8)<<1|1)
+		var acc uint64
+		accBits := 0
+		for _, v := range literals {
+			acc |= uint64(v) << accBits
+			accBits += bitWidth
+			for accBits >= 8 {
+				buf = append(buf, byte(acc))
+				acc >>= 8
+				accBits -= 8
+			}
+		}
+		literals = literals[:0]
+	}
+	for i := 0; i < len(values); {
+		run := 1
+		for i+run < len(values) && values[i+run] == values[i] {
+			run++
+		}
+		if run >= 8 || i+run == len(values) {
+			flush()
+			buf = binary.AppendUvarint(buf, uint64(run)<<1)
+			for b := 0; b < byteWidth; b++ {
+				buf = append(buf, byte(values[i]>>(8*b)))
+			}
+			i += run
+			continue
+		}
+		end := min(i+8, len(values))
+		literals = append(literals, values[i:end]...)
+		i = end
+	}
+	flush()
+	return buf
+}
+
+// parquetColumn is a flat column of a Parquet file, buffering the values of the current row group
+type parquetColumn struct {
+	name       string
+	kind       int32
+	optional   bool
+	dictionary bool // byte arrays only; values are written as indices into a per row group dictionary
+
+	defined []int32 // definition level of each row of an optional column
+	int32s  []int32
+	int64s  []int64
+	doubles []float64
+	indices []int32
+	dict    map[string]int32
+	entries []string
+
+	chunks []parquetChunk
+}
+
+// parquetChunk is the metadata of a column's data in one row group
+type parquetChunk struct {
+	offset           int64 // of the first page
+	dictionaryOffset int64 // -1 without a dictionary page
+	dataOffset       int64
+	values           int64
+	compressedSize   int64
+	uncompressedSize int64
+}
+
+func (c *parquetColumn) define(ok bool) bool {
+	if c.optional {
+		level := int32(0)
+		if ok {
+			level = 1
+		}
+		c.defined = append(c.defined, level)
+	}
+	return ok
+}
+
+func (c *parquetColumn) appendInt32(v int32) {
+	c.define(true)
+	c.int32s = append(c.int32s, v)
+}
+
+func (c *parquetColumn) appendInt64(v int64) {
+	c.define(true)
+	c.int64s = append(c.int64s, v)
+}
+
+// appendDouble appends v, or a null in place of NaN for an optional column
+func (c *parquetColumn) appendDouble(v float64) {
+	if c.define(!c.optional || !math.IsNaN(v)) {
+		c.doubles = append(c.doubles, v)
+	}
+}
+
+func (c *parquetColumn) appendString(v string) {
+	c.define(true)
+	id, ok := c.dict[v]
+	if !ok {
+		if c.dict == nil {
+			c.dict = make(map[string]int32)
+		}
+		id = int32(len(c.entries))
+		c.dict[v] = id
+		c.entries = append(c.entries, v)
+	}
+	c.indices = append(c.indices, id)
+}
+
+// plainValues returns the plain encoding of the buffered values
+func (c *parquetColumn) plainValues() []byte {
+	var buf []byte
+	for _, v := range c.int32s {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(v))
+	}
+	for _, v := range c.int64s {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(v))
+	}
+	for _, v := range c.doubles {
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(v))
+	}
+	return buf
+}
+
+func (c *parquetColumn) reset() {
+	c.defined, c.int32s, c.int64s, c.doubles, c.indices = c.defined[:0], c.int32s[:0], c.int64s[:0], c.doubles[:0], c.indices[:0]
+	c.dict, c.entries = nil, c.entries[:0]
+}
+
+// parquetWriter writes flat Parquet files: gzip compressed v1 data pages, one per column per row group,
+// with dictionary pages for dictionary columns
+type parquetWriter struct {
+	out       *offsetWriter
+	columns   []*parquetColumn
+	metadata  [][2]string // footer key/value metadata
+	rows      int
+	rowGroups []int64 // rows in each written row group
+}
+
+func newParquetWriter(w io.Writer, columns []*parquetColumn, metadata [][2]string) *parquetWriter {
+	pw := &parquetWriter{out: &offsetWriter{w: bufio.NewWriterSize(w, 1<<20)}, columns: columns, metadata: metadata}
+	pw.out.writeBytes([]byte(parquetMagic))
+	return pw
+}
+
+// endRow records that a value was appended to every column, writing a row group when enough have built up
+func (pw *parquetWriter) endRow() error {
+	pw.rows++
+	if pw.rows >= parquetRowGroupRows {
+		return pw.flushRowGroup()
+	}
+	return pw.out.err
+}
+
+// writePage writes a page header and its gzip compressed body, returning the bytes written before and
+// after compression
+func (pw *parquetWriter) writePage(header func(t *thriftWriter, uncompressed, compressed int32), body []byte) (int64, int64, error) {
+	var compressed bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&compressed, gzip.BestSpeed)
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := zw.Write(body); err != nil {
+		return 0, 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return 0, 0, err
+	}
+	t := &thriftWriter{}
+	header(t, int32(len(body)), int32(compressed.Len()))
+	t.buf = append(t.buf, 0)
+	pw.out.writeBytes(t.buf)
+	pw.out.writeBytes(compressed.Bytes())
+	return int64(len(t.buf) + len(body)), int64(len(t.buf) + compressed.Len()), pw.out.err
+}
+
+// flushRowGroup writes the buffered rows as a row group
+func (pw *parquetWriter) flushRowGroup() error {
+	if pw.rows == 0 {
+		return pw.out.err
+	}
+	for _, c := range pw.columns {
+		chunk := parquetChunk{offset: int64(pw.out.offset), dictionaryOffset: -1, values: int64(pw.rows)}
+
+		if c.dictionary {
+			var entries []byte
+			for _, e := range c.entries {
+				entries = binary.LittleEndian.AppendUint32(entries, uint32(len(e)))
+				entries = append(entries, e...)
+			}
+			chunk.dictionaryOffset = int64(pw.out.offset)
+			uncompressed, compressed, err := pw.writePage(func(t *thriftWriter, u, z int32) {
+				t.i32(1, parquetDictionaryPage)
+				t.i32(2, u)
+				t.i32(3, z)
+				t.begin(7)
+				t.i32(1, int32(len(c.entries)))
+				t.i32(2, parquetPlain)
+				t.end()
+			}, entries)
+			if err != nil {
+				return fmt.Errorf("error writing parquet dictionary page: %v", err)
+			}
+			chunk.uncompressedSize += uncompressed
+			chunk.compressedSize += compressed
+		}
+
+		var body []byte
+		if c.optional {
+			levels := appendHybrid(nil, c.defined, 1)
+			body = binary.LittleEndian.AppendUint32(body, uint32(len(levels)))
+			body = append(body, levels...)
+		}
+		encoding := parquetPlain
+		if c.dictionary {
+			encoding = parquetRLEDictionary
+			bitWidth := max(bits.Len(uint(len(c.entries)-1)), 1)
+			body = append(body, byte(bitWidth))
+			body = appendHybrid(body, c.indices, bitWidth)
+		} else {
+			body = append(body, c.plainValues()...)
+		}
+		chunk.dataOffset = int64(pw.out.offset)
+		uncompressed, compressed, err := pw.writePage(func(t *thriftWriter, u, z int32) {
+			t.i32(1, parquetDataPage)
+			t.i32(2, u)
+			t.i32(3, z)
+			t.begin(5)
+			t.i32(1, int32(pw.rows))
+			t.i32(2, encoding)
+			t.i32(3, parquetRLE)
+			t.i32(4, parquetRLE)
+			t.end()
+		}, body)
+		if err != nil {
+			return fmt.Errorf("error writing parquet data page: %v", err)
+		}
+		chunk.uncompressedSize += uncompressed
+		chunk.compressedSize += compressed
+
+		c.chunks = append(c.chunks, chunk)
+		c.reset()
+	}
+	pw.rowGroups = append(pw.rowGroups, int64(pw.rows))
+	pw.rows = 0
+	return pw.out.err
+}
+
+// footer returns the thrift encoded FileMetaData
+func (pw *parquetWriter) footer() []byte {
+	var totalRows int64
+	for _, n := range pw.rowGroups {
+		totalRows += n
+	}
+
+	t := &thriftWriter{}
+	t.i32(1, 1)
+	t.list(2, thriftStruct, len(pw.columns)+1)
+	t.begin(0)
+	t.binary(4, "schema")
+	t.i32(5, int32(len(pw.columns)))
+	t.end()
+	for _, c := range pw.columns {
+		t.begin(0)
+		t.i32(1, c.kind)
+		if c.optional {
+			t.i32(3, parquetOptional)
+		} else {
+			t.i32(3, parquetRequired)
+		}
+		t.binary(4, c.name)
+		if c.kind == parquetByteArray {
+			t.i32(6, parquetConvertedUTF8)
+			t.begin(10)
+			t.begin(1) // StringType
+			t.end()
+			t.end()
+		}
+		t.end()
+	}
+	t.i64(3, totalRows)
+
+	t.list(4, thriftStruct, len(pw.rowGroups))
+	for g, rows := range pw.rowGroups {
+		var size, compressedSize int64
+		t.begin(0)
+		t.list(1, thriftStruct, len(pw.columns))
+		for _, c := range pw.columns {
+			chunk := c.chunks[g]
+			size += chunk.uncompressedSize
+			compressedSize += chunk.compressedSize
+			t.begin(0)
+			t.i64(2, chunk.offset)
+			t.begin(3)
+			t.i32(1, c.kind)
+			encodings := []int32{parquetPlain, parquetRLE}
+			if c.dictionary {
+				encodings = append(encodings, parquetRLEDictionary)
+			}
+			t.list(2, thriftI32, len(encodings))
+			for _, e := range encodings {
+				t.varint(uint64(uint32((e << 1) ^ (e >> 31))))
+			}
+			t.list(3, thriftBinary, 1)
+			t.varint(uint64(len(c.name)))
+			t.buf = append(t.buf, c.name...)
+			t.i32(4, parquetCodecGzip)
+			t.i64(5, chunk.values)
+			t.i64(6, chunk.uncompressedSize)
+			t.i64(7, chunk.compressedSize)
+			t.i64(9, chunk.dataOffset)
+			if chunk.dictionaryOffset >= 0 {
+				t.i64(11, chunk.dictionaryOffset)
+			}
+			t.end()
+			t.end()
+		}
+		t.i64(2, size)
+		t.i64(3, rows)
+		t.i64(5, pw.columns[0].chunks[g].offset)
+		t.i64(6, compressedSize)
+		t.end()
+	}
+
+	if len(pw.metadata) > 0 {
+		t.list(5, thriftStruct, len(pw.metadata))
+		for _, kv := range pw.metadata {
+			t.begin(0)
+			t.binary(1, kv[0])
+			t.binary(2, kv[1])
+			t.end()
+		}
+	}
+	t.binary(6, "golooper")
+	t.buf = append(t.buf, 0)
+	return t.buf
+}
+
+// Close writes any buffered rows and the footer. it does not close the underlying writer.
+func (pw *parquetWriter) Close() error {
+	if err := pw.flushRowGroup(); err != nil {
+		return err
+	}
+	footer := pw.footer()
+	pw.out.writeBytes(footer)
+	pw.out.writeBytes(binary.LittleEndian.AppendUint32(nil, uint32(len(footer))))
+	pw.out.writeBytes([]byte(parquetMagic))
+	if pw.out.err != nil {
+		return fmt.Errorf("error writing parquet footer: %v", pw.out.err)
+	}
+	return pw.out.w.Flush()
+}
+
+// footer metadata naming the layout of the Parquet outputs, versioned like ResultSchema
+const (
+	parquetSchemaKey        = "golooper.schema"
+	parquetSchemaVersionKey = "golooper.schema_version"
+	structureParquetSchema  = "golooper.structures"
+	trackParquetSchema      = "golooper.tracks"
+)
+
+func parquetSchemaMetadata(schema string) [][2]string {
+	return [][2]string{{parquetSchemaKey, schema}, {parquetSchemaVersionKey, fmt.Sprint(ResultSchemaVersion)}}
+}
+
+// parquetStructureWriter writes a structure dump as Parquet, starting a new row group for each gene
+type parquetStructureWriter struct {
+	file *outputFile
+	pw   *parquetWriter
+	gene string
+
+	geneColumn, chromosome, start, end, length, freeEnergy, boltzmannFactor, probability *parquetColumn
+}
+
+func newParquetStructureWriter(file *outputFile) *parquetStructureWriter {
+	w := &parquetStructureWriter{
+		file:            file,
+		geneColumn:      &parquetColumn{name: "gene", kind: parquetByteArray, dictionary: true},
+		chromosome:      &parquetColumn{name: "chromosome", kind: parquetByteArray, dictionary: true},
+		start:           &parquetColumn{name: "start", kind: parquetInt64},
+		end:             &parquetColumn{name: "end", kind: parquetInt64},
+		length:          &parquetColumn{name: "length", kind: parquetInt32},
+		freeEnergy:      &parquetColumn{name: "free_energy", kind: parquetDouble},
+		boltzmannFactor: &parquetColumn{name: "boltzmann_factor", kind: parquetDouble},
+		probability:     &parquetColumn{name: "probability", kind: parquetDouble},
+	}
+	columns := []*parquetColumn{w.geneColumn, w.chromosome, w.start, w.end, w.length, w.freeEnergy, w.boltzmannFactor, w.probability}
+	w.pw = newParquetWriter(file, columns, parquetSchemaMetadata(structureParquetSchema))
+	return w
+}
+
+// StartGene ends the current row group so that no row group mixes genes, and names the gene of the
+// structures that follow
+func (w *parquetStructureWriter) StartGene(name string) error {
+	w.gene = name
+	if err := w.pw.flushRowGroup(); err != nil {
+		return fmt.Errorf("error writing structure dump: %v", err)
+	}
+	return nil
+}
+
+func (w *parquetStructureWriter) WriteStructures(structures []rlooper.Structure) error {
+	for _, s := range structures {
+		w.geneColumn.appendString(w.gene)
+		w.chromosome.appendString(s.Pos.Chromosome)
+		w.start.appendInt64(s.Pos.StartPos)
+		w.end.appendInt64(s.Pos.EndPos)
+		w.length.appendInt32(int32(s.Length))
+		w.freeEnergy.appendDouble(s.FreeEnergy)
+		w.boltzmannFactor.appendDouble(s.BoltzmannFactor)
+		w.probability.appendDouble(s.Probability)
+		if err := w.pw.endRow(); err != nil {
+			return fmt.Errorf("error writing structure dump: %v", err)
+		}
+	}
+	return nil
+}
+
+func (w *parquetStructureWriter) Abort() error {
+	return w.file.Abort()
+}
+
+func (w *parquetStructureWriter) Close() error {
+	if err := w.pw.Close(); err != nil {
+		w.file.Abort()
+		return fmt.Errorf("error flushing structure dump: %v", err)
+	}
+	return w.file.Close()
+}
+
+// parquetTrackWriter writes the per-base tracks of genes as Parquet, one row group per gene, with a row per
+// base at its 0-based genomic position
+type parquetTrackWriter struct {
+	pw *parquetWriter
+
+	geneColumn, chromosome, position, bpProbability, averageEnergy, minFreeEnergy *parquetColumn
+}
+
+func newParquetTrackWriter(w io.Writer) *parquetTrackWriter {
+	t := &parquetTrackWriter{
+		geneColumn:    &parquetColumn{name: "gene", kind: parquetByteArray, dictionary: true},
+		chromosome:    &parquetColumn{name: "chromosome", kind: parquetByteArray, dictionary: true},
+		position:      &parquetColumn{name: "position", kind: parquetInt64},
+		bpProbability: &parquetColumn{name: "bp_probability", kind: parquetDouble, optional: true},
+		averageEnergy: &parquetColumn{name: "average_energy", kind: parquetDouble, optional: true},
+		minFreeEnergy: &parquetColumn{name: "min_free_energy", kind: parquetDouble, optional: true},
+	}
+	columns := []*parquetColumn{t.geneColumn, t.chromosome, t.position, t.bpProbability, t.averageEnergy, t.minFreeEnergy}
+	t.pw = newParquetWriter(w, columns, parquetSchemaMetadata(trackParquetSchema))
+	return t
+}
+
+// WriteGene writes the tracks of gene. energies may be nil, leaving the energy columns null.
+func (t *parquetTrackWriter) WriteGene(gene *rlooper.Gene, probabilities []float64, energies *rlooper.EnergyTracks) error {
+	// end the previous gene's row group so that no row group mixes genes
+	if err := t.pw.flushRowGroup(); err != nil {
+		return fmt.Errorf("error writing parquet tracks: %v", err)
+	}
+	chrom, offset := chromName(gene), genomicOffset(gene)
+	for i, p := range probabilities {
+		t.geneColumn.appendString(gene.GeneName)
+		t.chromosome.appendString(chrom)
+		t.position.appendInt64(offset + int64(i))
+		t.bpProbability.appendDouble(p)
+		if energies != nil {
+			t.averageEnergy.appendDouble(energies.AverageEnergy[i])
+			t.minFreeEnergy.appendDouble(energies.MinFreeEnergy[i])
+		} else {
+			t.averageEnergy.appendDouble(math.NaN())
+			t.minFreeEnergy.appendDouble(math.NaN())
+		}
+		if err := t.pw.endRow(); err != nil {
+			return fmt.Errorf("error writing parquet tracks: %v", err)
+		}
+	}
+	return nil
+}
+
+// Close writes the last row group and the footer
+func (t *parquetTrackWriter) Close() error {
+	return t.pw.Close()
+}
+
+// writeTracksParquet writes the per-base tracks of gene as one row group. energies may be nil, leaving the
+// energy columns null.
+func writeTracksParquet(w io.Writer, gene *rlooper.Gene, probabilities []float64, energies *rlooper.EnergyTracks) error {
+	t := newParquetTrackWriter(w)
+	if err := t.WriteGene(gene, probabilities, energies); err != nil {
+		return err
+	}
+	return t.Close()
+}
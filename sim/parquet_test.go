@@ -0,0 +1,378 @@
+package sim
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golooper/rlooper"
+)
+
+// thriftReader decodes the thrift compact protocol into maps keyed by field id
+type thriftReader struct {
+	t   *testing.T
+	buf []byte
+	pos int
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		r.t.Fatalf("bad varint at %d", r.pos)
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(kind byte) any {
+	switch kind {
+	case 1:
+		return true
+	case 2:
+		return false
+	case 5, 6:
+		return r.zigzag()
+	case 8:
+		n := int(r.uvarint())
+		s := string(r.buf[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case 9:
+		header := r.buf[r.pos]
+		r.pos++
+		n, elem := int(header>>4), header&0x0f
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		list := make([]any, n)
+		for i := range list {
+			list[i] = r.value(elem)
+		}
+		return list
+	case 12:
+		return r.structure()
+	}
+	r.t.Fatalf("unexpected thrift type %d", kind)
+	return nil
+}
+
+func (r *thriftReader) structure() map[int16]any {
+	fields := make(map[int16]any)
+	var id int16
+	for {
+		header := r.buf[r.pos]
+		r.pos++
+		if header == 0 {
+			return fields
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(r.zigzag())
+		}
+		fields[id] = r.value(header & 0x0f)
+	}
+}
+
+// decodeHybrid reads n values of the RLE/bit-packed hybrid encoding
+func decodeHybrid(t *testing.T, buf []byte, bitWidth int, n int) ([]int32, int) {
+	t.Helper()
+	var values []int32
+	pos := 0
+	for len(values) < n {
+		header, k := binary.Uvarint(buf[pos:])
+		pos += k
+		if header&1 == 0 {
+			var v int32
+			for b := 0; b < (bitWidth+7)/8; b++ {
+				v |= int32(buf[pos]) << (8 * b)
+				pos++
+			}
+			for i := 0; i < int(header>>1); i++ {
+				values = append(values, v)
+			}
+			continue
+		}
+		count := int(header>>1) * 8
+		var acc uint64
+		accBits := 0
+		for i := 0; i < count; i++ {
+			for accBits < bitWidth {
+				acc |= uint64(buf[pos]) << accBits
+				pos++
+				accBits += 8
+			}
+			values = append(values, int32(acc&(1<<bitWidth-1)))
+			acc >>= bitWidth
+			accBits -= bitWidth
+		}
+	}
+	return values[:n], pos
+}
+
+// parquetContents is what readParquet recovers from a file
+type parquetContents struct {
+	columns   map[string][]any // nil for null
+	kinds     map[string]int64
+	metadata  map[string]string
+	rowGroups []int64
+}
+
+// readParquet parses a flat Parquet file following the format specification
+func readParquet(t *testing.T, data []byte) parquetContents {
+	t.Helper()
+	if string(data[:4]) != parquetMagic || string(data[len(data)-4:]) != parquetMagic {
+		t.Fatal("missing PAR1 magic")
+	}
+	footerLength := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := (&thriftReader{t: t, buf: data[len(data)-8-footerLength : len(data)-8]}).structure()
+
+	contents := parquetContents{columns: make(map[string][]any), kinds: make(map[string]int64), metadata: make(map[string]string)}
+	schema := footer[2].([]any)
+	var names []string
+	optional := make(map[string]bool)
+	for _, element := range schema[1:] {
+		e := element.(map[int16]any)
+		name := e[4].(string)
+		names = append(names, name)
+		contents.kinds[name] = e[1].(int64)
+		optional[name] = e[3].(int64) == int64(parquetOptional)
+	}
+	if kvs, ok := footer[5].([]any); ok {
+		for _, kv := range kvs {
+			m := kv.(map[int16]any)
+			contents.metadata[m[1].(string)] = m[2].(string)
+		}
+	}
+
+	page := func(offset int64) (map[int16]any, []byte) {
+		r := &thriftReader{t: t, buf: data, pos: int(offset)}
+		header := r.structure()
+		size := int(header[3].(int64))
+		zr, err := gzip.NewReader(bytes.NewReader(data[r.pos : r.pos+size]))
+		if err != nil {
+			t.Fatalf("Failed to open page: %v", err)
+		}
+		body, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("Failed to inflate page: %v", err)
+		}
+		if len(body) != int(header[2].(int64)) {
+			t.Fatalf("page inflated to %d bytes, header says %d", len(body), header[2])
+		}
+		return header, body
+	}
+
+	var totalRows int64
+	for _, group := range footer[4].([]any) {
+		rg := group.(map[int16]any)
+		rows := rg[3].(int64)
+		contents.rowGroups = append(contents.rowGroups, rows)
+		totalRows += rows
+		for i, chunk := range rg[1].([]any) {
+			name := names[i]
+			md := chunk.(map[int16]any)[3].(map[int16]any)
+			var dictionary []any
+			if offset, ok := md[11].(int64); ok {
+				header, body := page(offset)
+				for k := 0; k < int(header[7].(map[int16]any)[1].(int64)); k++ {
+					n := int(binary.LittleEndian.Uint32(body))
+					dictionary = append(dictionary, string(body[4:4+n]))
+					body = body[4+n:]
+				}
+			}
+			header, body := page(md[9].(int64))
+			n := int(header[5].(map[int16]any)[1].(int64))
+			if int64(n) != rows {
+				t.Fatalf("column %s has %d values in a row group of %d rows", name, n, rows)
+			}
+			defined := make([]int32, n)
+			for k := range defined {
+				defined[k] = 1
+			}
+			if optional[name] {
+				length := int(binary.LittleEndian.Uint32(body))
+				defined, _ = decodeHybrid(t, body[4:4+length], 1, n)
+				body = body[4+length:]
+			}
+			count := 0
+			for _, d := range defined {
+				count += int(d)
+			}
+			var values []any
+			if dictionary != nil {
+				indices, _ := decodeHybrid(t, body[1:], int(body[0]), count)
+				for _, index := range indices {
+					values = append(values, dictionary[index])
+				}
+			} else {
+				for k := 0; k < count; k++ {
+					switch int32(contents.kinds[name]) {
+					case parquetInt32:
+						values = append(values, int64(int32(binary.LittleEndian.Uint32(body[4*k:]))))
+					case parquetInt64:
+						values = append(values, int64(binary.LittleEndian.Uint64(body[8*k:])))
+					case parquetDouble:
+						values = append(values, math.Float64frombits(binary.LittleEndian.Uint64(body[8*k:])))
+					}
+				}
+			}
+			for _, d := range defined {
+				if d == 0 {
+					contents.columns[name] = append(contents.columns[name], nil)
+					continue
+				}
+				contents.columns[name] = append(contents.columns[name], values[0])
+				values = values[1:]
+			}
+		}
+	}
+	if totalRows != footer[3].(int64) {
+		t.Errorf("row groups hold %d rows, footer says %d", totalRows, footer[3])
+	}
+	return contents
+}
+
+func TestAppendHybrid(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, tc := range []struct {
+		name     string
+		bitWidth int
+		values   []int32
+	}{
+		{"constant", 1, []int32{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}},
+		{"short", 3, []int32{5, 2, 7}},
+		{"mixed", 4, append(append([]int32{1, 2, 3, 4, 5, 6, 7, 8, 9}, make([]int32, 20)...), 3, 3, 1)},
+	} {
+		buf := appendHybrid(nil, tc.values, tc.bitWidth)
+		decoded, _ := decodeHybrid(t, buf, tc.bitWidth, len(tc.values))
+		for i := range tc.values {
+			if decoded[i] != tc.values[i] {
+				t.Errorf("%s: value %d decoded as %d, want %d", tc.name, i, decoded[i], tc.values[i])
+			}
+		}
+	}
+
+	values := make([]int32, 10000)
+	for i := range values {
+		if rng.Intn(3) > 0 {
+			values[i] = int32(rng.Intn(1000))
+		} else if i > 0 {
+			values[i] = values[i-1]
+		}
+	}
+	decoded, _ := decodeHybrid(t, appendHybrid(nil, values, 10), 10, len(values))
+	for i := range values {
+		if decoded[i] != values[i] {
+			t.Fatalf("random value %d decoded as %d, want %d", i, decoded[i], values[i])
+		}
+	}
+}
+
+func TestParquetStructureWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.parquet")
+	w, err := NewStructureWriter(path, "parquet")
+	if err != nil {
+		t.Fatalf("Failed to create structure writer: %v", err)
+	}
+	gw := w.(geneStructureWriter)
+	if err := gw.StartGene("first"); err != nil {
+		t.Fatalf("StartGene returned error: %v", err)
+	}
+	if err := w.WriteStructures(dumpTestStructures); err != nil {
+		t.Fatalf("Failed to write structures: %v", err)
+	}
+	if err := gw.StartGene("second"); err != nil {
+		t.Fatalf("StartGene returned error: %v", err)
+	}
+	if err := w.WriteStructures(dumpTestStructures[:1]); err != nil {
+		t.Fatalf("Failed to write structures: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close structure writer: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read dump: %v", err)
+	}
+	contents := readParquet(t, data)
+	if len(contents.rowGroups) != 2 || contents.rowGroups[0] != 2 || contents.rowGroups[1] != 1 {
+		t.Errorf("expected a row group per gene, got %v", contents.rowGroups)
+	}
+	if contents.metadata[parquetSchemaKey] != structureParquetSchema {
+		t.Errorf("unexpected schema metadata %v", contents.metadata)
+	}
+	expected := map[string][]any{
+		"gene":             {"first", "first", "second"},
+		"chromosome":       {"chr1", "chr1", "chr1"},
+		"start":            {int64(0), int64(3), int64(0)},
+		"end":              {int64(4), int64(1), int64(4)},
+		"length":           {int64(5), int64(4), int64(5)},
+		"free_energy":      {-1.5, 0.5, -1.5},
+		"boltzmann_factor": {2.5, 0.75, 2.5},
+		"probability":      {0.25, 0.125, 0.25},
+	}
+	for name, values := range expected {
+		got := contents.columns[name]
+		if len(got) != len(values) {
+			t.Errorf("column %s: expected %v, got %v", name, values, got)
+			continue
+		}
+		for i := range values {
+			if got[i] != values[i] {
+				t.Errorf("column %s row %d: expected %v, got %v", name, i, values[i], got[i])
+			}
+		}
+	}
+}
+
+func TestWriteTracksParquet(t *testing.T) {
+	gene := &rlooper.Gene{
+		GeneName: "test",
+		Pos:      rlooper.Loci{Chromosome: "chr1", Strand: "+", StartPos: 101, EndPos: 104},
+		Sequence: []rune("GATT"),
+	}
+	energies := &rlooper.EnergyTracks{
+		AverageEnergy: []float64{1, math.NaN(), 2, 3},
+		MinFreeEnergy: []float64{0.5, math.NaN(), 1.5, 2.5},
+	}
+	var buf bytes.Buffer
+	if err := writeTracksParquet(&buf, gene, []float64{0.1, 0, 0.2, 0.3}, energies); err != nil {
+		t.Fatalf("writeTracksParquet returned error: %v", err)
+	}
+	contents := readParquet(t, buf.Bytes())
+	if len(contents.rowGroups) != 1 || contents.rowGroups[0] != 4 {
+		t.Errorf("expected one row group of 4 rows, got %v", contents.rowGroups)
+	}
+	if contents.columns["position"][0] != int64(100) || contents.columns["position"][3] != int64(103) {
+		t.Errorf("unexpected positions %v", contents.columns["position"])
+	}
+	if contents.columns["average_energy"][1] != nil || contents.columns["average_energy"][2] != 2.0 {
+		t.Errorf("expected NaN energies as null, got %v", contents.columns["average_energy"])
+	}
+	if contents.columns["bp_probability"][1] != 0.0 {
+		t.Errorf("expected a zero probability to be kept, got %v", contents.columns["bp_probability"][1])
+	}
+
+	buf.Reset()
+	if err := writeTracksParquet(&buf, gene, []float64{0.1, 0, 0.2, 0.3}, nil); err != nil {
+		t.Fatalf("writeTracksParquet returned error: %v", err)
+	}
+	for _, v := range readParquet(t, buf.Bytes()).columns["min_free_energy"] {
+		if v != nil {
+			t.Errorf("expected null energies without energy tracks, got %v", v)
+		}
+	}
+}
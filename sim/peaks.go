@@ -0,0 +1,162 @@
+package sim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"golooper/config"
+	"golooper/rlooper"
+)
+
+// BED layouts accepted by --bed-format
+const (
+	BedFormat6          = "bed6"
+	BedFormat9          = "bed9"
+	BedFormatNarrowPeak = "narrowpeak"
+)
+
+// DefaultPeakThreshold is the base pair probability a base needs to be part of a probability peak
+const DefaultPeakThreshold = 0.1
+
+// DefaultMfeMargin is how far above the lowest minimum free energy of a gene, in Kcal/mol, a base's minimum
+// free energy may be for the base to be part of an MFE peak. at 310 K a loop 2 Kcal/mol less stable than
+// the most stable one is still about 4% as likely.
+const DefaultMfeMargin = 2.0
+
+// checkBedFormat returns an error for an unknown --bed-format
+func checkBedFormat(config *config.Config) error {
+	switch config.BedFormat {
+	case "", BedFormat6, BedFormat9, BedFormatNarrowPeak:
+		return nil
+	default:
+		return fmt.Errorf("unknown BED format %q (expected %s, %s or %s)", config.BedFormat, BedFormat6, BedFormat9, BedFormatNarrowPeak)
+	}
+}
+
+// bedPeak is a run of bases passing a threshold, in sequence positions, with its BED columns
+type bedPeak struct {
+	start  int // first base
+	end    int // one past the last base
+	summit int // strongest base
+	score  int // 0 to 1000
+	signal float64
+	pValue float64 // -log10, or -1 when there is none
+}
+
+// findRuns returns the maximal half-open runs of values that pass
+func findRuns(values []float64, pass func(float64) bool) [][2]int {
+	var runs [][2]int
+	for i, v := range values {
+		if !pass(v) {
+			continue
+		}
+		if last := len(runs) - 1; last >= 0 && runs[last][1] == i {
+			runs[last][1] = i + 1
+		} else {
+			runs = append(runs, [2]int{i, i + 1})
+		}
+	}
+	return runs
+}
+
+// probabilityPeaks calls peaks where the base pair probability reaches threshold. the score is the summit
+// probability scaled to 1000, the signal is the mean probability over the peak and the p-value column is
+// -log10 of the probability that the summit is not in a loop.
+func probabilityPeaks(probabilities []float64, threshold float64) []bedPeak {
+	var peaks []bedPeak
+	for _, run := range findRuns(probabilities, func(p float64) bool { return p >= threshold }) {
+		peak := bedPeak{start: run[0], end: run[1], summit: run[0]}
+		for i := run[0]; i < run[1]; i++ {
+			peak.signal += probabilities[i]
+			if probabilities[i] > probabilities[peak.summit] {
+				peak.summit = i
+			}
+		}
+		peak.signal /= float64(run[1] - run[0])
+		top := probabilities[peak.summit]
+		peak.score = int(math.Round(math.Min(1, top) * 1000))
+		peak.pValue = math.Min(-math.Log10(math.Max(1-top, 0)), 1000)
+		peaks = append(peaks, peak)
+	}
+	return peaks
+}
+
+// energyPeaks calls peaks where the minimum free energy of the loops covering a base is within margin
+// Kcal/mol of the lowest of the gene, so that the peaks mark where its most stable loops form. the ground
+// state makes no cutoff: it is the energy of the whole domain's supercoiling, tens of Kcal/mol above most
+// loops. the score scales the summit's energy within the margin so that the most stable base maps to 1000,
+// and the signal is the mean energy below the cutoff in Kcal/mol.
+func energyPeaks(mfe []float64, margin float64) []bedPeak {
+	lowest := math.Inf(1)
+	for _, e := range mfe {
+		if !math.IsNaN(e) {
+			lowest = math.Min(lowest, e)
+		}
+	}
+	cutoff := lowest + margin
+	var peaks []bedPeak
+	for _, run := range findRuns(mfe, func(e float64) bool { return e <= cutoff }) {
+		peak := bedPeak{start: run[0], end: run[1], summit: run[0], pValue: -1}
+		for i := run[0]; i < run[1]; i++ {
+			peak.signal += cutoff - mfe[i]
+			if mfe[i] < mfe[peak.summit] {
+				peak.summit = i
+			}
+		}
+		peak.signal /= float64(run[1] - run[0])
+		peak.score = 1000
+		if margin > 0 {
+			peak.score = int(math.Round((cutoff - mfe[peak.summit]) / margin * 1000))
+		}
+		peaks = append(peaks, peak)
+	}
+	return peaks
+}
+
+// bedStrand returns the BED strand column for gene
+func bedStrand(gene *rlooper.Gene) string {
+	if gene.Pos.Strand == "" {
+		return "."
+	}
+	return gene.Pos.Strand
+}
+
+// itemRgb shades color from white at score 0 to the full color at score 1000
+func itemRgb(color string, score int) string {
+	channels := strings.Split(color, ",")
+	if len(channels) != 3 {
+		channels = strings.Split(defaultTrackColor, ",")
+	}
+	shaded := make([]string, 3)
+	for i, c := range channels {
+		v, err := strconv.Atoi(strings.TrimSpace(c))
+		if err != nil {
+			v = 0
+		}
+		shaded[i] = strconv.Itoa(255 - (255-v)*score/1000)
+	}
+	return strings.Join(shaded, ",")
+}
+
+// writeBedPeaks writes peaks in genomic coordinates in the layout set by config, naming each after the gene
+func writeBedPeaks(w io.Writer, config *config.Config, gene *rlooper.Gene, kind string, peaks []bedPeak) error {
+	chrom, offset, strand := chromName(gene), genomicOffset(gene), bedStrand(gene)
+	color := trackAttributes(config, "").Color
+	buf := bufio.NewWriter(w)
+	for i, p := range peaks {
+		start, end := offset+int64(p.start), offset+int64(p.end)
+		fmt.Fprintf(buf, "%s\t%d\t%d\t%s_%s_%d\t%d\t%s", chrom, start, end, gene.GeneName, kind, i+1, p.score, strand)
+		switch config.BedFormat {
+		case BedFormat9:
+			fmt.Fprintf(buf, "\t%d\t%d\t%s", start, end, itemRgb(color, p.score))
+		case BedFormatNarrowPeak:
+			fmt.Fprintf(buf, "\t%.6g\t%.6g\t-1\t%d", p.signal, p.pValue, p.summit-p.start)
+		}
+		fmt.Fprintln(buf)
+	}
+	return buf.Flush()
+}
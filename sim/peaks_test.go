@@ -0,0 +1,115 @@
+package sim
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"golooper/config"
+	"golooper/rlooper"
+)
+
+func TestFindRuns(t *testing.T) {
+	values := []float64{1, 1, 0, 1, math.NaN(), 1}
+	runs := findRuns(values, func(v float64) bool { return v > 0.5 })
+	expected := [][2]int{{0, 2}, {3, 4}, {5, 6}}
+	if len(runs) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, runs)
+	}
+	for i := range expected {
+		if runs[i] != expected[i] {
+			t.Errorf("run %d: expected %v, got %v", i, expected[i], runs[i])
+		}
+	}
+}
+
+func TestProbabilityPeaks(t *testing.T) {
+	peaks := probabilityPeaks([]float64{0.05, 0.2, 0.9, 0.4, 0.01, 1}, 0.1)
+	if len(peaks) != 2 {
+		t.Fatalf("expected 2 peaks, got %+v", peaks)
+	}
+	p := peaks[0]
+	if p.start != 1 || p.end != 4 || p.summit != 2 || p.score != 900 {
+		t.Errorf("unexpected first peak %+v", p)
+	}
+	if math.Abs(p.signal-0.5) > 1e-12 || math.Abs(p.pValue-1) > 1e-12 {
+		t.Errorf("expected signal 0.5 and p-value 1, got %v and %v", p.signal, p.pValue)
+	}
+	if peaks[1].score != 1000 || peaks[1].pValue != 1000 {
+		t.Errorf("expected a certain base to score 1000 with a capped p-value, got %+v", peaks[1])
+	}
+}
+
+func TestEnergyPeaks(t *testing.T) {
+	// a cutoff 2 Kcal/mol above the lowest energy, -4, makes peaks of the bases at -2 and below
+	mfe := []float64{math.NaN(), 2, -1, -4, -2.5, 1.5, -2}
+	peaks := energyPeaks(mfe, 2)
+	if len(peaks) != 2 {
+		t.Fatalf("expected 2 peaks, got %+v", peaks)
+	}
+	if p := peaks[0]; p.start != 3 || p.end != 5 || p.summit != 3 || p.score != 1000 || p.signal != 1.25 || p.pValue != -1 {
+		t.Errorf("unexpected first peak %+v", p)
+	}
+	if p := peaks[1]; p.start != 6 || p.end != 7 || p.score != 0 {
+		t.Errorf("unexpected second peak %+v", p)
+	}
+
+	// with no margin only the most stable bases are peaks
+	if peaks := energyPeaks(mfe, 0); len(peaks) != 1 || peaks[0].start != 3 || peaks[0].end != 4 || peaks[0].score != 1000 {
+		t.Errorf("expected the lowest base alone, got %+v", peaks)
+	}
+	// energies far above zero, as with the ground state of a long domain, still give peaks
+	if peaks := energyPeaks([]float64{30, 12, 11, 20}, 2); len(peaks) != 1 || peaks[0].start != 1 || peaks[0].end != 3 {
+		t.Errorf("expected one peak over the two most stable bases, got %+v", peaks)
+	}
+	if peaks := energyPeaks([]float64{math.NaN(), math.NaN()}, 2); len(peaks) != 0 {
+		t.Errorf("expected no peaks without energies, got %+v", peaks)
+	}
+}
+
+func TestItemRgb(t *testing.T) {
+	for _, tc := range []struct {
+		color    string
+		score    int
+		expected string
+	}{
+		{"50,150,255", 1000, "50,150,255"},
+		{"50,150,255", 0, "255,255,255"},
+		{"0,0,0", 500, "128,128,128"},
+		{"red", 1000, defaultTrackColor},
+	} {
+		if got := itemRgb(tc.color, tc.score); got != tc.expected {
+			t.Errorf("itemRgb(%q, %d): expected %s, got %s", tc.color, tc.score, tc.expected, got)
+		}
+	}
+}
+
+func TestWriteBedPeaks(t *testing.T) {
+	gene := &rlooper.Gene{
+		GeneName: "test",
+		Pos:      rlooper.Loci{Chromosome: "chr1", Strand: "-", StartPos: 101, EndPos: 110},
+		Sequence: []rune("GATTACAGAT"),
+	}
+	peaks := []bedPeak{{start: 2, end: 5, summit: 3, score: 800, signal: 0.5, pValue: 0.69897}}
+	for _, tc := range []struct {
+		format   string
+		expected string
+	}{
+		{BedFormat6, "chr1\t102\t105\ttest_bpprob_1\t800\t-\n"},
+		{BedFormat9, "chr1\t102\t105\ttest_bpprob_1\t800\t-\t102\t105\t91,171,255\n"},
+		{BedFormatNarrowPeak, "chr1\t102\t105\ttest_bpprob_1\t800\t-\t0.5\t0.69897\t-1\t1\n"},
+	} {
+		var buf bytes.Buffer
+		cfg := &config.Config{BedFormat: tc.format}
+		if err := writeBedPeaks(&buf, cfg, gene, "bpprob", peaks); err != nil {
+			t.Fatalf("writeBedPeaks returned error: %v", err)
+		}
+		if buf.String() != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.format, tc.expected, buf.String())
+		}
+	}
+
+	if err := checkBedFormat(&config.Config{BedFormat: "bed12"}); err == nil {
+		t.Error("expected an error for an unknown BED format")
+	}
+}
@@ -1,64 +1,383 @@
 package sim
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log"
 	"os"
+	"runtime"
+	"slices"
+	"sync"
 
 	"golooper/config"
+	"golooper/rlooper"
 )
 
-func SimulationA(config *config.Config) error {
+// modelFromConfig returns the model defaults with any values set in config applied on top
+func modelFromConfig(config *config.Config, gene *rlooper.Gene) rlooper.ModelParams {
+	model := rlooper.NewParamsReasonableDefaults()
+	if config.AutoDomainSize {
+		model.SetN(float64(len(gene.Sequence)))
+	} else if config.SuperhelicityDomain != nil {
+		model.SetN(float64(*config.SuperhelicityDomain))
+	}
+	if config.SuperhelicalDensity != nil {
+		model.SetSuperhelicity(*config.SuperhelicalDensity)
+	}
+	if config.NucleationFreeEnergy != nil {
+		model.SetNucleationFreeEnergy(*config.NucleationFreeEnergy)
+	}
+	if config.Homopolymer != nil {
+		model.SetHomopolymerOverride(*config.Homopolymer)
+	}
+	return model
+}
+
+// threadCount returns the number of worker threads set by --threads, or the number of CPUs
+func threadCount(config *config.Config) int {
+	if config.Threads <= 0 {
+		return runtime.NumCPU()
+	}
+	return config.Threads
+}
+
+// newExecutionContext returns the worker settings of config, stopped by canceling ctx
+func newExecutionContext(ctx context.Context, config *config.Config) *rlooper.ExecutionContext {
+	return &rlooper.ExecutionContext{
+		NumThreads: threadCount(config),
+		ChunkSize:  config.ChunkSize,
+		WaitGroup:  &sync.WaitGroup{},
+		Context:    ctx,
+	}
+}
+
+// basePairProbabilities computes per-base R-loop probabilities with the engine selected in config
+func basePairProbabilities(config *config.Config, gene *rlooper.Gene, ec *rlooper.ExecutionContext, model *rlooper.ModelParams, wp rlooper.WindowParams) ([]float64, error) {
+	switch config.Engine {
+	case "", rlooper.EngineEnumerate:
+		return gene.BasePairProbabilities(ec, model, wp)
+	case rlooper.EngineDP:
+		return gene.BasePairProbabilitiesDP(ec, model, wp)
+	default:
+		return nil, fmt.Errorf("unknown engine %q (expected %s or %s)", config.Engine, rlooper.EngineEnumerate, rlooper.EngineDP)
+	}
+}
+
+// dumpsProbabilities reports whether the base pair probabilities come from the enumeration that writes the
+// structure dump, which the writer runs in input order, rather than from computeGene
+func dumpsProbabilities(config *config.Config) bool {
+	enumerate := config.Engine == "" || config.Engine == rlooper.EngineEnumerate
+	return config.Dump && enumerate && config.SampleSteps == 0 && (config.MaxLoops == nil || *config.MaxLoops <= 1)
+}
+
+// Track formats accepted by --format
+const (
+	FormatWig    = "wig"
+	FormatBigWig = "bigwig"
+)
+
+// checkTrackFormat returns an error for an unknown --format
+func checkTrackFormat(config *config.Config) error {
+	switch config.Format {
+	case "", FormatWig, FormatBigWig:
+		return nil
+	default:
+		return fmt.Errorf("unknown track format %q (expected %s or %s)", config.Format, FormatWig, FormatBigWig)
+	}
+}
+
+// ConfigChecks returns the checks of the settings whose accepted values are defined here, for
+// config.Validate
+func ConfigChecks() []config.Check {
+	return []config.Check{
+		{Setting: "sigma", Fn: checkSigma},
+		{Setting: "format", Fn: checkTrackFormat},
+		{Setting: "bed-format", Fn: checkBedFormat},
+		{Setting: "dump-format", Fn: checkDumpFormat},
+		{Setting: "outputs", Fn: checkOutputs},
+		{Setting: "initiation-zone", Fn: checkInitiationZone},
+		{Setting: "sample-steps", Fn: checkSampleSteps},
+		{Setting: "resume", Fn: checkResume},
+	}
+}
+
+// writeTrack writes per-base values to the wig output called name, or for bigWig output adds them to the
+// file written when outputs are closed. nothing is written if the output was not selected.
+func writeTrack(config *config.Config, gene *rlooper.Gene, outputs *FileOps, name string, values []float64) error {
+	if !outputs.Selected(name) {
+		return nil
+	}
+	if config.Format == FormatBigWig {
+		return outputs.addBigWigTrack(name, geneBigWigTrack(gene, values))
+	}
+	return outputs.Write(name, func(wig *os.File) error {
+		return NewWigWriter(wig, config.WigSpan).WriteValues(chromName(gene), genomicOffset(gene), values)
+	})
+}
+
+// minLoopLength returns the configured minimum R-loop length or the model default
+func minLoopLength(config *config.Config) int {
+	if config.MinRLoopLength != nil {
+		return *config.MinRLoopLength
+	}
+	return rlooper.DefaultMinLoopLength
+}
+
+// annotations are the tracks of a run that apply across its genes, read once and sliced for each gene
+type annotations struct {
+	initiation trackIndex // nil without --initiation-bed
+	occupancy  trackIndex // nil without --occupancy
+}
+
+// readAnnotations reads the annotation tracks set in config
+func readAnnotations(config *config.Config) (*annotations, error) {
+	a := &annotations{}
+	if config.InitiationBed != "" {
+		sites, err := readInitiationSites(config.InitiationBed)
+		if err != nil {
+			return nil, err
+		}
+		a.initiation = sites
+	}
+	if config.OccupancyTrack != "" {
+		occupancy, err := readOccupancy(config.OccupancyTrack)
+		if err != nil {
+			return nil, err
+		}
+		a.occupancy = occupancy
+	}
+	return a, nil
+}
+
+// windowParamsFromConfig returns the loop length restrictions, prior, initiation zones and energy
+// adjustments set in config, with the annotations of the run applied to gene
+func windowParamsFromConfig(config *config.Config, annotations *annotations, gene *rlooper.Gene) (rlooper.WindowParams, error) {
+	prior, err := rlooper.ParseLengthPrior(config.LengthPrior)
+	if err != nil {
+		return rlooper.WindowParams{}, err
+	}
+	wp := rlooper.WindowParams{
+		MinLength:   minLoopLength(config),
+		Circular:    config.Circular,
+		LengthPrior: prior,
+	}
+	if config.MaxRLoopLength != nil {
+		wp.MaxLength = *config.MaxRLoopLength
+	}
+	zones, err := initiationZones(config, annotations.initiation, gene)
+	if err != nil {
+		return rlooper.WindowParams{}, err
+	}
+	if zones != nil {
+		wp.Initiation = rlooper.InitiationMask(len(gene.Sequence), zones)
+		wp.InitiateFromEnd = gene.Pos.Strand == "-"
+	}
+	if config.G4Bonus != nil {
+		wp.Adjustments = append(wp.Adjustments, rlooper.G4Bonus{Motifs: g4Motifs(gene), Energy: -*config.G4Bonus})
+	}
+	if annotations.occupancy != nil {
+		occupancy := geneOccupancy(annotations.occupancy, gene)
+		wp.Adjustments = append(wp.Adjustments, rlooper.NewOccupancyPenalty(occupancy, config.OccupancyPenalty))
+	}
+	return wp, nil
+}
+
+// SimulationA computes the ensemble of every gene of the input and writes the selected outputs. genes that
+// take longer than config.GeneTimeout are skipped. canceling ctx stops the run: when every output can be
+// appended to, the outputs of the genes written so far are kept with a checkpoint that config.Resume
+// continues from, otherwise they are removed. config is expected to have passed config.Validate with
+// ConfigChecks.
+func SimulationA(ctx context.Context, config *config.Config) error {
+	warnSuperhelicalDensity(config)
+
+	ec := newExecutionContext(ctx, config)
+	manifest := newRunManifest(config, ec.NumThreads)
+	if err := manifest.addInputs(config); err != nil {
+		return err
+	}
+	annotations, err := readAnnotations(config)
+	if err != nil {
+		return err
+	}
+
+	// Output files are created at their first write
+	outFiles, err := NewFileOps(config)
+	if err != nil {
+		return err
+	}
+	// outputs are written under partial names and only moved into place once the run succeeds
+	defer outFiles.Abort()
+
 	infile, err := os.Open(config.InfileName)
 	if err != nil {
 		return fmt.Errorf("error opening input file: %v", err)
 	}
 	defer infile.Close()
 
-	// Create all output files using FileOps
-	outFiles, err := CreateOutputFiles(config) // TODO: move fileops use to first place results are written
-	if err != nil {
-		return fmt.Errorf("error creating output files: %v", err)
+	// a run whose outputs can all be appended to records the genes it has written in a checkpoint, which
+	// --resume continues from after the outputs written so far
+	var checkpoint *Checkpoint
+	var resumed []GeneTiming
+	if config.Resume {
+		if checkpoint, err = readCheckpoint(config); err != nil {
+			return err
+		}
+		if err := checkpoint.verify(config); err != nil {
+			return err
+		}
+		if err := outFiles.resume(checkpoint.Outputs); err != nil {
+			return err
+		}
+		resumed = slices.Clone(checkpoint.Genes)
+		manifest.Genes = append(manifest.Genes, resumed...)
+		fmt.Printf("Resuming after %d genes from %s\n", len(resumed), checkpointPath(config))
+	} else if resumable(config) == nil {
+		if checkpoint, err = newCheckpoint(config); err != nil {
+			return err
+		}
+		// the outputs the checkpoint of an earlier run describes are about to be overwritten
+		if err := os.Remove(checkpointPath(config)); err == nil {
+			log.Printf("WARN: starting over, discarding the checkpoint of an earlier run; use --resume to continue a run")
+		}
 	}
-	defer outFiles.Close()
 
-	// Test reading first few bytes of input file to validate it's accessible
-	testBuf := make([]byte, 100)
-	n, err := infile.Read(testBuf)
+	writer, err := newGeneWriter(config, ec, outFiles, manifest, checkpoint)
 	if err != nil {
-		return fmt.Errorf("error reading from input file: %v", err)
+		if config.Resume {
+			outFiles.Keep()
+		}
+		return err
 	}
-	if n == 0 {
-		return fmt.Errorf("input file is empty")
+	defer writer.abort()
+
+	// the energy tracks cost as much as the probabilities, so skip them when none of their outputs is wanted
+	wantEnergies := outFiles.Selected("avgG") || outFiles.Selected("mfe") || outFiles.Selected("mfe-bed") || outFiles.Selected("parquet") || writer.wantResults
+	threads := func(gene *rlooper.Gene, last bool) int {
+		// one sampler chain runs per thread, so every gene samples with all of them to keep its result the
+		// one --threads gives for the gene on its own
+		if config.SampleSteps > 0 {
+			return ec.NumThreads
+		}
+		return geneThreads(gene, ec.NumThreads, last)
+	}
+	compute := func(job *geneJob) error {
+		if config.GeneTimeout > 0 {
+			geneCtx, cancel := context.WithTimeout(job.ec.Context, config.GeneTimeout)
+			defer cancel()
+			job.ec.Context = geneCtx
+		}
+		err := computeGene(config, annotations, job, wantEnergies, writer.wantResults)
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			// the gene ran out of time, not the run
+			job.timedOut = true
+			return nil
+		}
+		return err
 	}
 
-	// Reset file pointer to beginning after test read
-	_, err = infile.Seek(0, 0)
-	if err != nil {
-		return fmt.Errorf("error resetting file position: %v", err)
+	reader := rlooper.NewFastaReader(infile)
+	if runErr := scheduleGenes(ec, skipFinishedGenes(reader, resumed), threads, compute, writer.write); runErr != nil {
+		if writer.keep() {
+			return fmt.Errorf("run stopped: %v; partial outputs kept, rerun with --resume to continue from %s", runErr, checkpointPath(config))
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("run stopped, partial outputs removed: %v", ctx.Err())
+		}
+		return runErr
+	}
+	if writer.genes == 0 && writer.skipped == 0 && len(manifest.Genes) == 0 {
+		return fmt.Errorf("input file %s has no FASTA records", config.InfileName)
 	}
 
-	// Test writing to output files
-	testStr := "Test output\n"
-	if _, err := outFiles.BasePairProbWig.WriteString(testStr); err != nil {
-		return fmt.Errorf("error writing to base pair prob wig: %v", err)
+	if err := writer.close(); err != nil {
+		return err
+	}
+	if err := outFiles.Close(); err != nil {
+		return err
 	}
-	if _, err := outFiles.AverageEnergyWig.WriteString(testStr); err != nil {
-		return fmt.Errorf("error writing to average energy wig: %v", err)
+	if err := manifest.write(config); err != nil {
+		return err
 	}
-	if _, err := outFiles.MinFreeEnergyWig.WriteString(testStr); err != nil {
-		return fmt.Errorf("error writing to min free energy wig: %v", err)
+	if checkpoint != nil {
+		if err := os.Remove(checkpointPath(config)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("WARN: error removing checkpoint: %v", err)
+		}
 	}
-	if _, err := outFiles.BasePairProbBed.WriteString(testStr); err != nil {
-		return fmt.Errorf("error writing to base pair prob bed: %v", err)
+	return nil
+}
+
+// skipFinishedGenes returns the reader of the genes left to compute, after the genes finished records as
+// written by the run being resumed
+func skipFinishedGenes(reader *rlooper.FastaReader, finished []GeneTiming) func() (*rlooper.Gene, error) {
+	return func() (*rlooper.Gene, error) {
+		for len(finished) > 0 {
+			gene, err := reader.Next()
+			if err == io.EOF {
+				return nil, fmt.Errorf("cannot resume: the input has fewer records than the checkpoint records genes")
+			}
+			if err != nil {
+				return nil, err
+			}
+			if gene.GeneName != finished[0].Name {
+				return nil, fmt.Errorf("cannot resume: the input has %s where the checkpoint records %s", gene.GeneName, finished[0].Name)
+			}
+			finished = finished[1:]
+		}
+		return reader.Next()
 	}
-	if _, err := outFiles.MinFreeEnergyBed.WriteString(testStr); err != nil {
-		return fmt.Errorf("error writing to min free energy bed: %v", err)
+}
+
+// computeGene computes everything the selected outputs need for the gene of job, on the threads reserved
+// for it
+func computeGene(config *config.Config, annotations *annotations, job *geneJob, wantEnergies bool, wantResults bool) error {
+	gene, ec := job.gene, job.ec
+	wp, err := windowParamsFromConfig(config, annotations, gene)
+	if err != nil {
+		return err
 	}
-	if _, err := outFiles.ExtendedBasePairProbWig.WriteString(testStr); err != nil {
-		return fmt.Errorf("error writing to extended base pair prob wig: %v", err)
+	job.wp = wp
+	job.model = modelFromConfig(config, gene)
+	model := &job.model
+	if config.G4Bonus != nil {
+		job.motifs = g4Motifs(gene)
 	}
 
-	// TODO: Add the rest of the simulation logic here
+	if config.SampleSteps > 0 {
+		job.sampled, err = gene.SampleEnsemble(ec, model, wp, samplerParamsFromConfig(config))
+		if err != nil {
+			return fmt.Errorf("error sampling ensemble: %v", err)
+		}
+		job.probabilities = job.sampled.BpProbability
+	} else if config.MaxLoops != nil && *config.MaxLoops > 1 {
+		job.multiLoop, err = gene.ComputeMultiLoopEnsemble(ec, model, wp, *config.MaxLoops)
+		if err != nil {
+			if errors.Is(err, rlooper.ErrTooManyConfigurations) {
+				return fmt.Errorf("error computing multi-loop ensemble of %s: %v; lower --max-loops, cap loop length with --maxlength or sample the ensemble with --sample-steps", gene.GeneName, err)
+			}
+			return fmt.Errorf("error computing multi-loop ensemble: %v", err)
+		}
+		job.probabilities = job.multiLoop.BpProbability
+	} else if !dumpsProbabilities(config) {
+		job.probabilities, err = basePairProbabilities(config, gene, ec, model, wp)
+		if err != nil {
+			return err
+		}
+	}
 
+	if wantEnergies {
+		job.energies, err = gene.ComputeEnergyTracks(ec, model, wp)
+		if err != nil {
+			return fmt.Errorf("error computing energy tracks: %v", err)
+		}
+	}
+	if wantResults {
+		job.top, job.z, err = gene.TopStructures(ec, model, wp, config.TopStructures)
+		if err != nil {
+			return fmt.Errorf("error finding top structures: %v", err)
+		}
+	}
 	return nil
 }
@@ -0,0 +1,183 @@
+package sim
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golooper/config"
+)
+
+// writeTestFasta writes a FASTA file of two short genes and returns its path
+func writeTestFasta(t *testing.T, dir string) string {
+	path := filepath.Join(dir, "genes.fa")
+	fasta := ">first range=chr1:1-14 strand=+\nGATTACAGGGCCCA\n>second range=chr1:101-110 strand=+\nGGGCCCATTA\n"
+	if err := os.WriteFile(path, []byte(fasta), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSimulationACanceled(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(t.TempDir(), "run")
+	cfg := &config.Config{InfileName: writeTestFasta(t, dir), OutfileName: out, Threads: 2}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := SimulationA(ctx, cfg); err == nil {
+		t.Fatal("expected a canceled run to fail")
+	}
+	entries, _ := os.ReadDir(filepath.Dir(out))
+	if len(entries) != 0 {
+		t.Errorf("expected a canceled run to leave no outputs, found %v", entries)
+	}
+
+	if err := SimulationA(context.Background(), cfg); err != nil {
+		t.Fatalf("SimulationA returned error: %v", err)
+	}
+	entries, _ = os.ReadDir(filepath.Dir(out))
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) == partialSuffix {
+			t.Errorf("expected no partial files after a finished run, found %s", e.Name())
+		}
+	}
+}
+
+func TestSimulationAGeneTimeout(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{InfileName: writeTestFasta(t, dir), OutfileName: filepath.Join(dir, "out", "run"), GeneTimeout: time.Nanosecond}
+	if err := SimulationA(context.Background(), cfg); err != nil {
+		t.Fatalf("expected genes that time out to be skipped, got %v", err)
+	}
+	b, err := os.ReadFile(cfg.OutfileName + "_manifest.json")
+	if err != nil {
+		t.Fatalf("manifest was not written: %v", err)
+	}
+	var manifest RunManifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Genes) != 2 || !manifest.Genes[0].TimedOut || !manifest.Genes[1].TimedOut {
+		t.Errorf("expected both genes to be recorded as timed out, got %+v", manifest.Genes)
+	}
+	if _, err := os.Stat(cfg.OutfileName + "_bpprob.wig"); !os.IsNotExist(err) {
+		t.Error("expected no track for skipped genes")
+	}
+}
+
+// TestSimulationAResume resumes a run killed after its first gene, with bytes written after the checkpoint,
+// and expects the outputs of an uninterrupted run
+func TestSimulationAResume(t *testing.T) {
+	dir := t.TempDir()
+	infile := writeTestFasta(t, dir)
+	full := &config.Config{InfileName: infile, OutfileName: filepath.Join(dir, "full", "run"), Threads: 2}
+	if err := SimulationA(context.Background(), full); err != nil {
+		t.Fatalf("SimulationA returned error: %v", err)
+	}
+
+	// the outputs of the first gene alone are where a run of both stood after writing it
+	first := filepath.Join(dir, "first.fa")
+	if err := os.WriteFile(first, []byte(">first range=chr1:1-14 strand=+\nGATTACAGGGCCCA\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "resumed", "run")
+	if err := SimulationA(context.Background(), &config.Config{InfileName: first, OutfileName: out, Threads: 2}); err != nil {
+		t.Fatalf("SimulationA returned error: %v", err)
+	}
+	os.Remove(out + "_manifest.json")
+	cfg := &config.Config{InfileName: infile, OutfileName: out, Threads: 2}
+	checkpoint, err := newCheckpoint(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint.Genes = []GeneTiming{{Name: "first", Length: 14}}
+	entries, _ := os.ReadDir(filepath.Dir(out))
+	for _, e := range entries {
+		path := filepath.Join(filepath.Dir(out), e.Name())
+		info, _ := e.Info()
+		checkpoint.Outputs[e.Name()] = info.Size()
+		if err := os.Rename(path, path+partialSuffix); err != nil {
+			t.Fatal(err)
+		}
+		f, _ := os.OpenFile(path+partialSuffix, os.O_APPEND|os.O_WRONLY, 0)
+		f.WriteString("written after the checkpoint\n")
+		f.Close()
+	}
+	if err := checkpoint.write(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Resume = true
+	if err := SimulationA(context.Background(), cfg); err != nil {
+		t.Fatalf("resumed SimulationA returned error: %v", err)
+	}
+	for name := range checkpoint.Outputs {
+		want, _ := os.ReadFile(filepath.Join(dir, "full", name))
+		got, err := os.ReadFile(filepath.Join(dir, "resumed", name))
+		if err != nil {
+			t.Errorf("resumed run did not write %s: %v", name, err)
+		} else if string(got) != string(want) {
+			t.Errorf("resumed %s differs from an uninterrupted run:\n%s\nwant:\n%s", name, got, want)
+		}
+	}
+	if _, err := os.Stat(checkpointPath(cfg)); !os.IsNotExist(err) {
+		t.Error("expected the checkpoint to be removed once the run finished")
+	}
+	b, err := os.ReadFile(out + "_manifest.json")
+	if err != nil {
+		t.Fatalf("manifest was not written: %v", err)
+	}
+	var manifest RunManifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Genes) != 2 || manifest.Genes[0].Name != "first" || manifest.Genes[1].Name != "second" {
+		t.Errorf("expected the manifest to record both genes, got %+v", manifest.Genes)
+	}
+}
+
+func TestSimulationAKeepsOutputsForResume(t *testing.T) {
+	dir := t.TempDir()
+	// the third record has no sequence, which stops the run once the first two are written
+	infile := filepath.Join(dir, "genes.fa")
+	fasta := ">first range=chr1:1-14 strand=+\nGATTACAGGGCCCA\n>second range=chr1:101-110 strand=+\nGGGCCCATTA\n>third range=chr1:201-210 strand=+\n"
+	if err := os.WriteFile(infile, []byte(fasta), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out", "run")
+	cfg := &config.Config{InfileName: infile, OutfileName: out}
+	err := SimulationA(context.Background(), cfg)
+	if err == nil || !strings.Contains(err.Error(), "--resume") {
+		t.Fatalf("expected the error to suggest --resume, got %v", err)
+	}
+	if _, err := os.Stat(out + "_bpprob.wig" + partialSuffix); err != nil {
+		t.Errorf("expected the partial track to be kept: %v", err)
+	}
+	checkpoint, err := readCheckpoint(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checkpoint.Genes) != 2 || checkpoint.Outputs["run_bpprob.wig"] == 0 {
+		t.Errorf("expected a checkpoint of both genes, got %+v", checkpoint)
+	}
+
+	sigma := -0.05
+	changed := &config.Config{InfileName: infile, OutfileName: out, SuperhelicalDensity: &sigma, Resume: true}
+	err = SimulationA(context.Background(), changed)
+	if err == nil || !strings.Contains(err.Error(), "SuperhelicalDensity") {
+		t.Errorf("expected resuming with another sigma to fail naming it, got %v", err)
+	}
+	if _, err := os.Stat(out + "_bpprob.wig" + partialSuffix); err != nil {
+		t.Errorf("expected a refused resume to leave the partial track: %v", err)
+	}
+
+	bigWig := &config.Config{InfileName: infile, OutfileName: out, Format: FormatBigWig, Resume: true}
+	if err := bigWig.Validate(ConfigChecks()...); err == nil || !strings.Contains(err.Error(), "--resume") {
+		t.Errorf("expected --resume with bigWig output to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package sim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"golooper/config"
+	"golooper/rlooper"
+)
+
+// samplerParamsFromConfig returns the sampler defaults with the values set in config applied on top
+func samplerParamsFromConfig(config *config.Config) rlooper.SamplerParams {
+	params := rlooper.NewSamplerParamsReasonableDefaults()
+	params.Steps = config.SampleSteps
+	params.BurnIn = config.SampleBurnIn
+	params.Seed = config.Seed
+	if config.MaxLoops != nil {
+		params.MaxLoops = *config.MaxLoops
+	}
+	return params
+}
+
+// checkSampleSteps returns an error when --sample-steps is too few to split into the batches the
+// confidence intervals are estimated from
+func checkSampleSteps(config *config.Config) error {
+	batches := rlooper.NewSamplerParamsReasonableDefaults().Batches
+	if config.SampleSteps > 0 && config.SampleSteps < batches {
+		return fmt.Errorf("must be at least %d, one step for each batch of the confidence intervals, got %d", batches, config.SampleSteps)
+	}
+	return nil
+}
+
+// writeSampledProbabilities writes per-base probabilities estimated by the sampler with their 95%
+// confidence intervals, with positions relative to the start of the gene sequence
+func writeSampledProbabilities(w io.Writer, gene *rlooper.Gene, result *rlooper.SampledResult) error {
+	buf := bufio.NewWriter(w)
+	for i, p := range result.BpProbability {
+		fmt.Fprintf(buf, "%s\t%s\t%d\t%g\t%g\t%g\n", gene.GeneName, gene.Pos.Chromosome, i, p, result.ConfidenceLow[i], result.ConfidenceHigh[i])
+	}
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("error writing sampled probabilities: %v", err)
+	}
+	return nil
+}
+
+// printSamplingSummary reports chain statistics and the sampled distribution of loop counts
+func printSamplingSummary(gene *rlooper.Gene, result *rlooper.SampledResult) {
+	fmt.Printf("Sampled ensemble for %s (%d chains):\n", gene.GeneName, result.Chains)
+	fmt.Printf("  acceptance rate = %.3f\n", result.AcceptanceRate)
+	for k, p := range result.LoopCountProbability {
+		fmt.Printf("  P(%d loops) = %.4g\n", k, p)
+	}
+}
@@ -0,0 +1,133 @@
+package sim
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"golooper/rlooper"
+)
+
+// geneThreadLength is the sequence length per thread a gene is given when others are waiting: promoters and
+// other short sequences run one per thread, side by side, while long genes split their windows between
+// threads
+const geneThreadLength = 2000
+
+// geneJob is one gene of the input on its way through scheduleGenes, with the results compute stores for
+// write
+type geneJob struct {
+	index   int // position of the gene in the input
+	gene    *rlooper.Gene
+	ec      *rlooper.ExecutionContext // threads reserved for the gene while it is computed
+	threads int
+	started time.Time
+
+	model         rlooper.ModelParams
+	wp            rlooper.WindowParams
+	motifs        []rlooper.G4
+	sampled       *rlooper.SampledResult
+	multiLoop     *rlooper.MultiLoopResult
+	probabilities []float64
+	energies      *rlooper.EnergyTracks
+	top           []rlooper.Structure
+	z             float64
+	timedOut      bool // the gene was stopped by --gene-timeout and has no results
+}
+
+// geneThreads returns how many of total threads a gene gets: all of them for the last gene, so that a
+// single gene keeps every thread busy, otherwise one per geneThreadLength bases
+func geneThreads(gene *rlooper.Gene, total int, last bool) int {
+	if last {
+		return total
+	}
+	return min(max(len(gene.Sequence)/geneThreadLength, 1), total)
+}
+
+// scheduleGenes runs compute on every gene returned by next, up to io.EOF, and then write on the results in
+// input order. genes are computed side by side on threads reserved from ec, a gene waiting until the
+// threads it asks for through threads are free. at most twice as many genes as ec has threads are read
+// ahead of the last written, which bounds the memory held by results waiting on a slower gene before them.
+// write is called from the calling goroutine. the first error, or the cancellation of ec.Context, stops
+// reading and is returned once the genes already started have finished.
+func scheduleGenes(ec *rlooper.ExecutionContext, next func() (*rlooper.Gene, error), threads func(gene *rlooper.Gene, last bool) int, compute func(job *geneJob) error, write func(job *geneJob) error) error {
+	type finishedJob struct {
+		job *geneJob
+		err error
+	}
+	slots := make(chan struct{}, 2*max(ec.NumThreads, 1))
+	done := make(chan struct{})
+	finished := make(chan finishedJob)
+	var dispatchErr error // error reading the input or reserving threads
+
+	go func() {
+		var workers sync.WaitGroup
+		defer func() {
+			workers.Wait()
+			close(finished)
+		}()
+		gene, err := next()
+		for index := 0; err == nil; index++ {
+			// read one gene ahead to know whether this one is the last
+			following, followingErr := next()
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if dispatchErr = ec.Err(); dispatchErr != nil {
+				return
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			job := &geneJob{index: index, gene: gene, started: time.Now()}
+			if job.ec, dispatchErr = ec.Reserve(threads(gene, followingErr != nil)); dispatchErr != nil {
+				return
+			}
+			job.threads = job.ec.NumThreads
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				err := compute(job)
+				job.ec.Release()
+				finished <- finishedJob{job, err}
+			}()
+			gene, err = following, followingErr
+		}
+		if err != io.EOF {
+			dispatchErr = err
+		}
+	}()
+
+	// results arrive in the order genes finish and are written in the order they were read
+	pending := make(map[int]finishedJob)
+	nextIndex := 0
+	var firstErr error
+	for f := range finished {
+		pending[f.job.index] = f
+		for {
+			f, ok := pending[nextIndex]
+			if !ok {
+				break
+			}
+			delete(pending, nextIndex)
+			nextIndex++
+			if firstErr == nil {
+				firstErr = f.err
+				if firstErr == nil {
+					firstErr = write(f.job)
+				}
+				if firstErr != nil {
+					close(done)
+				}
+			}
+			<-slots
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	return dispatchErr
+}
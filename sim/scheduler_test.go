@@ -0,0 +1,86 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golooper/rlooper"
+)
+
+// geneSource returns a next function for scheduleGenes over n genes of the given length
+func geneSource(n, length int) func() (*rlooper.Gene, error) {
+	i := 0
+	return func() (*rlooper.Gene, error) {
+		if i == n {
+			return nil, io.EOF
+		}
+		i++
+		return &rlooper.Gene{GeneName: fmt.Sprint("gene", i-1), Sequence: make([]rune, length)}, nil
+	}
+}
+
+func TestScheduleGenes(t *testing.T) {
+	ec := &rlooper.ExecutionContext{NumThreads: 3, WaitGroup: &sync.WaitGroup{}}
+	var inUse, peak atomic.Int64
+	var written []string
+	var lastThreads int
+	err := scheduleGenes(ec, geneSource(20, 100),
+		func(gene *rlooper.Gene, last bool) int { return geneThreads(gene, ec.NumThreads, last) },
+		func(job *geneJob) error {
+			n := inUse.Add(int64(job.ec.NumThreads))
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			// later genes finish first
+			time.Sleep(time.Duration(20-job.index) * 100 * time.Microsecond)
+			inUse.Add(-int64(job.ec.NumThreads))
+			return nil
+		},
+		func(job *geneJob) error {
+			written = append(written, job.gene.GeneName)
+			lastThreads = job.threads
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("scheduleGenes returned error: %v", err)
+	}
+	for i, name := range written {
+		if name != fmt.Sprint("gene", i) {
+			t.Fatalf("expected genes to be written in input order, got %v", written)
+		}
+	}
+	if len(written) != 20 {
+		t.Errorf("expected 20 genes, got %d", len(written))
+	}
+	if peak.Load() > 3 {
+		t.Errorf("expected at most 3 threads in use, got %d", peak.Load())
+	}
+	if lastThreads != 3 {
+		t.Errorf("expected the last gene to get every thread, got %d", lastThreads)
+	}
+
+	failure := errors.New("failed")
+	written = nil
+	err = scheduleGenes(ec, geneSource(50, 100),
+		func(gene *rlooper.Gene, last bool) int { return 1 },
+		func(job *geneJob) error {
+			if job.index == 5 {
+				return failure
+			}
+			return nil
+		},
+		func(job *geneJob) error {
+			written = append(written, job.gene.GeneName)
+			return nil
+		})
+	if err != failure {
+		t.Errorf("expected the compute error, got %v", err)
+	}
+	if len(written) != 5 {
+		t.Errorf("expected the genes before the failure to be written, got %v", written)
+	}
+}
@@ -0,0 +1,144 @@
+package sim
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+
+	"golooper/config"
+)
+
+// superhelical density convention: sigma is the fractional change in linking number relative to relaxed
+// DNA. negative values are underwound (negatively supercoiled) DNA, which drives R-loop formation, so a
+// plasmid at -7% is --sigma=-0.07. positive values are overwound DNA, which suppresses R-loops.
+const (
+	// maxSuperhelicalDensity bounds |sigma|; anything larger is almost certainly a percentage
+	maxSuperhelicalDensity = 1.0
+	// typicalSuperhelicalDensity bounds the |sigma| seen in vivo
+	typicalSuperhelicalDensity = 0.2
+)
+
+// checkSuperhelicalDensity returns an error for a sigma that cannot be a fraction, and a warning for one
+// that is valid but likely has its sign or scale wrong
+func checkSuperhelicalDensity(sigma float64) (string, error) {
+	if math.IsNaN(sigma) || math.IsInf(sigma, 0) {
+		return "", fmt.Errorf("superhelical density must be a finite number, got %v", sigma)
+	}
+	if math.Abs(sigma) >= maxSuperhelicalDensity {
+		return "", fmt.Errorf("superhelical density %g is not a fraction between -1 and 1; sigma is not a percentage, write -7%% as --sigma=-0.07", sigma)
+	}
+	if math.Abs(sigma) > typicalSuperhelicalDensity {
+		return fmt.Sprintf("superhelical density %g is far outside the physiological range of about -0.1 to 0.05", sigma), nil
+	}
+	if sigma > 0 {
+		return fmt.Sprintf("superhelical density %g is positive (overwound DNA), which suppresses R-loops; negatively supercoiled DNA such as a plasmid is --sigma=%g", sigma, -sigma), nil
+	}
+	return "", nil
+}
+
+// checkSigma returns an error for a sigma set in config that cannot be a fraction
+func checkSigma(config *config.Config) error {
+	if config.SuperhelicalDensity == nil {
+		return nil
+	}
+	_, err := checkSuperhelicalDensity(*config.SuperhelicalDensity)
+	return err
+}
+
+// warnSuperhelicalDensity logs a warning if the sign or scale of the sigma set in config looks wrong
+func warnSuperhelicalDensity(config *config.Config) {
+	if config.SuperhelicalDensity == nil {
+		return
+	}
+	if warning, _ := checkSuperhelicalDensity(*config.SuperhelicalDensity); warning != "" {
+		log.Println("WARN:", warning)
+	}
+}
+
+// SigmaPoint summarizes the single-loop ensemble of one input record at one superhelical density
+type SigmaPoint struct {
+	Gene              string
+	Sigma             float64
+	LoopProbability   float64 // probability that any loop is present
+	MeanBpProbability float64
+	MaxBpProbability  float64
+}
+
+// SigmaScan computes the single-loop ensemble of each input record at each superhelical density, with every
+// other parameter taken from config. points are in input order, then in the order of sigmas. canceling ctx
+// stops the scan.
+func SigmaScan(ctx context.Context, config *config.Config, sigmas []float64) ([]SigmaPoint, error) {
+	var points []SigmaPoint
+	err := sweepEnsembles(ctx, config, SweepParams{Sigmas: sigmas}, func(swept sweptGene) error {
+		for i, c := range swept.conditions {
+			summary := summarizeEnsemble(swept.results[i])
+			points = append(points, SigmaPoint{
+				Gene:              swept.gene.GeneName,
+				Sigma:             c.Sigma,
+				LoopProbability:   summary.loopProbability,
+				MeanBpProbability: summary.meanBpProbability,
+				MaxBpProbability:  summary.maxBpProbability,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// WriteSigmaScan writes the scan as a table, followed for each record by a comparison of each negative
+// density against the positive density of the same magnitude so it is clear how probability flips around
+// relaxed DNA
+func WriteSigmaScan(w io.Writer, points []SigmaPoint) error {
+	if _, err := fmt.Fprintf(w, "gene\tsigma\tloop_probability\tmean_bpprob\tmax_bpprob\n"); err != nil {
+		return err
+	}
+	for _, p := range points {
+		if _, err := fmt.Fprintf(w, "%s\t%g\t%.6g\t%.6g\t%.6g\n", p.Gene, p.Sigma, p.LoopProbability, p.MeanBpProbability, p.MaxBpProbability); err != nil {
+			return err
+		}
+	}
+
+	for start := 0; start < len(points); {
+		end := start + 1
+		for end < len(points) && points[end].Gene == points[start].Gene {
+			end++
+		}
+		if err := writeSigmaComparison(w, points[start:end]); err != nil {
+			return err
+		}
+		start = end
+	}
+	return nil
+}
+
+// writeSigmaComparison writes the comparison around relaxed DNA of the points of one record
+func writeSigmaComparison(w io.Writer, points []SigmaPoint) error {
+	gene := points[0].Gene
+	bySigma := make(map[float64]SigmaPoint, len(points))
+	for _, p := range points {
+		bySigma[p.Sigma] = p
+	}
+	if relaxed, ok := bySigma[0]; ok {
+		if _, err := fmt.Fprintf(w, "# %s relaxed (sigma=0): P(loop) = %.4g\n", gene, relaxed.LoopProbability); err != nil {
+			return err
+		}
+	}
+	for _, p := range points {
+		if p.Sigma >= 0 {
+			continue
+		}
+		mirror, ok := bySigma[-p.Sigma]
+		if !ok {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "# %s |sigma|=%g: P(loop) underwound %.4g vs overwound %.4g\n", gene, -p.Sigma, p.LoopProbability, mirror.LoopProbability); err != nil {
+			return err
+		}
+	}
+	return nil
+}
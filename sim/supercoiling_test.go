@@ -0,0 +1,62 @@
+package sim
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCheckSuperhelicalDensity(t *testing.T) {
+	tests := []struct {
+		sigma   float64
+		warns   bool
+		invalid bool
+	}{
+		{sigma: -0.07},
+		{sigma: 0},
+		{sigma: 0.07, warns: true},
+		{sigma: -0.5, warns: true},
+		{sigma: -7, invalid: true},
+		{sigma: 7, invalid: true},
+		{sigma: math.NaN(), invalid: true},
+	}
+	for _, tt := range tests {
+		warning, err := checkSuperhelicalDensity(tt.sigma)
+		if (err != nil) != tt.invalid {
+			t.Errorf("sigma=%g: expected invalid=%v, got error %v", tt.sigma, tt.invalid, err)
+		}
+		if (warning != "") != tt.warns {
+			t.Errorf("sigma=%g: expected warning=%v, got %q", tt.sigma, tt.warns, warning)
+		}
+	}
+}
+
+func TestWriteSigmaScan(t *testing.T) {
+	points := []SigmaPoint{
+		{Gene: "first", Sigma: -0.02, LoopProbability: 0.3},
+		{Gene: "first", Sigma: 0, LoopProbability: 0.002},
+		{Gene: "first", Sigma: 0.02, LoopProbability: 0.0004},
+		{Gene: "second", Sigma: -0.02, LoopProbability: 0.1},
+		{Gene: "second", Sigma: 0.02, LoopProbability: 0.05},
+	}
+	var buf bytes.Buffer
+	if err := WriteSigmaScan(&buf, points); err != nil {
+		t.Fatalf("WriteSigmaScan returned error: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"gene\tsigma\tloop_probability\tmean_bpprob\tmax_bpprob\n",
+		"second\t-0.02\t0.1\t0\t0\n",
+		"# first relaxed (sigma=0): P(loop) = 0.002\n",
+		"# first |sigma|=0.02: P(loop) underwound 0.3 vs overwound 0.0004\n",
+		"# second |sigma|=0.02: P(loop) underwound 0.1 vs overwound 0.05\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "# second relaxed") {
+		t.Errorf("expected no relaxed line for a record scanned without sigma=0, got:\n%s", out)
+	}
+}
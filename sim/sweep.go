@@ -0,0 +1,310 @@
+package sim
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golooper/config"
+	"golooper/rlooper"
+)
+
+// SweepParams holds the values of each parameter to sweep. an empty list keeps the value from the config,
+// or the model default if the config leaves it unset.
+type SweepParams struct {
+	Sigmas       []float64
+	Ns           []float64
+	Nucleations  []float64
+	Temperatures []float64
+}
+
+// ValueRange returns the values from..to inclusive in increments of step
+func ValueRange(from, to, step float64) ([]float64, error) {
+	if step <= 0 {
+		return nil, fmt.Errorf("step must be positive, got %g", step)
+	}
+	if to < from {
+		return nil, fmt.Errorf("range is empty: %g to %g", from, to)
+	}
+	count := int(math.Floor((to-from)/step+1e-9)) + 1
+	values := make([]float64, count)
+	for i := range values {
+		// round away accumulated error so that, e.g., relaxed DNA lands on exactly 0
+		values[i] = math.Round((from+float64(i)*step)*1e9) / 1e9
+	}
+	return values, nil
+}
+
+// ParseSweepValues parses a comma separated list of values, or a range written FROM:TO:STEP
+func ParseSweepValues(spec string) ([]float64, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	if parts := strings.Split(spec, ":"); len(parts) == 3 {
+		bounds := make([]float64, 3)
+		for i, part := range parts {
+			value, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid sweep range %q: %v", spec, err)
+			}
+			bounds[i] = value
+		}
+		values, err := ValueRange(bounds[0], bounds[1], bounds[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid sweep range %q: %v", spec, err)
+		}
+		return values, nil
+	}
+	var values []float64
+	for _, part := range strings.Split(spec, ",") {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sweep values %q: %v", spec, err)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// sweepConditions returns every combination of the swept values, varying sigma fastest, with unswept
+// parameters taken from model
+func sweepConditions(model *rlooper.ModelParams, params SweepParams) ([]rlooper.SweepCondition, error) {
+	orDefault := func(values []float64, value float64) []float64 {
+		if len(values) == 0 {
+			return []float64{value}
+		}
+		return values
+	}
+	sigmas := orDefault(params.Sigmas, model.Sigma())
+	ns := orDefault(params.Ns, model.N)
+	nucleations := orDefault(params.Nucleations, model.NucleationFreeEnergy())
+	temperatures := orDefault(params.Temperatures, model.T)
+
+	for _, sigma := range sigmas {
+		if _, err := checkSuperhelicalDensity(sigma); err != nil {
+			return nil, err
+		}
+	}
+	for _, n := range ns {
+		if n <= 0 {
+			return nil, fmt.Errorf("superhelicity domain must be positive, got %g", n)
+		}
+	}
+	for _, t := range temperatures {
+		if t <= 0 {
+			return nil, fmt.Errorf("temperature must be positive in Kelvin, got %g", t)
+		}
+	}
+
+	var conditions []rlooper.SweepCondition
+	for _, t := range temperatures {
+		for _, a := range nucleations {
+			for _, n := range ns {
+				for _, sigma := range sigmas {
+					conditions = append(conditions, rlooper.SweepCondition{Sigma: sigma, N: n, Nucleation: a, T: t})
+				}
+			}
+		}
+	}
+	return conditions, nil
+}
+
+// ensembleSummary condenses an ensemble to the probability of any loop and the mean and largest per-base
+// probability
+type ensembleSummary struct {
+	loopProbability   float64
+	meanBpProbability float64
+	maxBpProbability  float64
+}
+
+func summarizeEnsemble(result *rlooper.EnsembleResult) ensembleSummary {
+	summary := ensembleSummary{loopProbability: result.LoopProbability()}
+	for _, p := range result.BpProbability {
+		summary.meanBpProbability += p
+		summary.maxBpProbability = math.Max(summary.maxBpProbability, p)
+	}
+	if len(result.BpProbability) > 0 {
+		summary.meanBpProbability /= float64(len(result.BpProbability))
+	}
+	return summary
+}
+
+// checkSweepConfig rejects the settings that choose an ensemble other than the single-loop one a sweep
+// computes. --engine needs no check: a sweep shares the sequence energies across conditions as the dp engine
+// does, and both engines give the same single-loop ensemble.
+func checkSweepConfig(config *config.Config) error {
+	if config.SampleSteps > 0 {
+		return fmt.Errorf("--sample-steps is not supported: a sweep computes the exact single-loop ensemble")
+	}
+	if config.MaxLoops != nil && *config.MaxLoops > 1 {
+		return fmt.Errorf("--max-loops above 1 is not supported: a sweep computes the single-loop ensemble")
+	}
+	return nil
+}
+
+// sweptGene holds the ensembles of one input record under every condition of a sweep, in the order of
+// conditions
+type sweptGene struct {
+	gene       *rlooper.Gene
+	conditions []rlooper.SweepCondition
+	results    []*rlooper.EnsembleResult
+	started    time.Time
+}
+
+// sweepEnsembles computes the ensemble of each input record under every condition of params, handing the
+// records to fn in input order. the conditions are those of each record's model, so --N auto gives each
+// record its own domain size.
+func sweepEnsembles(ctx context.Context, config *config.Config, params SweepParams, fn func(swept sweptGene) error) error {
+	if err := checkSweepConfig(config); err != nil {
+		return err
+	}
+	infile, err := os.Open(config.InfileName)
+	if err != nil {
+		return fmt.Errorf("error opening input file: %v", err)
+	}
+	defer infile.Close()
+
+	annotations, err := readAnnotations(config)
+	if err != nil {
+		return err
+	}
+	ec := newExecutionContext(ctx, config)
+	reader := rlooper.NewFastaReader(infile)
+	genes := 0
+	for {
+		gene, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading input file: %v", err)
+		}
+		started := time.Now()
+		wp, err := windowParamsFromConfig(config, annotations, gene)
+		if err != nil {
+			return err
+		}
+		model := modelFromConfig(config, gene)
+		conditions, err := sweepConditions(&model, params)
+		if err != nil {
+			return err
+		}
+		results, err := gene.SweepEnsembles(ec, &model, wp, conditions)
+		if err != nil {
+			return fmt.Errorf("error running sweep of %s: %v", gene.GeneName, err)
+		}
+		genes++
+		if err := fn(sweptGene{gene: gene, conditions: conditions, results: results, started: started}); err != nil {
+			return err
+		}
+	}
+	if genes == 0 {
+		return fmt.Errorf("input file %s has no FASTA records", config.InfileName)
+	}
+	return nil
+}
+
+// sweepTrackPath returns the per-base probability track written for condition i of a sweep
+func sweepTrackPath(config *config.Config, i int) string {
+	return fmt.Sprintf("%s_sweep_%03d_bpprob.wig", outputBasePath(config), i)
+}
+
+// sweepTrackName names the track of a sweep condition. with --N auto and N not swept each record has its
+// own domain size, so the name says auto rather than giving the first record's.
+func sweepTrackName(c rlooper.SweepCondition, autoN bool) string {
+	n := strconv.FormatFloat(c.N, 'g', -1, 64)
+	if autoN {
+		n = "auto"
+	}
+	return fmt.Sprintf("Base Pair Probability sigma=%g N=%s a=%g T=%g", c.Sigma, n, c.Nucleation, c.T)
+}
+
+// Sweep computes the single-loop ensemble of each input record over every combination of params. it writes
+// a tidy table with one row per record and condition to _sweep.tsv and the per-base probabilities of every
+// record under condition i to _sweep_<i>_bpprob.wig, and a run manifest without a model, as each condition
+// has its own. the outputs are written whole or not at all. canceling ctx stops the sweep.
+func Sweep(ctx context.Context, config *config.Config, params SweepParams) error {
+	manifest := newRunManifest(config, threadCount(config))
+	if err := manifest.addInputs(config); err != nil {
+		return err
+	}
+
+	var files []*outputFile
+	abort := func() {
+		for _, file := range files {
+			file.Abort()
+		}
+	}
+	path := outputBasePath(config) + "_sweep.tsv"
+	table, err := createFileWithDir(path)
+	if err != nil {
+		return fmt.Errorf("error creating sweep table at %s: %v", path, err)
+	}
+	files = append(files, table)
+	buf := bufio.NewWriter(table)
+	fmt.Fprintf(buf, "gene\tcondition\tsigma\tN\ta\tT\tloop_probability\tmean_bpprob\tmax_bpprob\ttrack\n")
+
+	// every record is added to the same track of each condition, opened with the first record
+	var tracks []*WigWriter
+	autoN := config.AutoDomainSize && len(params.Ns) == 0
+	err = sweepEnsembles(ctx, config, params, func(swept sweptGene) error {
+		gene := swept.gene
+		if tracks == nil {
+			for i, c := range swept.conditions {
+				trackPath := sweepTrackPath(config, i)
+				file, err := createFileWithDir(trackPath)
+				if err != nil {
+					return fmt.Errorf("error creating sweep track at %s: %v", trackPath, err)
+				}
+				files = append(files, file)
+				wig := NewWigWriter(file, config.WigSpan)
+				if err := wig.WriteTrackLine(trackAttributes(config, sweepTrackName(c, autoN))); err != nil {
+					return fmt.Errorf("error writing sweep track: %v", err)
+				}
+				tracks = append(tracks, wig)
+			}
+		}
+		for i, c := range swept.conditions {
+			summary := summarizeEnsemble(swept.results[i])
+			fmt.Fprintf(buf, "%s\t%d\t%g\t%g\t%g\t%g\t%.6g\t%.6g\t%.6g\t%s\n", gene.GeneName, i, c.Sigma, c.N, c.Nucleation, c.T,
+				summary.loopProbability, summary.meanBpProbability, summary.maxBpProbability, filepath.Base(sweepTrackPath(config, i)))
+			if err := tracks[i].WriteValues(chromName(gene), genomicOffset(gene), swept.results[i].BpProbability); err != nil {
+				return fmt.Errorf("error writing sweep track: %v", err)
+			}
+		}
+		manifest.addGene(gene, swept.started, threadCount(config))
+		return nil
+	})
+	if err == nil {
+		if err = buf.Flush(); err != nil {
+			err = fmt.Errorf("error writing sweep table: %v", err)
+		}
+	}
+	if err != nil {
+		abort()
+		return err
+	}
+	for _, file := range files {
+		if err := file.finish(); err != nil {
+			abort()
+			return fmt.Errorf("error closing %s: %v", file.path, err)
+		}
+	}
+	for i, file := range files {
+		if err := file.commit(); err != nil {
+			for _, done := range files[:i] {
+				os.Remove(done.path)
+			}
+			abort()
+			return fmt.Errorf("error moving %s into place: %v", file.path, err)
+		}
+	}
+	return manifest.write(config)
+}
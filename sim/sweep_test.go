@@ -0,0 +1,152 @@
+package sim
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golooper/config"
+	"golooper/rlooper"
+)
+
+func TestValueRange(t *testing.T) {
+	values, err := ValueRange(-0.03, 0.03, 0.01)
+	if err != nil {
+		t.Fatalf("ValueRange returned error: %v", err)
+	}
+	expected := []float64{-0.03, -0.02, -0.01, 0, 0.01, 0.02, 0.03}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %v, got %v", expected, values)
+	}
+
+	if _, err := ValueRange(0, 0.1, 0); err == nil {
+		t.Error("expected an error for a zero step")
+	}
+	if _, err := ValueRange(0.1, 0, 0.01); err == nil {
+		t.Error("expected an error for an empty range")
+	}
+}
+
+func TestParseSweepValues(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected []float64
+		wantErr  bool
+	}{
+		{spec: "", expected: nil},
+		{spec: "310", expected: []float64{310}},
+		{spec: "1000, 1500,3000", expected: []float64{1000, 1500, 3000}},
+		{spec: "-0.02:0:0.01", expected: []float64{-0.02, -0.01, 0}},
+		{spec: "8:10", wantErr: true},
+		{spec: "1,x", wantErr: true},
+		{spec: "0:1:-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		values, err := ParseSweepValues(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: expected error=%v, got %v", tt.spec, tt.wantErr, err)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(values, tt.expected) {
+			t.Errorf("%q: expected %v, got %v", tt.spec, tt.expected, values)
+		}
+	}
+}
+
+func TestSweepConditions(t *testing.T) {
+	model := rlooper.NewParamsReasonableDefaults()
+	conditions, err := sweepConditions(&model, SweepParams{Sigmas: []float64{-0.07, 0}, Ns: []float64{1000, 2000}})
+	if err != nil {
+		t.Fatalf("sweepConditions returned error: %v", err)
+	}
+	expected := []rlooper.SweepCondition{
+		{Sigma: -0.07, N: 1000, Nucleation: 10, T: 310},
+		{Sigma: 0, N: 1000, Nucleation: 10, T: 310},
+		{Sigma: -0.07, N: 2000, Nucleation: 10, T: 310},
+		{Sigma: 0, N: 2000, Nucleation: 10, T: 310},
+	}
+	if !reflect.DeepEqual(conditions, expected) {
+		t.Errorf("expected %v, got %v", expected, conditions)
+	}
+
+	if _, err := sweepConditions(&model, SweepParams{Sigmas: []float64{7}}); err == nil {
+		t.Error("expected an error for a percentage sigma")
+	}
+	if _, err := sweepConditions(&model, SweepParams{Temperatures: []float64{0}}); err == nil {
+		t.Error("expected an error for a zero temperature")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.fa")
+	if err := os.WriteFile(input, []byte(">first range=chr1:1-7\nGATTACA\n>second range=chr2:11-20\nGGGCTTAGCC\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testConfig := &config.Config{
+		InfileName:  input,
+		OutfileName: filepath.Join(dir, "sweep"),
+	}
+
+	params := SweepParams{Sigmas: []float64{-0.07, 0, 0.07}, Temperatures: []float64{300, 310}}
+	if err := Sweep(context.Background(), testConfig, params); err != nil {
+		t.Fatalf("Sweep returned error: %v", err)
+	}
+
+	table, err := os.ReadFile(testConfig.OutfileName + "_sweep.tsv")
+	if err != nil {
+		t.Fatalf("Failed to read sweep table: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(table)), "\n")
+	if len(lines) != 13 {
+		t.Fatalf("expected a header and 6 conditions for each of 2 records, got %d lines", len(lines))
+	}
+	if !strings.HasPrefix(lines[1], "first\t0\t-0.07\t1500\t10\t300\t") || !strings.HasSuffix(lines[1], "\tsweep_sweep_000_bpprob.wig") {
+		t.Errorf("unexpected first condition: %q", lines[1])
+	}
+	if !strings.HasPrefix(lines[7], "second\t0\t-0.07\t") {
+		t.Errorf("unexpected first condition of the second record: %q", lines[7])
+	}
+
+	for i := 0; i < 6; i++ {
+		track, err := os.ReadFile(sweepTrackPath(testConfig, i))
+		if err != nil {
+			t.Fatalf("Failed to read track for condition %d: %v", i, err)
+		}
+		// a track line, then a fixedStep declaration and one value per base for each record
+		if count := strings.Count(string(track), "\n"); count != 1+len("GATTACA")+1+len("GGGCTTAGCC")+1 {
+			t.Errorf("condition %d: expected one value per base of each record, got %d lines", i, count)
+		}
+		if !strings.Contains(string(track), "chrom=chr2 start=11") {
+			t.Errorf("condition %d: expected the second record at chr2:11, got:\n%s", i, track)
+		}
+	}
+}
+
+func TestSweepRejectsOtherEnsembles(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.fa")
+	if err := os.WriteFile(input, []byte(">first\nGATTACA\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	two := 2
+	for _, tt := range []struct {
+		name   string
+		config config.Config
+	}{
+		{"sampling", config.Config{SampleSteps: 100}},
+		{"multi-loop", config.Config{MaxLoops: &two}},
+	} {
+		tt.config.InfileName = input
+		tt.config.OutfileName = filepath.Join(dir, tt.name)
+		if err := Sweep(context.Background(), &tt.config, SweepParams{}); err == nil {
+			t.Errorf("%s: expected the sweep to be rejected", tt.name)
+		}
+		if _, err := os.Stat(tt.config.OutfileName + "_sweep.tsv"); err == nil {
+			t.Errorf("%s: expected no sweep table", tt.name)
+		}
+	}
+}
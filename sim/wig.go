@@ -0,0 +1,197 @@
+package sim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"golooper/config"
+)
+
+// default track line attributes, matching the tracks written before they were configurable
+const (
+	defaultTrackColor    = "50,150,255"
+	defaultTrackPriority = 10
+)
+
+// TrackAttributes are the browser display settings written on a track line
+type TrackAttributes struct {
+	Name        string
+	Description string
+	Color       string // r,g,b
+	ViewLimits  string // lower:upper
+	Priority    int
+}
+
+// trackAttributes returns the attributes of the track called name, with the overrides set in config. a
+// configured name is prefixed so that the tracks of one run stay distinguishable.
+func trackAttributes(config *config.Config, name string) TrackAttributes {
+	attrs := TrackAttributes{
+		Name:        name,
+		Description: config.TrackDescription,
+		Color:       config.TrackColor,
+		ViewLimits:  config.TrackViewLimits,
+		Priority:    defaultTrackPriority,
+	}
+	if config.TrackName != "" {
+		attrs.Name = config.TrackName + " " + name
+	}
+	if attrs.Color == "" {
+		attrs.Color = defaultTrackColor
+	}
+	return attrs
+}
+
+// wigTrackLine returns the track definition line of a wiggle file
+func (a TrackAttributes) wigTrackLine() string {
+	var line strings.Builder
+	fmt.Fprintf(&line, "track type=wiggle_0 name=%q", a.Name)
+	if a.Description != "" {
+		fmt.Fprintf(&line, " description=%q", a.Description)
+	}
+	fmt.Fprintf(&line, " visibility=full autoscale=off color=%s priority=%d", a.Color, a.Priority)
+	if a.ViewLimits != "" {
+		fmt.Fprintf(&line, " viewLimits=%s", a.ViewLimits)
+	}
+	line.WriteString("\n")
+	return line.String()
+}
+
+// wigDeclaration holds the state set by a fixedStep or variableStep declaration line
+type wigDeclaration struct {
+	variable bool
+	chrom    string
+	next     int64 // 1-based position of the next fixedStep value
+	step     int64
+	span     int64
+}
+
+// parseWigDeclaration parses the key=value pairs of a fixedStep or variableStep line
+func parseWigDeclaration(fields []string) (wigDeclaration, error) {
+	d := wigDeclaration{variable: fields[0] == "variableStep", step: 1, span: 1}
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return d, fmt.Errorf("invalid declaration field %q", field)
+		}
+		if key == "chrom" {
+			d.chrom = value
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return d, fmt.Errorf("invalid %s: %v", key, err)
+		}
+		switch key {
+		case "start":
+			d.next = n
+		case "step":
+			d.step = n
+		case "span":
+			d.span = n
+		}
+	}
+	if d.chrom == "" || (!d.variable && d.next == 0) {
+		return d, fmt.Errorf("declaration is missing chrom or start")
+	}
+	return d, nil
+}
+
+// String returns the declaration line, without a newline
+func (d wigDeclaration) String() string {
+	if d.variable {
+		return fmt.Sprintf("variableStep chrom=%s span=%d", d.chrom, d.span)
+	}
+	return fmt.Sprintf("fixedStep chrom=%s start=%d step=%d span=%d", d.chrom, d.next, d.step, d.span)
+}
+
+// WigWriter writes per-base values as wiggle data with declaration lines. with a span above 1, each value
+// is the mean over span bases.
+type WigWriter struct {
+	w    io.Writer
+	span int
+}
+
+func NewWigWriter(w io.Writer, span int) *WigWriter {
+	return &WigWriter{w: w, span: max(span, 1)}
+}
+
+// WriteTrackLine writes the track definition line
+func (ww *WigWriter) WriteTrackLine(attrs TrackAttributes) error {
+	_, err := io.WriteString(ww.w, attrs.wigTrackLine())
+	return err
+}
+
+// bins averages values over consecutive groups of span bases, ignoring NaN. a group with no values is NaN.
+// the last group may be short, in which case its value still covers span bases.
+func (ww *WigWriter) bins(values []float64) []float64 {
+	if ww.span == 1 {
+		return values
+	}
+	bins := make([]float64, 0, (len(values)+ww.span-1)/ww.span)
+	for i := 0; i < len(values); i += ww.span {
+		sum, count := 0.0, 0
+		for _, v := range values[i:min(i+ww.span, len(values))] {
+			if !math.IsNaN(v) {
+				sum += v
+				count++
+			}
+		}
+		if count == 0 {
+			bins = append(bins, math.NaN())
+		} else {
+			bins = append(bins, sum/float64(count))
+		}
+	}
+	return bins
+}
+
+// WriteValues writes values for the bases of chrom starting at the 0-based offset. NaN values are left out;
+// each contiguous run becomes a fixedStep block unless a single variableStep block is smaller, which
+// happens when values are broken into many short runs.
+func (ww *WigWriter) WriteValues(chrom string, offset int64, values []float64) error {
+	bins := ww.bins(values)
+	span := int64(ww.span)
+	position := func(i int) int64 { return offset + int64(i)*span + 1 }
+
+	// compare the bytes each layout spends on declarations and positions
+	var runs [][2]int
+	for i := 0; i < len(bins); i++ {
+		if math.IsNaN(bins[i]) {
+			continue
+		}
+		if len(runs) > 0 && runs[len(runs)-1][1] == i {
+			runs[len(runs)-1][1] = i + 1
+		} else {
+			runs = append(runs, [2]int{i, i + 1})
+		}
+	}
+	fixedCost, variableCost := 0, len(wigDeclaration{variable: true, chrom: chrom, span: span}.String())
+	for _, run := range runs {
+		fixedCost += len(wigDeclaration{chrom: chrom, next: position(run[0]), step: span, span: span}.String()) + 1
+		for i := run[0]; i < run[1]; i++ {
+			variableCost += len(fmt.Sprint(position(i))) + 1
+		}
+	}
+
+	buf := bufio.NewWriter(ww.w)
+	if fixedCost <= variableCost {
+		for _, run := range runs {
+			fmt.Fprintln(buf, wigDeclaration{chrom: chrom, next: position(run[0]), step: span, span: span})
+			for _, v := range bins[run[0]:run[1]] {
+				fmt.Fprintf(buf, "%g\n", v)
+			}
+		}
+	} else if len(runs) > 0 {
+		fmt.Fprintln(buf, wigDeclaration{variable: true, chrom: chrom, span: span})
+		for _, run := range runs {
+			for i := run[0]; i < run[1]; i++ {
+				fmt.Fprintf(buf, "%d\t%g\n", position(i), bins[i])
+			}
+		}
+	}
+	return buf.Flush()
+}
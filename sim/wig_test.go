@@ -0,0 +1,98 @@
+package sim
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golooper/config"
+)
+
+func TestTrackAttributes(t *testing.T) {
+	legacy := "track type=wiggle_0 name=\"Average Energy\" visibility=full autoscale=off color=50,150,255 priority=10\n"
+	if line := trackAttributes(&config.Config{}, "Average Energy").wigTrackLine(); line != legacy {
+		t.Errorf("expected default track line %q, got %q", legacy, line)
+	}
+
+	testConfig := &config.Config{
+		TrackName:        "pfc53",
+		TrackDescription: "sigma -0.07",
+		TrackColor:       "200,0,0",
+		TrackViewLimits:  "0:1",
+	}
+	expected := "track type=wiggle_0 name=\"pfc53 Base Pair Probability\" description=\"sigma -0.07\" visibility=full autoscale=off color=200,0,0 priority=10 viewLimits=0:1\n"
+	if line := trackAttributes(testConfig, "Base Pair Probability").wigTrackLine(); line != expected {
+		t.Errorf("expected %q, got %q", expected, line)
+	}
+}
+
+// writeAndReadWig writes values with a WigWriter and reads them back with readWigRecords
+func writeAndReadWig(t *testing.T, span int, offset int64, values []float64) (string, []bedRecord) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := NewWigWriter(&buf, span).WriteValues("chr1", offset, values); err != nil {
+		t.Fatalf("WriteValues returned error: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "test.wig")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write wig: %v", err)
+	}
+	records, err := readWigRecords(path)
+	if err != nil {
+		t.Fatalf("readWigRecords returned error: %v\n%s", err, buf.String())
+	}
+	return buf.String(), records
+}
+
+func TestWigWriterFixedStep(t *testing.T) {
+	var values []float64
+	for i := 0; i < 25; i++ {
+		switch {
+		case i < 10:
+			values = append(values, 0.5)
+		case i < 15:
+			values = append(values, math.NaN())
+		default:
+			values = append(values, 1)
+		}
+	}
+	out, records := writeAndReadWig(t, 1, 100, values)
+	expected := "fixedStep chrom=chr1 start=101 step=1 span=1\n" + strings.Repeat("0.5\n", 10) +
+		"fixedStep chrom=chr1 start=116 step=1 span=1\n" + strings.Repeat("1\n", 10)
+	if out != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+	if len(records) != 20 {
+		t.Fatalf("expected 20 records, got %d", len(records))
+	}
+	if first, last := records[0], records[19]; first != (bedRecord{chrom: "chr1", start: 100, end: 101, value: 0.5}) ||
+		last != (bedRecord{chrom: "chr1", start: 124, end: 125, value: 1}) {
+		t.Errorf("unexpected records %v", records)
+	}
+}
+
+func TestWigWriterVariableStep(t *testing.T) {
+	nan := math.NaN()
+	values := []float64{1, nan, 2, nan, 3, nan, 4}
+	out, records := writeAndReadWig(t, 1, 0, values)
+	if !strings.HasPrefix(out, "variableStep chrom=chr1 span=1\n1\t1\n3\t2\n") {
+		t.Errorf("expected scattered values to be written as variableStep, got:\n%s", out)
+	}
+	if len(records) != 4 || records[3].start != 6 || records[3].value != 4 {
+		t.Errorf("unexpected records %v", records)
+	}
+}
+
+func TestWigWriterSpan(t *testing.T) {
+	out, records := writeAndReadWig(t, 2, 10, []float64{1, 3, 5, math.NaN(), 2, 2, 2, 2, 2, 2, 2})
+	expected := "fixedStep chrom=chr1 start=11 step=2 span=2\n2\n5\n2\n2\n2\n2\n"
+	if out != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+	if len(records) != 6 || records[1].start != 12 || records[1].end != 14 {
+		t.Errorf("unexpected records %v", records)
+	}
+}